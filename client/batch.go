@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"sync/atomic"
+
+	"my.eth.test/model"
+)
+
+// Call is a single json-rpc call of a batch
+type Call struct {
+	Method string
+	Params interface{}
+}
+
+// Answer is the outcome of a single call of a batch
+type Answer struct {
+	Result json.RawMessage
+	Err    error
+}
+
+// Batcher is implemented by sources that group upstream calls into json-rpc batches
+type Batcher interface {
+	// GetBlocksBy returns blocks like GetBlockBy does, errs[i] is the error of the i-th identifier.
+	// Cached blocks are taken from the cache, the rest are requested in batches
+	GetBlocksBy(ctx context.Context, identifiers []string) ([]*model.Block, []error)
+	// ForwardBatch sends calls as is and returns their answers in the same order
+	ForwardBatch(ctx context.Context, calls []Call) []Answer
+}
+
+var _ Batcher = (*JRClient)(nil)
+
+// GetBlocksBy returns blocks by hex numbers or tags. Only the blocks missed in the cache go upstream
+func (c *JRClient) GetBlocksBy(ctx context.Context, identifiers []string) ([]*model.Block, []error) {
+	blocks := make([]*model.Block, len(identifiers))
+	errs := make([]error, len(identifiers))
+	var calls []Call
+	var order []string
+	missed := make(map[string][]int) // positions of every missed identifier
+	for i, identifier := range identifiers {
+		// tags are resolved like GetBlockBy does, an unresolved 'safe' or 'finalized' one is requested alone
+		switch identifier {
+		case "earliest":
+			identifier = "0x0"
+		case "safe", "finalized":
+			number, ok := c.resolved(identifier)
+			if !ok {
+				blocks[i], errs[i] = c.getTagged(ctx, identifier)
+				continue
+			}
+			identifier = fmt.Sprintf("0x%x", number)
+		}
+		if numID, ok := new(big.Int).SetString(identifier, 0); ok && c.cacheable(ctx, numID) {
+			if cached := c.cached(identifier); cached != nil {
+				blocks[i] = cached
+				continue
+			}
+		}
+		if _, ok := missed[identifier]; !ok {
+			order = append(order, identifier)
+			calls = append(calls, Call{Method: "eth_getBlockByNumber", Params: []interface{}{identifier, true}})
+		}
+		missed[identifier] = append(missed[identifier], i)
+	}
+	if len(calls) == 0 {
+		return blocks, errs
+	}
+	log.Printf("%d of %d blocks not found in cache. requesting ethereum\n", len(calls), len(identifiers))
+	atomic.AddUint64(&c.flight.upstream, uint64(len(calls)))
+	answers := c.batch(ctx, calls)
+	for j, identifier := range order {
+		b, err := c.batchBlock(ctx, identifier, answers[j])
+		for _, i := range missed[identifier] {
+			blocks[i], errs[i] = b, err
+		}
+	}
+	return blocks, errs
+}
+
+// batchBlock decodes a block of a batch and updates the cache like GetBlockBy does
+func (c *JRClient) batchBlock(ctx context.Context, identifier string, answer Answer) (*model.Block, error) {
+	if answer.Err != nil {
+		return nil, answer.Err
+	}
+	b, err := c.bytesToBlockJSON(answer.Result, identifier)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, &model.NotFoundBlockError{Identifier: identifier}
+	}
+	if numID, ok := new(big.Int).SetString(identifier, 0); ok && c.cacheable(ctx, numID) {
+		log.Printf("update cache with block by number %s", identifier)
+		c.keep(identifier, b)
+	} else if identifier == "pending" {
+		// a pending block isn't on the chain yet
+		return b, nil
+	} else {
+		c.advanceHead(b)
+	}
+	c.observe(b)
+	return b, nil
+}
+
+// ForwardBatch sends any json-rpc methods to upstream nodes in batches
+func (c *JRClient) ForwardBatch(ctx context.Context, calls []Call) []Answer {
+	log.Printf("forward a batch of %d calls upstream\n", len(calls))
+	calls = append([]Call(nil), calls...)
+	for i := range calls {
+		if raw, ok := calls[i].Params.(json.RawMessage); calls[i].Params == nil || ok && len(raw) == 0 {
+			calls[i].Params = json.RawMessage("[]")
+		}
+	}
+	return c.batch(ctx, calls)
+}
+
+// batch splits calls into json-rpc batches of maxBatch calls at most and sends them concurrently
+func (c *JRClient) batch(ctx context.Context, calls []Call) []Answer {
+	answers := make([]Answer, len(calls))
+	var wg sync.WaitGroup
+	for start := 0; start < len(calls); start += c.maxBatch {
+		end := start + c.maxBatch
+		if end > len(calls) {
+			end = len(calls)
+		}
+		wg.Add(1)
+		go func(calls []Call, answers []Answer) {
+			defer wg.Done()
+			c.sendBatch(ctx, calls, answers)
+		}(calls[start:end], answers[start:end])
+	}
+	wg.Wait()
+	return answers
+}
+
+// sendBatch sends a single batch and fills answers. A whole batch is retried and failed over like a single call,
+// and the calls failed with transient errors are repeated in a smaller batch
+func (c *JRClient) sendBatch(ctx context.Context, calls []Call, answers []Answer) {
+	pending := make([]int, len(calls))
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 0; ; attempt++ {
+		reqs := make([]*model.RPCRequest, len(pending))
+		for j, i := range pending {
+			reqs[j] = &model.RPCRequest{JSONRPC: "2.0", Method: calls[i].Method, Params: calls[i].Params, ID: i}
+		}
+		body, err := json.Marshal(reqs)
+		if err == nil {
+			err = c.exchange(ctx, "a batch", body, func(data []byte) error {
+				return decodeBatch(data, pending, answers)
+			})
+		}
+		var again []int
+		for _, i := range pending {
+			if err != nil {
+				answers[i] = Answer{Err: err}
+			} else if answers[i].Err != nil && c.retry.retryable(answers[i].Err) {
+				again = append(again, i)
+			}
+		}
+		if len(again) == 0 || ctx.Err() != nil || attempt+1 >= c.retry.MaxAttempts {
+			return
+		}
+		delay := c.retry.backoff(attempt)
+		log.Printf("retrying %d calls of a batch in %s\n", len(again), delay)
+		if !pause(ctx, delay) {
+			return
+		}
+		pending = again
+	}
+}
+
+// decodeBatch matches answers of a batch to its calls by ids. Answers are set only when every pending call has one
+func decodeBatch(data []byte, pending []int, answers []Answer) error {
+	var resps []*model.RPCResponse
+	if err := json.Unmarshal(data, &resps); err != nil {
+		// a node answers a batch it can't serve with a single error
+		if _, rpcErr := decodeAnswer(data); rpcErr != nil {
+			return rpcErr
+		}
+		return err
+	}
+	byID := make(map[int]*model.RPCResponse, len(resps))
+	for _, r := range resps {
+		if r != nil {
+			byID[r.ID] = r
+		}
+	}
+	for _, i := range pending {
+		if _, ok := byID[i]; !ok {
+			return fmt.Errorf("no answer for the call %d of a batch", i)
+		}
+	}
+	for _, i := range pending {
+		r := byID[i]
+		if r.Error != nil {
+			answers[i] = Answer{Err: &model.ResponseContentError{Code: r.Error.Code, Message: r.Error.Message}}
+		} else {
+			answers[i] = Answer{Result: r.Result}
+		}
+	}
+	return nil
+}
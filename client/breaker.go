@@ -0,0 +1,135 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"my.eth.test/model"
+)
+
+// BreakerConfig is the set of rules for a circuit breaker of an upstream node
+type BreakerConfig struct {
+	Threshold int           // consecutive failures to open the circuit
+	Cooldown  time.Duration // how long the circuit stays open before a trial request
+}
+
+func (b BreakerConfig) withDefaults() BreakerConfig {
+	if b.Threshold <= 0 {
+		b.Threshold = 5
+	}
+	if b.Cooldown <= 0 {
+		b.Cooldown = 30 * time.Second
+	}
+	return b
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// breaker fails requests to a node fast when the node is clearly down.
+// closed -> open after Threshold consecutive failures,
+// open -> half-open after Cooldown, so one trial request passes,
+// half-open -> closed or open again depending on the trial result
+type breaker struct {
+	conf     BreakerConfig
+	url      string
+	lock     sync.Mutex
+	state    breakerState
+	failures int
+	since    time.Time
+	trial    bool
+}
+
+func newBreaker(url string, conf BreakerConfig) *breaker {
+	return &breaker{conf: conf, url: url, since: time.Now()}
+}
+
+// allow says whether a request can be sent to the node
+func (b *breaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.since) < b.conf.Cooldown {
+			return false
+		}
+		b.set(breakerHalfOpen)
+		b.trial = true
+		return true
+	case breakerHalfOpen:
+		if b.trial {
+			return false // the only trial request is in flight
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// report updates the state with a result of an allowed request
+func (b *breaker) report(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.set(breakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.conf.Threshold {
+		b.set(breakerOpen)
+	}
+}
+
+// release lets another trial request through when an allowed one has been cancelled
+func (b *breaker) release() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.trial = false
+}
+
+func (b *breaker) set(state breakerState) {
+	log.Printf("the circuit breaker of %s: %s -> %s\n", b.url, b.state, state)
+	b.state = state
+	b.since = time.Now()
+}
+
+func (b *breaker) status() (string, time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state.String(), b.since
+}
+
+// tripping says whether an error means the node is unavailable.
+// json-rpc errors and 4xx answers mean the node is alive unless they are retryable
+func (p RetryPolicy) tripping(err error) bool {
+	if err == nil {
+		return false
+	}
+	var rpcErr *model.ResponseContentError
+	var statusErr *model.UpstreamStatusError
+	if errors.As(err, &rpcErr) || errors.As(err, &statusErr) {
+		return p.retryable(err)
+	}
+	return true
+}
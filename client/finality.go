@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"my.eth.test/model"
+)
+
+// resolvedTag is a block number a tag has been resolved to
+type resolvedTag struct {
+	number *big.Int
+	at     time.Time
+}
+
+// cacheable says whether a block is deep enough to never change: it's below the finality tag
+// when the tag is configured or it has at least Confirmations blocks on top of it
+func (c *JRClient) cacheable(ctx context.Context, number *big.Int) bool {
+	return number.Cmp(c.deepest(ctx)) <= 0
+}
+
+// deepest is the number of the latest cacheable block, it's negative while no block is deep enough.
+// A recently resolved 'finalized' block is cacheable even with fewer confirmations
+func (c *JRClient) deepest(ctx context.Context) *big.Int {
+	if c.finality != "" {
+		final, err := c.finalNumber(ctx)
+		if err == nil {
+			return final
+		}
+		log.Printf(
+			"an error (%s) occured while resolving the '%s' tag, the confirmation depth is used\n",
+			err.Error(),
+			c.finality,
+		)
+	}
+	ln := new(big.Int).SetUint64(c.head.latest())
+	ln.Sub(ln, new(big.Int).SetUint64(c.confirmations))
+	if final, ok := c.resolved("finalized"); ok && final.Cmp(ln) > 0 {
+		return final
+	}
+	return ln
+}
+
+// finalNumber resolves the finality tag to a block number, the number is reused for FinalityTTL
+func (c *JRClient) finalNumber(ctx context.Context) (*big.Int, error) {
+	c.finalLock.Lock()
+	defer c.finalLock.Unlock()
+	if number, ok := c.resolved(c.finality); ok {
+		return number, nil
+	}
+	result, err := c.call(ctx, "eth_getBlockByNumber", c.finality, false)
+	if err != nil {
+		return nil, err
+	}
+	header := new(struct {
+		Number string `json:"number"`
+	})
+	if err := json.Unmarshal(result, header); err != nil {
+		return nil, err
+	}
+	return c.remember(c.finality, header.Number)
+}
+
+// resolved returns the number a tag has been resolved to within FinalityTTL
+func (c *JRClient) resolved(tag string) (*big.Int, bool) {
+	c.tagLock.Lock()
+	defer c.tagLock.Unlock()
+	r, ok := c.tags[tag]
+	if !ok || time.Since(r.at) >= c.finalityTTL {
+		return nil, false
+	}
+	return r.number, true
+}
+
+// remember keeps the number of a block a tag is resolved to
+func (c *JRClient) remember(tag string, number string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(number, 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid block number %q of the '%s' block", number, tag)
+	}
+	c.tagLock.Lock()
+	c.tags[tag] = resolvedTag{number: n, at: time.Now()}
+	c.tagLock.Unlock()
+	return n, nil
+}
+
+// getTagged returns the block of the 'safe' or 'finalized' tag. The tag is resolved to a number
+// for FinalityTTL, so meanwhile the block is served by its number from the cache
+func (c *JRClient) getTagged(ctx context.Context, tag string) (*model.Block, error) {
+	if number, ok := c.resolved(tag); ok {
+		return c.GetBlockBy(ctx, fmt.Sprintf("0x%x", number))
+	}
+	return c.flight.do(ctx, tag, 0, func(ctx context.Context) (*model.Block, error) {
+		b, err := c.receiveBlockStruct(ctx, tag)
+		if err != nil {
+			return nil, err
+		}
+		number, err := c.remember(tag, b.Number)
+		if err != nil {
+			return nil, err
+		}
+		if c.cacheable(ctx, number) {
+			log.Printf("update cache with the '%s' block by number %s", tag, b.Number)
+			c.keep(b.Number, b)
+		}
+		c.observe(b)
+		return b, nil
+	})
+}
+
+// FinalityReporter is implemented by sources that know whether a block won't change anymore
+type FinalityReporter interface {
+	// Final says whether a block with a hex number is deep enough to never change
+	Final(ctx context.Context, number string) bool
+}
+
+var _ FinalityReporter = (*JRClient)(nil)
+
+// Final says whether a block is cacheable
+func (c *JRClient) Final(ctx context.Context, number string) bool {
+	n, ok := new(big.Int).SetString(number, 0)
+	return ok && c.cacheable(ctx, n)
+}
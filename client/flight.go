@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"my.eth.test/model"
+)
+
+// flight deduplicates concurrent requests of the same block, so they share one upstream call.
+// A result can be shared for a window after the call is done, it's useful for the 'latest' tag
+type flight struct {
+	lock      sync.Mutex
+	calls     map[string]*flightCall
+	upstream  uint64
+	coalesced uint64
+}
+
+type flightCall struct {
+	key     string
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	expires time.Time
+	block   *model.Block
+	err     error
+}
+
+func newFlight() *flight {
+	return &flight{calls: make(map[string]*flightCall)}
+}
+
+// do calls fn once for every group of concurrent callers with the same key.
+// fn doesn't get the caller's ctx: the call goes on while at least one caller waits for it
+func (f *flight) do(
+	ctx context.Context,
+	key string,
+	window time.Duration,
+	fn func(context.Context) (*model.Block, error),
+) (*model.Block, error) {
+	f.lock.Lock()
+	call, ok := f.calls[key]
+	if ok && call.finished() && time.Now().After(call.expires) {
+		delete(f.calls, key)
+		ok = false
+	}
+	if ok {
+		call.waiters++
+		f.lock.Unlock()
+		atomic.AddUint64(&f.coalesced, 1)
+		return f.wait(ctx, call)
+	}
+	callCtx, cancel := context.WithCancel(context.Background())
+	call = &flightCall{key: key, done: make(chan struct{}), cancel: cancel, waiters: 1}
+	f.calls[key] = call
+	f.lock.Unlock()
+	atomic.AddUint64(&f.upstream, 1)
+
+	go func() {
+		block, err := fn(callCtx)
+		cancel()
+		f.lock.Lock()
+		call.block, call.err = block, err
+		call.expires = time.Now().Add(window)
+		if (window <= 0 || err != nil) && f.calls[key] == call {
+			delete(f.calls, key)
+		}
+		f.lock.Unlock()
+		close(call.done)
+	}()
+	return f.wait(ctx, call)
+}
+
+func (f *flight) wait(ctx context.Context, call *flightCall) (*model.Block, error) {
+	select {
+	case <-call.done:
+		return call.block, call.err
+	case <-ctx.Done():
+		f.lock.Lock()
+		call.waiters--
+		if call.waiters == 0 && !call.finished() {
+			// nobody needs the result anymore
+			call.cancel()
+			if f.calls[call.key] == call {
+				delete(f.calls, call.key)
+			}
+		}
+		f.lock.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *flightCall) finished() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stats is the set of client counters
+type Stats struct {
+	BlockRequests uint64 `json:"blockRequests"` // block requests sent upstream
+	Coalesced     uint64 `json:"coalesced"`     // block requests served by another in-flight or recent upstream call
+}
+
+// StatsReporter is implemented by sources that count their work
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// Stats reports the client counters
+func (c *JRClient) Stats() Stats {
+	return Stats{
+		BlockRequests: atomic.LoadUint64(&c.flight.upstream),
+		Coalesced:     atomic.LoadUint64(&c.flight.coalesced),
+	}
+}
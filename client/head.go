@@ -0,0 +1,288 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"my.eth.test/model"
+)
+
+// headSilence is how long a newHeads subscription may stay silent, then it's considered dead
+const headSilence = time.Minute
+
+// sources of the head
+const (
+	headSubscription = "subscription"
+	headPolling      = "polling"
+	headRequest      = "request"
+)
+
+// HeadConfig is the set of rules to follow the head of the chain
+type HeadConfig struct {
+	Interval    time.Duration // how often eth_blockNumber is polled while there's no subscription
+	WebSocket   string        // a ws:// address of a node to subscribe to newHeads, the head is polled without it
+	Resubscribe time.Duration // how long the head is polled after a subscription has failed
+}
+
+func (h HeadConfig) withDefaults() HeadConfig {
+	if h.Interval <= 0 {
+		h.Interval = 2 * time.Second
+	}
+	if h.Resubscribe <= 0 {
+		h.Resubscribe = 30 * time.Second
+	}
+	return h
+}
+
+// HeadStatus is the report about the latest known block of the chain
+type HeadStatus struct {
+	Number     uint64    `json:"number"`
+	Hash       string    `json:"hash,omitempty"`
+	Source     string    `json:"source"`
+	Observed   time.Time `json:"observed"`
+	Age        string    `json:"age"`
+	Subscribed bool      `json:"subscribed"`
+}
+
+// HeadReporter is implemented by sources that follow the head of the chain
+type HeadReporter interface {
+	Head() HeadStatus
+	// HeadChanged returns a channel that's closed when the head moves next time
+	HeadChanged() <-chan struct{}
+}
+
+var _ HeadReporter = (*JRClient)(nil)
+
+// headTracker keeps the latest known block, it only moves forward
+type headTracker struct {
+	lock       sync.RWMutex
+	number     uint64
+	hash       string
+	source     string
+	observed   time.Time
+	subscribed bool
+	changed    chan struct{} // closed on the next move, it's made by the first waiter
+}
+
+// advance moves the head to a higher block, false means the head is the same.
+// The hash is learnt later when the head has come from eth_blockNumber
+func (h *headTracker) advance(number uint64, hash string, source string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if number < h.number || number == h.number && !h.observed.IsZero() {
+		if number == h.number && h.hash == "" {
+			h.hash = hash
+		}
+		return false
+	}
+	h.number, h.hash, h.source, h.observed = number, hash, source, time.Now()
+	if h.changed != nil {
+		close(h.changed)
+		h.changed = nil
+	}
+	return true
+}
+
+func (h *headTracker) changes() <-chan struct{} {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.changed == nil {
+		h.changed = make(chan struct{})
+	}
+	return h.changed
+}
+
+func (h *headTracker) latest() uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.number
+}
+
+func (h *headTracker) setSubscribed(subscribed bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.subscribed = subscribed
+}
+
+func (h *headTracker) status() HeadStatus {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return HeadStatus{
+		Number:     h.number,
+		Hash:       h.hash,
+		Source:     h.source,
+		Observed:   h.observed,
+		Age:        time.Since(h.observed).Round(time.Millisecond).String(),
+		Subscribed: h.subscribed,
+	}
+}
+
+// Head reports the latest known block and how long ago it has appeared
+func (c *JRClient) Head() HeadStatus {
+	return c.head.status()
+}
+
+// HeadChanged returns a channel that's closed when the head moves, by a subscription, polling or a request
+func (c *JRClient) HeadChanged() <-chan struct{} {
+	return c.head.changes()
+}
+
+// advanceHead moves the head with a block received for a request
+func (c *JRClient) advanceHead(b *model.Block) {
+	number, err := blockNumber(b)
+	if err != nil {
+		return
+	}
+	c.setHead(number, b.Hash, headRequest)
+}
+
+func (c *JRClient) setHead(number uint64, hash string, source string) {
+	if c.head.advance(number, hash, source) {
+		log.Printf("Update latest block number with 0x%x from %s\n", number, source)
+	}
+}
+
+// trackHead follows the head till the client is closed: over a newHeads subscription when a WebSocket
+// address is configured, and by polling eth_blockNumber while there's no subscription
+func (c *JRClient) trackHead() {
+	for {
+		if c.headConf.WebSocket != "" {
+			err := c.subscribeHeads()
+			if c.closed() {
+				return
+			}
+			log.Printf(
+				"an error (%s) occured while following newHeads of %s, the head is polled for %s\n",
+				err.Error(),
+				c.headConf.WebSocket,
+				c.headConf.Resubscribe,
+			)
+		}
+		if !c.pollHeads() {
+			return
+		}
+	}
+}
+
+func (c *JRClient) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// pollHeads polls eth_blockNumber till it's time to subscribe again, false means the client is closed
+func (c *JRClient) pollHeads() bool {
+	ticker := time.NewTicker(c.headConf.Interval)
+	defer ticker.Stop()
+	var resubscribe <-chan time.Time
+	if c.headConf.WebSocket != "" {
+		timer := time.NewTimer(c.headConf.Resubscribe)
+		defer timer.Stop()
+		resubscribe = timer.C
+	}
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), c.health.MaxLatency)
+			if err := c.pollHead(ctx); err != nil {
+				log.Printf("an error (%s) occured while polling the head\n", err.Error())
+			}
+			cancel()
+		case <-resubscribe:
+			return true
+		case <-c.done:
+			return false
+		}
+	}
+}
+
+// pollHead requests the latest block number
+func (c *JRClient) pollHead(ctx context.Context) error {
+	result, err := c.send(ctx, "eth_blockNumber", []interface{}{})
+	if err != nil {
+		return err
+	}
+	var hex string
+	if err := json.Unmarshal(result, &hex); err != nil {
+		return err
+	}
+	number, err := strconv.ParseUint(hex, 0, 64)
+	if err != nil {
+		return err
+	}
+	c.setHead(number, "", headPolling)
+	return nil
+}
+
+// headNotification is a message of the newHeads subscription
+type headNotification struct {
+	Method string `json:"method"`
+	Params struct {
+		Result struct {
+			Number string `json:"number"`
+			Hash   string `json:"hash"`
+		} `json:"result"`
+	} `json:"params"`
+}
+
+// subscribeHeads follows the head over eth_subscribe newHeads till the subscription fails or the client is closed
+func (c *JRClient) subscribeHeads() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.health.MaxLatency)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.headConf.WebSocket, nil)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		// a blocked read is interrupted by closing the connection
+		select {
+		case <-c.done:
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(c.health.MaxLatency))
+	err = conn.WriteJSON(&model.RPCRequest{JSONRPC: "2.0", Method: "eth_subscribe", Params: []interface{}{"newHeads"}, ID: 1})
+	if err != nil {
+		return err
+	}
+	answer := new(model.RPCResponse)
+	if err := conn.ReadJSON(answer); err != nil {
+		return err
+	}
+	if answer.Error != nil {
+		return &model.ResponseContentError{Code: answer.Error.Code, Message: answer.Error.Message}
+	}
+	log.Printf("subscribed to newHeads of %s\n", c.headConf.WebSocket)
+	c.head.setSubscribed(true)
+	defer c.head.setSubscribed(false)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(headSilence))
+		note := new(headNotification)
+		if err := conn.ReadJSON(note); err != nil {
+			return err
+		}
+		if note.Method != "eth_subscription" {
+			continue
+		}
+		number, err := strconv.ParseUint(note.Params.Result.Number, 0, 64)
+		if err != nil {
+			log.Printf("an error (%s) occured while reading a new head\n", err.Error())
+			continue
+		}
+		c.setHead(number, note.Params.Result.Hash, headSubscription)
+	}
+}
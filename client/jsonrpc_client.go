@@ -1,14 +1,11 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"math"
 	"math/big"
-	"net/http"
-	"strings"
 	"sync"
 	"time"
 
@@ -18,142 +15,329 @@ import (
 
 const contentType = "application/json"
 
-// JRClient is the object to request blocks from an ether node
+// Config is the set of JRClient settings
+type Config struct {
+	Health  HealthConfig
+	Retry   RetryPolicy
+	Breaker BreakerConfig
+	Head    HeadConfig
+	// LatestWindow is how long a received 'latest' block is shared by new requests.
+	// 500ms by default, a negative value disables sharing after the call is done
+	LatestWindow time.Duration
+	// Confirmations is how many blocks must be on top of a block to cache it. 20 by default
+	Confirmations uint64
+	// Finality is the 'safe' or 'finalized' tag. When it's set, blocks up to the tagged one are cached
+	// and Confirmations is the fallback while the tag can't be resolved
+	Finality string
+	// FinalityTTL is how long a resolved 'safe' or 'finalized' tag is reused. 12s by default
+	FinalityTTL time.Duration
+	// ReorgWindow is how many recent blocks are remembered to notice reorganizations. 256 by default
+	ReorgWindow uint64
+	// Store is the persistent tier of the cache, nil disables it
+	Store BlockStore
+	// IndexSize is how many entries of the block hash index and the transaction hash index
+	// are kept in memory apart from the block cache. 1M by default
+	IndexSize int64
+	// MaxBatch is the largest number of calls in a json-rpc batch to upstream nodes. 100 by default
+	MaxBatch int
+	// MaxLogsRange is the widest cacheable block range of eth_getLogs served block by block,
+	// a wider one is forwarded as is. 1000 by default
+	MaxLogsRange uint64
+}
+
+// JRClient is the object to request blocks from ether nodes
 type JRClient struct {
-	url              string
-	preformattedBody string
-	cache            *ccache.Cache
-	lastBlockNumber  *big.Int
-	lock             sync.RWMutex
+	upstreams       []*upstream
+	health          HealthConfig
+	headConf        HeadConfig
+	retry           RetryPolicy
+	flight          *flight
+	latestWindow    time.Duration
+	maxBatch        int
+	maxLogsRange    uint64
+	noBlockReceipts int32 // set when upstream nodes don't serve eth_getBlockReceipts
+	confirmations   uint64
+	finality        string
+	finalityTTL     time.Duration
+	tags            map[string]resolvedTag // the 'safe' and 'finalized' tags resolved to numbers
+	tagLock         sync.Mutex
+	finalLock       sync.Mutex
+	chain           *chain
+	repairLock      sync.Mutex
+	repairs         sync.WaitGroup // running repairs of the chain
+	cache           *ccache.Cache
+	index           *ccache.Cache
+	store           BlockStore
+	head            headTracker
+	done            chan struct{}
+	closeOnce       sync.Once
+	closeLock       sync.Mutex
 }
 
 // NewJRClient is the JRClient constructor
-func NewJRClient(url string, cache *ccache.Cache) (*JRClient, error) {
-	n := new(big.Int)
+// urls - addresses of ether nodes in the order of priority
+func NewJRClient(urls []string, cache *ccache.Cache, conf Config) (*JRClient, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("at least one ether node address is required")
+	}
 	c := &JRClient{
-		url:              url,
-		preformattedBody: "{\"jsonrpc\":\"2.0\",\"method\":\"eth_getBlockByNumber\",\"params\":[\"%s\", true],\"id\":1}",
-		cache:            cache,
-		lastBlockNumber:  big.NewInt(0),
+		health:        conf.Health.withDefaults(),
+		headConf:      conf.Head.withDefaults(),
+		retry:         conf.Retry.withDefaults(),
+		flight:        newFlight(),
+		latestWindow:  conf.LatestWindow,
+		maxBatch:      conf.MaxBatch,
+		maxLogsRange:  conf.MaxLogsRange,
+		confirmations: conf.Confirmations,
+		finality:      conf.Finality,
+		finalityTTL:   conf.FinalityTTL,
+		tags:          make(map[string]resolvedTag),
+		cache:         cache,
+		store:         conf.Store,
+		done:          make(chan struct{}),
 	}
-	b, err := c.GetBlockBy("latest")
-	if err != nil {
-		return nil, err
+	if c.latestWindow == 0 {
+		c.latestWindow = 500 * time.Millisecond
+	}
+	if conf.IndexSize <= 0 {
+		conf.IndexSize = 1 << 20
+	}
+	c.index = ccache.New(ccache.Configure().MaxSize(conf.IndexSize).ItemsToPrune(100))
+	if c.maxBatch <= 0 {
+		c.maxBatch = 100
+	}
+	if c.maxLogsRange == 0 {
+		c.maxLogsRange = 1000
+	}
+	if c.confirmations == 0 {
+		c.confirmations = 20
+	}
+	if c.finalityTTL <= 0 {
+		c.finalityTTL = 12 * time.Second
 	}
-	_, ok := n.SetString(b.Number, 0)
-	if !ok {
-		return nil, fmt.Errorf("impossible to get latest block number")
+	if c.finality != "" && c.finality != "safe" && c.finality != "finalized" {
+		return nil, fmt.Errorf("unknown finality tag '%s', it's 'safe' or 'finalized'", c.finality)
 	}
+	if conf.ReorgWindow == 0 {
+		conf.ReorgWindow = 256
+	}
+	c.chain = newChain(conf.ReorgWindow)
+	for _, url := range urls {
+		c.upstreams = append(c.upstreams, newUpstream(url, conf.Breaker.withDefaults()))
+	}
+	c.checkHealth()
+	// the latest block is the first head, it's recorded to notice reorganizations since the start
+	if _, err := c.GetBlockBy(context.Background(), "latest"); err != nil {
+		return nil, err
+	}
+	go c.watchHealth()
+	go c.trackHead()
 	return c, nil
 }
 
+// Close stops background health checks, head tracking and the memory index. It waits for repairs of the chain,
+// so nothing is written to the cache after it
+func (c *JRClient) Close() {
+	c.closeOnce.Do(func() {
+		c.closeLock.Lock()
+		close(c.done)
+		c.closeLock.Unlock()
+		c.repairs.Wait()
+		c.index.Stop()
+	})
+}
+
 // GetBlockBy is the GET method to request the latest block from eth chain
-// identifier - can be a hex number in string format or the 'latest' tag
-func (c *JRClient) GetBlockBy(identifier string) (*model.Block, error) {
-	if identifier != "latest" {
+// identifier - can be a hex number in string format or the 'latest', 'earliest', 'pending', 'safe' or 'finalized' tag
+func (c *JRClient) GetBlockBy(ctx context.Context, identifier string) (*model.Block, error) {
+	switch identifier {
+	case "earliest":
+		identifier = "0x0"
+	case "safe", "finalized":
+		return c.getTagged(ctx, identifier)
+	}
+	if identifier != "latest" && identifier != "pending" {
 		numID, ok := new(big.Int).SetString(identifier, 0)
 		if ok {
-			c.lock.RLock()
-			ln := new(big.Int).Set(c.lastBlockNumber)
-			c.lock.RUnlock()
-
-			cmp := new(big.Int).Sub(ln, numID).Cmp(big.NewInt(20))
-			if cmp > 0 {
+			if c.cacheable(ctx, numID) {
 				log.Printf("check cache for block with number %s\n", identifier)
-				cached := c.cache.Get(identifier)
-				if cached != nil {
+				if cached := c.cached(identifier); cached != nil {
 					log.Printf("block with number %s found in cache\n", identifier)
-					return cached.Value().(*model.Block), nil
+					return cached, nil
 				}
 				log.Printf("block with number %s not found in cache. requesting ethereum\n", identifier)
-				b, err := c.receiveBlockStruct(identifier)
-				if err != nil {
-					return nil, err
-				}
-
-				// update cache concurrently
-				go func() {
+				return c.flight.do(ctx, identifier, 0, func(ctx context.Context) (*model.Block, error) {
+					b, err := c.receiveBlockStruct(ctx, identifier)
+					if err != nil {
+						return nil, err
+					}
+					// the only caller that has requested the block updates cache for every waiter
 					log.Printf("update cache with block by number %s", identifier)
-					c.cache.Set(identifier, b, time.Duration(math.MaxInt64))
-				}()
-
-				return b, nil
+					c.keep(identifier, b)
+					c.observe(b)
+					return b, nil
+				})
 			}
 		}
 	}
 
-	b, err := c.receiveBlockStruct(identifier)
-	if err != nil {
-		return nil, err
+	window := time.Duration(0)
+	if identifier == "latest" {
+		window = c.latestWindow
 	}
-
-	go c.updateLastNumber(b.Number)
-	return b, nil
-
+	return c.flight.do(ctx, identifier, window, func(ctx context.Context) (*model.Block, error) {
+		b, err := c.receiveBlockStruct(ctx, identifier)
+		if err != nil {
+			return nil, err
+		}
+		// a pending block isn't on the chain yet
+		if identifier != "pending" {
+			c.advanceHead(b)
+			c.observe(b)
+		}
+		return b, nil
+	})
 }
 
-func (c *JRClient) updateLastNumber(new string) {
-	log.Printf("Update latest block number with %s\n", new)
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	c.lastBlockNumber.SetString(new, 0)
+func (c *JRClient) receiveBlockStruct(ctx context.Context, identifier string) (*model.Block, error) {
+	log.Printf("request for a block by identifier %s\n", identifier)
+	return c.receiveBlock(ctx, "eth_getBlockByNumber", identifier)
 }
 
-func (c *JRClient) receiveBlockStruct(identifier string) (*model.Block, error) {
-	respBody, err := c.getBlockBytes(identifier)
+// receiveBlock requests a block with full transactions by a method that takes an identifier
+func (c *JRClient) receiveBlock(ctx context.Context, method string, identifier string) (*model.Block, error) {
+	result, err := c.call(ctx, method, identifier, true)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.bytesToBlockJSON(respBody, identifier)
+	block, err := c.bytesToBlockJSON(result, identifier)
 	if err != nil {
 		return nil, err
 	}
+	if block == nil {
+		return nil, &model.NotFoundBlockError{Identifier: identifier}
+	}
+	return block, nil
+}
 
-	if resp.Error != nil {
-		return nil, &model.ResponseContentError{
-			Message: resp.Error.Message,
-		}
+// call sends a json-rpc request to upstream nodes and repeats it with backoff on transient errors
+func (c *JRClient) call(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+	return c.send(ctx, method, params)
+}
+
+func (c *JRClient) send(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	body, err := json.Marshal(&model.RPCRequest{JSONRPC: "2.0", Method: method, Params: params, ID: 1})
+	if err != nil {
+		return nil, err
 	}
-	if resp.Result == nil {
-		return nil, &model.ResponseContentError{
-			Message: "a resulting block in a response is empty because of unknown reason",
+	var result json.RawMessage
+	err = c.exchange(ctx, method, body, func(data []byte) (err error) {
+		result, err = decodeAnswer(data)
+		return err
+	})
+	return result, err
+}
+
+// exchange posts a body to upstream nodes and repeats it with backoff on transient errors.
+// decode checks an answer, its error is the node's failure
+func (c *JRClient) exchange(ctx context.Context, method string, body []byte, decode func([]byte) error) error {
+	for attempt := 0; ; attempt++ {
+		err := c.callOnce(ctx, method, body, decode)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || attempt+1 >= c.retry.MaxAttempts || !c.retry.retryable(err) {
+			return err
+		}
+		delay := c.retry.backoff(attempt)
+		log.Printf("retrying %s in %s after an error (%s)\n", method, delay, err.Error())
+		if !pause(ctx, delay) {
+			return ctx.Err()
 		}
 	}
-	return resp.Result, nil
 }
 
-func (c *JRClient) getBlockBytes(param string) ([]byte, error) {
-	data := strings.NewReader(fmt.Sprintf(c.preformattedBody, param))
-	log.Printf("request for a block by identifier %s\n", param)
-	resp, err := http.Post(c.url, contentType, data)
-	if err != nil {
+// pause waits for a delay before the next attempt, false means ctx is done
+func pause(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// callOnce sends a json-rpc request to upstream nodes one by one till the first successful answer.
+// Transport errors and transient json-rpc errors make the client fail over to the next node.
+// A deterministic json-rpc error is the answer, it's returned as is and isn't counted against the node.
+// Nodes with open circuit breakers are skipped
+func (c *JRClient) callOnce(ctx context.Context, method string, body []byte, decode func([]byte) error) error {
+	var lastErr error
+	for _, u := range c.candidates() {
+		if !u.breaker.allow() {
+			if lastErr == nil {
+				lastErr = &model.CircuitOpenError{URL: u.url}
+			}
+			continue
+		}
+		err := c.callUpstream(ctx, u, method, body, decode)
+		if ctx.Err() != nil {
+			// the caller has gone or run out of time, it's not the node's fault
+			u.breaker.release()
+			return ctx.Err()
+		}
+		if c.retry.deterministic(err) {
+			u.breaker.report(false)
+			return err
+		}
+		u.record(err)
+		u.breaker.report(c.retry.tripping(err))
+		if err == nil {
+			return nil
+		}
 		log.Printf(
-			"an error (%s) occured while requesting a block by identifier %s\n",
+			"an error (%s) occured while requesting %s from %s\n",
 			err.Error(),
-			param,
+			method,
+			u.url,
 		)
-		return nil, err
+		lastErr = err
 	}
-	log.Printf(
-		"received answer for a block with identifier %s, Status code = %d\n",
-		param,
-		resp.StatusCode,
-	)
-	body, err := ioutil.ReadAll(resp.Body)
+	if len(c.upstreams) == 1 {
+		return lastErr
+	}
+	return &model.NoHealthyUpstreamError{LastError: lastErr}
+}
+
+func (c *JRClient) callUpstream(ctx context.Context, u *upstream, method string, body []byte, decode func([]byte) error) error {
+	data, err := u.post(ctx, body)
 	if err != nil {
-		log.Printf(
-			"an error (%s) occured while reading a response with a block by identifier %s\n",
-			err.Error(),
-			param,
-		)
-		return nil, err
+		return err
 	}
-	return body, nil
+	log.Printf("received answer for %s from %s\n", method, u.url)
+	return decode(data)
 }
 
-func (c *JRClient) bytesToBlockJSON(data []byte, param string) (*model.RespJSON, error) {
-	resp := new(model.RespJSON)
+// decodeAnswer returns the result of a single json-rpc answer
+func decodeAnswer(data []byte) (json.RawMessage, error) {
+	resp := new(model.RPCResponse)
 	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, &model.ResponseContentError{
+			Code:    resp.Error.Code,
+			Message: resp.Error.Message,
+		}
+	}
+	return resp.Result, nil
+}
+
+func (c *JRClient) bytesToBlockJSON(data []byte, param string) (*model.Block, error) {
+	var block *model.Block
+	if err := json.Unmarshal(data, &block); err != nil {
 		log.Printf(
 			"an error (%s) occured while creating json of a block to  by identifier %s\n",
 			err.Error(),
@@ -161,11 +345,11 @@ func (c *JRClient) bytesToBlockJSON(data []byte, param string) (*model.RespJSON,
 		)
 		return nil, err
 	}
-	return resp, nil
+	return block, nil
 }
 
 // GetTransactionByHash finds a particular transaction in a requested block
-func (c *JRClient) GetTransactionByHash(block *model.Block, hash string) (*model.Transaction, error) {
+func (c *JRClient) GetTransactionByHash(ctx context.Context, block *model.Block, hash string) (*model.Transaction, error) {
 	log.Printf(
 		"searching in a block with a number %s for a transaction with hash %s\n",
 		block.Number,
@@ -190,7 +374,7 @@ func (c *JRClient) GetTransactionByHash(block *model.Block, hash string) (*model
 }
 
 // GetTransactionByIndex finds a particular transaction in a requested block
-func (c *JRClient) GetTransactionByIndex(block *model.Block, index uint64) (*model.Transaction, error) {
+func (c *JRClient) GetTransactionByIndex(ctx context.Context, block *model.Block, index uint64) (*model.Transaction, error) {
 	log.Printf(
 		"searching in a block with a number %s for a transaction with index %d\n",
 		block.Number,
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"my.eth.test/model"
+)
+
+// logsPrefix is the prefix of the keys of block logs in every tier
+const logsPrefix = "logs:"
+
+// LogSource is implemented by sources that serve event logs
+type LogSource interface {
+	// GetLogs returns logs passing a filter like eth_getLogs does
+	GetLogs(ctx context.Context, filter *model.LogFilter) ([]*model.Log, error)
+}
+
+var _ LogSource = (*JRClient)(nil)
+
+// GetLogs returns logs passing a filter. The cacheable part of the block range is served block by block:
+// a block whose logsBloom can't match is skipped, logs of the rest are taken from the cache
+// or requested by the block hash, and then filtered here. The recent part is forwarded with the filter
+func (c *JRClient) GetLogs(ctx context.Context, filter *model.LogFilter) ([]*model.Log, error) {
+	if filter.BlockHash != "" {
+		if b := c.cachedByHash(filter.BlockHash); b != nil {
+			return c.filterLogs(ctx, []*model.Block{b}, filter)
+		}
+		return c.receiveLogs(ctx, filter)
+	}
+	from, ok := logsBound(filter.FromBlock)
+	if !ok {
+		return c.receiveLogs(ctx, filter)
+	}
+	deepest := c.deepest(ctx)
+	if deepest.Sign() < 0 || !deepest.IsUint64() || from > deepest.Uint64() {
+		return c.receiveLogs(ctx, filter)
+	}
+	last := deepest.Uint64()
+	to, ok := logsBound(filter.ToBlock)
+	if !ok && filter.ToBlock != "" && filter.ToBlock != "latest" && filter.ToBlock != "pending" {
+		// 'safe' and 'finalized' may be below the cacheable part
+		return c.receiveLogs(ctx, filter)
+	}
+	if ok && to < from {
+		return c.receiveLogs(ctx, filter)
+	}
+	if ok && to < last {
+		last = to
+	}
+	if last-from >= c.maxLogsRange {
+		log.Printf("the range of logs from %d to %d is too wide for the cache, it's forwarded\n", from, last)
+		return c.receiveLogs(ctx, filter)
+	}
+
+	logs, err := c.cachedRangeLogs(ctx, from, last, filter)
+	if err != nil {
+		return nil, err
+	}
+	if ok && to == last {
+		return logs, nil
+	}
+	recent := *filter
+	recent.FromBlock = fmt.Sprintf("0x%x", last+1)
+	rest, err := c.receiveLogs(ctx, &recent)
+	if err != nil {
+		return nil, err
+	}
+	return append(logs, rest...), nil
+}
+
+// logsBound parses a bound of a block range, only a number and 'earliest' are known without a node
+func logsBound(identifier string) (uint64, bool) {
+	if identifier == "earliest" {
+		return 0, true
+	}
+	if len(identifier) < 3 || identifier[:2] != "0x" {
+		return 0, false
+	}
+	number, err := strconv.ParseUint(identifier[2:], 16, 64)
+	return number, err == nil
+}
+
+// cachedRangeLogs serves logs of the cacheable blocks from 'from' to 'last' in chunks of a batch size
+func (c *JRClient) cachedRangeLogs(ctx context.Context, from, last uint64, filter *model.LogFilter) ([]*model.Log, error) {
+	var logs []*model.Log
+	for start := from; start <= last; start += uint64(c.maxBatch) {
+		end := start + uint64(c.maxBatch) - 1
+		if end > last || end < start {
+			end = last
+		}
+		identifiers := make([]string, 0, end-start+1)
+		for number := start; number <= end; number++ {
+			identifiers = append(identifiers, fmt.Sprintf("0x%x", number))
+		}
+		blocks, errs := c.GetBlocksBy(ctx, identifiers)
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+		chunk, err := c.filterLogs(ctx, blocks, filter)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, chunk...)
+		if end == last {
+			break
+		}
+	}
+	return logs, nil
+}
+
+// filterLogs returns logs of cacheable blocks passing a filter. Blocks are tested with their bloom first,
+// logs of the candidates missed in the cache are requested in a batch
+func (c *JRClient) filterLogs(ctx context.Context, blocks []*model.Block, filter *model.LogFilter) ([]*model.Log, error) {
+	found := make([][]*model.Log, len(blocks))
+	var calls []Call
+	var missed []int
+	candidates := 0
+	for i, b := range blocks {
+		if bloom, ok := model.ParseBloom(b.LogsBloom); ok && (bloom.Empty() || !filter.MayMatch(&bloom)) {
+			continue
+		}
+		candidates++
+		if cached := c.cachedLogs(b); cached != nil {
+			found[i] = cached
+			continue
+		}
+		calls = append(calls, Call{Method: "eth_getLogs", Params: []interface{}{&model.LogFilter{BlockHash: b.Hash}}})
+		missed = append(missed, i)
+	}
+	log.Printf("%d of %d blocks may have matching logs, logs of %d blocks not found in cache\n", candidates, len(blocks), len(calls))
+	if len(calls) > 0 {
+		for j, answer := range c.batch(ctx, calls) {
+			if answer.Err != nil {
+				return nil, answer.Err
+			}
+			var logs []*model.Log
+			if err := json.Unmarshal(answer.Result, &logs); err != nil {
+				return nil, err
+			}
+			b := blocks[missed[j]]
+			if logsOf(b, logs) {
+				c.keepLogs(b.Number, &model.BlockLogs{Hash: b.Hash, Logs: logs})
+			}
+			found[missed[j]] = logs
+		}
+	}
+	var logs []*model.Log
+	for _, blockLogs := range found {
+		for _, l := range blockLogs {
+			if filter.Matches(l) {
+				logs = append(logs, l)
+			}
+		}
+	}
+	return logs, nil
+}
+
+// receiveLogs forwards a filter upstream
+func (c *JRClient) receiveLogs(ctx context.Context, filter *model.LogFilter) ([]*model.Log, error) {
+	log.Printf("request for logs from %s to %s\n", filter.FromBlock, filter.ToBlock)
+	result, err := c.call(ctx, "eth_getLogs", filter)
+	if err != nil {
+		return nil, err
+	}
+	var logs []*model.Log
+	if err := json.Unmarshal(result, &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// logsOf says whether logs belong to the block
+func logsOf(b *model.Block, logs []*model.Log) bool {
+	for _, l := range logs {
+		if l == nil || l.BlockHash != b.Hash {
+			return false
+		}
+	}
+	return true
+}
+
+// cachedLogs returns every log of a cached block from its cached receipts or from the logs cache.
+// Logs are dropped when they belong to another block
+func (c *JRClient) cachedLogs(b *model.Block) []*model.Log {
+	if rs := c.cachedReceipts(b.Number); rs != nil {
+		logs := []*model.Log{}
+		for _, r := range rs {
+			logs = append(logs, r.Logs...)
+		}
+		return logs
+	}
+	key := b.Number
+	var bl *model.BlockLogs
+	if item := c.cache.Get(logsPrefix + key); item != nil {
+		bl = item.Value().(*model.BlockLogs)
+	} else if c.store != nil {
+		data, ok, err := c.store.Get(logsPrefix + key)
+		if err != nil {
+			log.Printf("an error (%s) occured while reading logs of the block %s from the store\n", err.Error(), key)
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+		bl = new(model.BlockLogs)
+		if err := json.Unmarshal(data, bl); err != nil {
+			log.Printf("an error (%s) occured while decoding logs of the block %s from the store\n", err.Error(), key)
+			return nil
+		}
+		c.cache.Set(logsPrefix+key, bl, time.Duration(math.MaxInt64))
+	} else {
+		return nil
+	}
+	if bl.Hash != b.Hash {
+		log.Printf("drop stale logs of the block %s\n", key)
+		c.dropLogs(key)
+		return nil
+	}
+	return bl.Logs
+}
+
+// keepLogs puts logs of a block to every tier
+func (c *JRClient) keepLogs(key string, bl *model.BlockLogs) {
+	c.cache.Set(logsPrefix+key, bl, time.Duration(math.MaxInt64))
+	if c.store == nil {
+		return
+	}
+	data, err := json.Marshal(bl)
+	if err == nil {
+		err = c.store.Put(logsPrefix+key, data)
+	}
+	if err != nil {
+		log.Printf("an error (%s) occured while writing logs of the block %s to the store\n", err.Error(), key)
+	}
+}
+
+// dropLogs removes logs of a block from every tier
+func (c *JRClient) dropLogs(key string) {
+	c.cache.Delete(logsPrefix + key)
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Delete(logsPrefix + key); err != nil {
+		log.Printf("an error (%s) occured while deleting logs of the block %s from the store\n", err.Error(), key)
+	}
+}
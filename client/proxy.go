@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"my.eth.test/model"
+)
+
+// Forwarder is implemented by sources that can send any json-rpc method upstream
+type Forwarder interface {
+	// Forward sends a method with its params as is and returns the result
+	Forward(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error)
+}
+
+var _ Forwarder = (*JRClient)(nil)
+
+// GetBlockByHash returns a block by its hash from the cache or requests it.
+// Nodes serve blocks that have fallen off the chain by their hashes too, so a requested block is compared
+// with the canonical one of its number. Only a canonical block is cached and indexed by its hash
+func (c *JRClient) GetBlockByHash(ctx context.Context, hash string) (*model.Block, error) {
+	log.Printf("check cache for block with hash %s\n", hash)
+	if cached := c.cachedByHash(hash); cached != nil {
+		log.Printf("block with hash %s found in cache\n", hash)
+		return cached, nil
+	}
+	return c.flight.do(ctx, hash, 0, func(ctx context.Context) (*model.Block, error) {
+		log.Printf("request for a block by hash %s\n", hash)
+		b, err := c.receiveBlock(ctx, "eth_getBlockByHash", hash)
+		if err != nil {
+			return nil, err
+		}
+		// the canonical block is cached and observed by GetBlockBy when it's requested by its number
+		canonical, err := c.GetBlockBy(ctx, b.Number)
+		if err != nil {
+			log.Printf("an error (%s) occured while checking the block with hash %s is canonical, it isn't cached\n", err.Error(), hash)
+			return b, nil
+		}
+		if canonical.Hash != b.Hash {
+			log.Printf("block with hash %s isn't canonical, %s is at the number %s. it isn't cached\n", hash, canonical.Hash, b.Number)
+		}
+		return b, nil
+	})
+}
+
+// Forward sends any json-rpc method to upstream nodes with failover and retries
+func (c *JRClient) Forward(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error) {
+	log.Printf("forward %s upstream\n", method)
+	if len(params) == 0 {
+		params = json.RawMessage("[]")
+	}
+	return c.send(ctx, method, params)
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"my.eth.test/model"
+)
+
+// receiptsPrefix is the prefix of the keys of block receipts in every tier
+const receiptsPrefix = "receipts:"
+
+// methodNotFound is the json-rpc code of a method a node doesn't serve
+const methodNotFound = -32601
+
+// ReceiptSource is implemented by sources that serve transaction receipts
+type ReceiptSource interface {
+	// GetBlockReceipts returns receipts of every transaction of a block by a hex number or a tag
+	GetBlockReceipts(ctx context.Context, identifier string) (model.Receipts, error)
+	// GetTransactionReceipt returns a receipt of a transaction of a block
+	GetTransactionReceipt(ctx context.Context, block *model.Block, t *model.Transaction) (*model.Receipt, error)
+}
+
+var _ ReceiptSource = (*JRClient)(nil)
+
+// GetBlockReceipts returns receipts of a block. Receipts are cached under the same rules as blocks,
+// and cached receipts are served only with their cached block
+func (c *JRClient) GetBlockReceipts(ctx context.Context, identifier string) (model.Receipts, error) {
+	numID, ok := new(big.Int).SetString(identifier, 0)
+	if !ok || !c.cacheable(ctx, numID) {
+		return c.receiveReceipts(ctx, identifier)
+	}
+	log.Printf("check cache for receipts of block with number %s\n", identifier)
+	if cached := c.cachedReceipts(identifier); cached != nil {
+		log.Printf("receipts of block with number %s found in cache\n", identifier)
+		return cached, nil
+	}
+	// the block is cached first, receipts are checked against it
+	b, err := c.GetBlockBy(ctx, identifier)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := c.receiveReceipts(ctx, identifier)
+	if err != nil {
+		return nil, err
+	}
+	if matches(b, rs) {
+		log.Printf("update cache with receipts of block by number %s", identifier)
+		c.keepReceipts(identifier, rs)
+	}
+	return rs, nil
+}
+
+// GetTransactionReceipt returns a receipt of a transaction. Receipts of a deep enough block are
+// requested and cached all at once, a receipt of a recent transaction is requested by eth_getTransactionReceipt
+func (c *JRClient) GetTransactionReceipt(ctx context.Context, block *model.Block, t *model.Transaction) (*model.Receipt, error) {
+	if numID, ok := new(big.Int).SetString(block.Number, 0); ok && c.cacheable(ctx, numID) {
+		rs, err := c.GetBlockReceipts(ctx, block.Number)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rs {
+			if r.TransactionHash == t.Hash {
+				return r, nil
+			}
+		}
+		return nil, &model.NotFoundTransactionError{Hash: t.Hash}
+	}
+	log.Printf("request for a receipt of transaction %s\n", t.Hash)
+	result, err := c.call(ctx, "eth_getTransactionReceipt", t.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return decodeReceipt(result, t.Hash)
+}
+
+// receiveReceipts requests receipts of a block by eth_getBlockReceipts. When a node doesn't serve it,
+// the block is requested and receipts of its transactions are requested by eth_getTransactionReceipt in a batch
+func (c *JRClient) receiveReceipts(ctx context.Context, identifier string) (model.Receipts, error) {
+	if atomic.LoadInt32(&c.noBlockReceipts) == 0 {
+		log.Printf("request for receipts of a block by identifier %s\n", identifier)
+		result, err := c.call(ctx, "eth_getBlockReceipts", identifier)
+		var rpcErr *model.ResponseContentError
+		if err == nil {
+			var rs model.Receipts
+			if err := json.Unmarshal(result, &rs); err != nil {
+				return nil, err
+			}
+			if rs == nil {
+				return nil, &model.NotFoundBlockError{Identifier: identifier}
+			}
+			return rs, nil
+		}
+		if !errors.As(err, &rpcErr) || rpcErr.Code != methodNotFound {
+			return nil, err
+		}
+		log.Printf("eth_getBlockReceipts isn't served by ether nodes, receipts are requested one by one\n")
+		atomic.StoreInt32(&c.noBlockReceipts, 1)
+	}
+
+	b, err := c.GetBlockBy(ctx, identifier)
+	if err != nil {
+		return nil, err
+	}
+	calls := make([]Call, len(b.Transactions))
+	for i, t := range b.Transactions {
+		calls[i] = Call{Method: "eth_getTransactionReceipt", Params: []interface{}{t.Hash}}
+	}
+	rs := make(model.Receipts, len(calls))
+	for i, answer := range c.batch(ctx, calls) {
+		if answer.Err != nil {
+			return nil, answer.Err
+		}
+		if rs[i], err = decodeReceipt(answer.Result, b.Transactions[i].Hash); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+func decodeReceipt(data []byte, hash string) (*model.Receipt, error) {
+	var r *model.Receipt
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, &model.NotFoundTransactionError{Hash: hash}
+	}
+	return r, nil
+}
+
+// matches says whether receipts belong to the block
+func matches(b *model.Block, rs model.Receipts) bool {
+	if len(rs) != len(b.Transactions) {
+		return false
+	}
+	for i, r := range rs {
+		if r == nil || r.BlockHash != b.Hash || r.TransactionHash != b.Transactions[i].Hash {
+			return false
+		}
+	}
+	return true
+}
+
+// cachedReceipts returns receipts of a block from the memory cache or from the store.
+// Receipts are dropped when their block isn't cached anymore or is another one
+func (c *JRClient) cachedReceipts(key string) model.Receipts {
+	var rs model.Receipts
+	if item := c.cache.Get(receiptsPrefix + key); item != nil {
+		rs = item.Value().(model.Receipts)
+	} else if c.store != nil {
+		data, ok, err := c.store.Get(receiptsPrefix + key)
+		if err != nil {
+			log.Printf("an error (%s) occured while reading receipts of the block %s from the store\n", err.Error(), key)
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+		if err := json.Unmarshal(data, &rs); err != nil {
+			log.Printf("an error (%s) occured while decoding receipts of the block %s from the store\n", err.Error(), key)
+			return nil
+		}
+		c.cache.Set(receiptsPrefix+key, rs, time.Duration(math.MaxInt64))
+	} else {
+		return nil
+	}
+	if b := c.cached(key); b == nil || !matches(b, rs) {
+		log.Printf("drop stale receipts of the block %s\n", key)
+		c.dropReceipts(key)
+		return nil
+	}
+	return rs
+}
+
+// keepReceipts puts receipts of a block to every tier
+func (c *JRClient) keepReceipts(key string, rs model.Receipts) {
+	c.cache.Set(receiptsPrefix+key, rs, time.Duration(math.MaxInt64))
+	if c.store == nil {
+		return
+	}
+	data, err := json.Marshal(rs)
+	if err == nil {
+		err = c.store.Put(receiptsPrefix+key, data)
+	}
+	if err != nil {
+		log.Printf("an error (%s) occured while writing receipts of the block %s to the store\n", err.Error(), key)
+	}
+}
+
+// dropReceipts removes receipts of a block from every tier
+func (c *JRClient) dropReceipts(key string) {
+	c.cache.Delete(receiptsPrefix + key)
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Delete(receiptsPrefix + key); err != nil {
+		log.Printf("an error (%s) occured while deleting receipts of the block %s from the store\n", err.Error(), key)
+	}
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"my.eth.test/model"
+)
+
+// link is a recorded block of a chain
+type link struct {
+	hash   string
+	parent string
+}
+
+// chain remembers hashes of recently seen blocks to notice reorganizations
+type chain struct {
+	lock    sync.Mutex
+	window  uint64
+	links   map[uint64]link
+	highest uint64
+}
+
+func newChain(window uint64) *chain {
+	return &chain{window: window, links: make(map[uint64]link)}
+}
+
+// conflicts says whether a block contradicts the recorded chain:
+// there is another block with the same number or the recorded parent has another hash
+func (ch *chain) conflicts(number uint64, l link) bool {
+	ch.lock.Lock()
+	defer ch.lock.Unlock()
+	if known, ok := ch.links[number]; ok && known.hash != l.hash {
+		return true
+	}
+	if number == 0 {
+		return false
+	}
+	known, ok := ch.links[number-1]
+	return ok && known.hash != l.parent
+}
+
+// record stores a block as a canonical one. Recorded descendants that don't link to it
+// are forgotten and returned, they have fallen off the canonical chain
+func (ch *chain) record(number uint64, l link) map[uint64]string {
+	ch.lock.Lock()
+	defer ch.lock.Unlock()
+	ch.links[number] = l
+	orphans := make(map[uint64]string)
+	for n, parent := number+1, l.hash; ; n++ {
+		child, ok := ch.links[n]
+		if !ok || child.parent == parent {
+			break
+		}
+		orphans[n] = child.hash
+		delete(ch.links, n)
+		parent = child.hash
+	}
+	if number > ch.highest {
+		ch.highest = number
+	}
+	for n := range ch.links {
+		if ch.highest-n > ch.window {
+			delete(ch.links, n)
+		}
+	}
+	return orphans
+}
+
+// below returns recorded numbers lower than a number, the highest first
+func (ch *chain) below(number uint64) []uint64 {
+	ch.lock.Lock()
+	defer ch.lock.Unlock()
+	numbers := make([]uint64, 0, len(ch.links))
+	for n := range ch.links {
+		if n < number {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] > numbers[j] })
+	return numbers
+}
+
+func (ch *chain) get(number uint64) (link, bool) {
+	ch.lock.Lock()
+	defer ch.lock.Unlock()
+	l, ok := ch.links[number]
+	return l, ok
+}
+
+func blockNumber(b *model.Block) (uint64, error) {
+	if len(b.Number) < 3 {
+		return 0, fmt.Errorf("invalid block number %q", b.Number)
+	}
+	return strconv.ParseUint(b.Number[2:], 16, 64)
+}
+
+// observe checks every block received from upstream against the recorded chain
+// and repairs the cache when the chain has been reorganized
+func (c *JRClient) observe(b *model.Block) {
+	number, err := blockNumber(b)
+	if err != nil {
+		return
+	}
+	l := link{hash: b.Hash, parent: b.ParentHash}
+	if !c.chain.conflicts(number, l) {
+		c.forget(c.chain.record(number, l))
+		return
+	}
+	log.Printf("the block %s (%s) contradicts seen blocks, the chain has been reorganized\n", b.Number, b.Hash)
+	// a repair is waited for on Close, so it's never started after it
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
+	if c.closed() {
+		return
+	}
+	c.repairs.Add(1)
+	go func() {
+		defer c.repairs.Done()
+		c.repair(number, b)
+	}()
+}
+
+// repair walks recorded blocks below the new canonical one down to a common ancestor.
+// Every recorded block that has fallen off the canonical chain is replaced by the canonical one,
+// the cache is updated with it or is cleared of the stale block
+func (c *JRClient) repair(number uint64, b *model.Block) {
+	c.repairLock.Lock()
+	defer c.repairLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	go func() {
+		// closing the client stops a repair
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	c.forget(c.chain.record(number, link{hash: b.Hash, parent: b.ParentHash}))
+	c.fixCached(number, b)
+	prev, parent := number, b.ParentHash
+	for _, n := range c.chain.below(number) {
+		if c.closed() {
+			return
+		}
+		known, _ := c.chain.get(n)
+		if n == prev-1 && known.hash == parent {
+			log.Printf("the block 0x%x is a common ancestor, the chain is repaired\n", n)
+			return
+		}
+		canonical, err := c.receiveBlockStruct(ctx, fmt.Sprintf("0x%x", n))
+		if err != nil {
+			log.Printf("an error (%s) occured while repairing the chain, it's stopped\n", err.Error())
+			return
+		}
+		if canonical.Hash == known.hash {
+			log.Printf("the block %s is a common ancestor, the chain is repaired\n", canonical.Number)
+			return
+		}
+		log.Printf("the block %s (%s) has been replaced with %s\n", canonical.Number, known.hash, canonical.Hash)
+		c.forget(c.chain.record(n, link{hash: canonical.Hash, parent: canonical.ParentHash}))
+		c.fixCached(n, canonical)
+		prev, parent = n, canonical.ParentHash
+	}
+}
+
+// fixCached replaces a cached block that differs from the canonical one
+func (c *JRClient) fixCached(number uint64, canonical *model.Block) {
+	key := fmt.Sprintf("0x%x", number)
+	cached := c.cached(key)
+	if cached == nil || cached.Hash == canonical.Hash {
+		return
+	}
+	log.Printf("update cache with canonical block by number %s", key)
+	c.keep(key, canonical)
+	c.dropReceipts(key)
+	c.dropLogs(key)
+}
+
+// forget evicts cached blocks that have fallen off the canonical chain
+func (c *JRClient) forget(orphans map[uint64]string) {
+	for number, hash := range orphans {
+		key := fmt.Sprintf("0x%x", number)
+		cached := c.cached(key)
+		if cached != nil && cached.Hash == hash {
+			log.Printf("evict non-canonical block by number %s from cache", key)
+			c.evict(key)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"my.eth.test/model"
+)
+
+// RetryPolicy is the set of rules to repeat upstream requests failed because of transient errors
+type RetryPolicy struct {
+	MaxAttempts int           // 1 disables retries
+	BaseDelay   time.Duration // a delay before the first retry, it's doubled every next one
+	MaxDelay    time.Duration // the upper limit of a delay
+	Jitter      float64       // a random share of a delay to subtract from it, 0..1
+	RetryCodes  []int64       // json-rpc error codes worth to retry
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 100 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 2 * time.Second
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = 0.2
+	}
+	if p.RetryCodes == nil {
+		// -32005 is "limit exceeded", -32603 is "internal error"
+		p.RetryCodes = []int64{-32005, -32603}
+	}
+	return p
+}
+
+// backoff is an exponential delay with jitter before the retry number attempt (starting from 0)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << uint(attempt)
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	return delay - time.Duration(rand.Float64()*p.Jitter*float64(delay))
+}
+
+// retryable says whether an error is transient: a timeout, 429, 5xx or one of RetryCodes
+func (p RetryPolicy) retryable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var statusErr *model.UpstreamStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests ||
+			statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	var rpcErr *model.ResponseContentError
+	if errors.As(err, &rpcErr) {
+		for _, code := range p.RetryCodes {
+			if rpcErr.Code == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deterministic says whether an error is a json-rpc answer any node would give, like a reverted call or invalid params.
+// It's neither retryable nor "method not found": a method may be served by another node
+func (p RetryPolicy) deterministic(err error) bool {
+	var rpcErr *model.ResponseContentError
+	return errors.As(err, &rpcErr) && rpcErr.Code != methodNotFound && !p.retryable(err)
+}
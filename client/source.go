@@ -0,0 +1,25 @@
+package client
+
+import (
+	"context"
+
+	"my.eth.test/model"
+)
+
+// BlockSource is the abstraction over a backend the service gets blocks and transactions from.
+// JRClient is the default implementation, but the server accepts any other backend or a fake.
+// Every method stops upstream work when ctx is cancelled or its deadline is exceeded
+type BlockSource interface {
+	// GetBlockBy returns a block by a hex number in string format or the 'latest' tag
+	GetBlockBy(ctx context.Context, identifier string) (*model.Block, error)
+	// GetBlockByHash returns a block by its hash
+	GetBlockByHash(ctx context.Context, hash string) (*model.Block, error)
+	// GetTransactionByHash finds a particular transaction in a block by its hash
+	GetTransactionByHash(ctx context.Context, block *model.Block, hash string) (*model.Transaction, error)
+	// GetTransactionByIndex finds a particular transaction in a block by its index
+	GetTransactionByIndex(ctx context.Context, block *model.Block, index uint64) (*model.Transaction, error)
+	// FindTransaction returns a transaction by its hash without knowing its block
+	FindTransaction(ctx context.Context, hash string) (*model.Transaction, error)
+}
+
+var _ BlockSource = (*JRClient)(nil)
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"my.eth.test/model"
+)
+
+// BlockStore is a persistent tier of the block cache, it's consulted after the memory one
+type BlockStore interface {
+	Get(key string) ([]byte, bool, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+}
+
+// batchStore is implemented by stores that write many records at once cheaper than one by one
+type batchStore interface {
+	PutAll(values map[string][]byte) error
+}
+
+const (
+	// hashPrefix is the prefix of the block hash index keys in every tier, an entry is a block number
+	hashPrefix = "hash:"
+	// txPrefix is the prefix of the transaction hash index keys in every tier,
+	// an entry is a block number and a position in the block like 0x64:3
+	txPrefix = "tx:"
+)
+
+// cached returns a block from the memory cache or from the store.
+// A block found in the store is put to the memory cache
+func (c *JRClient) cached(key string) *model.Block {
+	if item := c.cache.Get(key); item != nil {
+		return item.Value().(*model.Block)
+	}
+	if c.store == nil {
+		return nil
+	}
+	data, ok, err := c.store.Get(key)
+	if err != nil {
+		log.Printf("an error (%s) occured while reading the block %s from the store\n", err.Error(), key)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	b := new(model.Block)
+	if err := json.Unmarshal(data, b); err != nil {
+		log.Printf("an error (%s) occured while decoding the block %s from the store\n", err.Error(), key)
+		return nil
+	}
+	log.Printf("block with number %s found in the store\n", key)
+	c.cache.Set(key, b, time.Duration(math.MaxInt64))
+	return b
+}
+
+// cachedByHash finds a cached block by the hash index. The index isn't updated on evictions and reorgs,
+// so the block cached by the number must still have the hash, otherwise the entry is stale
+func (c *JRClient) cachedByHash(hash string) *model.Block {
+	number := c.indexed(hashPrefix + hash)
+	if number == "" {
+		return nil
+	}
+	b := c.cached(number)
+	if b == nil || b.Hash != hash {
+		log.Printf("drop the stale index entry of the block %s\n", hash)
+		c.unindex(hashPrefix + hash)
+		return nil
+	}
+	return b
+}
+
+// cachedTx finds a cached transaction by the transaction index.
+// Like in cachedByHash the transaction must still be in the cached block of its number
+func (c *JRClient) cachedTx(hash string) *model.Transaction {
+	entry := c.indexed(txPrefix + hash)
+	if entry == "" {
+		return nil
+	}
+	sep := strings.IndexByte(entry, ':')
+	if sep < 0 {
+		return nil
+	}
+	position, err := strconv.Atoi(entry[sep+1:])
+	if err != nil {
+		return nil
+	}
+	b := c.cached(entry[:sep])
+	if b != nil && position < len(b.Transactions) && b.Transactions[position].Hash == hash {
+		return b.Transactions[position]
+	}
+	log.Printf("drop the stale index entry of the transaction %s\n", hash)
+	c.unindex(txPrefix + hash)
+	return nil
+}
+
+// indexed returns an index entry from the memory index or from the store
+func (c *JRClient) indexed(key string) string {
+	if item := c.index.Get(key); item != nil {
+		return item.Value().(string)
+	}
+	if c.store == nil {
+		return ""
+	}
+	data, ok, err := c.store.Get(key)
+	if err != nil {
+		log.Printf("an error (%s) occured while reading the index entry %s from the store\n", err.Error(), key)
+		return ""
+	}
+	if !ok {
+		return ""
+	}
+	c.index.Set(key, string(data), time.Duration(math.MaxInt64))
+	return string(data)
+}
+
+// keep puts a block with its entries of the block hash index and the transaction hash index to every tier
+func (c *JRClient) keep(key string, b *model.Block) {
+	entries := make(map[string]string, len(b.Transactions)+1)
+	entries[hashPrefix+b.Hash] = key
+	for i, t := range b.Transactions {
+		if t != nil {
+			entries[txPrefix+t.Hash] = key + ":" + strconv.Itoa(i)
+		}
+	}
+	c.cache.Set(key, b, time.Duration(math.MaxInt64))
+	for k, entry := range entries {
+		c.index.Set(k, entry, time.Duration(math.MaxInt64))
+	}
+	if c.store == nil {
+		return
+	}
+	data, err := json.Marshal(b)
+	if err == nil {
+		values := make(map[string][]byte, len(entries)+1)
+		values[key] = data
+		for k, entry := range entries {
+			values[k] = []byte(entry)
+		}
+		err = c.putAll(values)
+	}
+	if err != nil {
+		log.Printf("an error (%s) occured while writing the block %s to the store\n", err.Error(), key)
+	}
+}
+
+// putAll writes records to the store at once when it can, every write is synced otherwise
+func (c *JRClient) putAll(values map[string][]byte) error {
+	if batch, ok := c.store.(batchStore); ok {
+		return batch.PutAll(values)
+	}
+	for key, value := range values {
+		if err := c.store.Put(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindex removes an index entry from every tier
+func (c *JRClient) unindex(key string) {
+	c.index.Delete(key)
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Delete(key); err != nil {
+		log.Printf("an error (%s) occured while deleting the index entry %s from the store\n", err.Error(), key)
+	}
+}
+
+// evict removes a block with its receipts and logs from every tier
+func (c *JRClient) evict(key string) {
+	c.cache.Delete(key)
+	c.dropReceipts(key)
+	c.dropLogs(key)
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Delete(key); err != nil {
+		log.Printf("an error (%s) occured while deleting the block %s from the store\n", err.Error(), key)
+	}
+}
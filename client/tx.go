@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"my.eth.test/model"
+)
+
+// FindTransaction returns a transaction by its hash from the cache or requests it by eth_getTransactionByHash.
+// The block of a requested transaction is requested too, so it's cached and indexed when it's deep enough
+func (c *JRClient) FindTransaction(ctx context.Context, hash string) (*model.Transaction, error) {
+	log.Printf("check cache for transaction with hash %s\n", hash)
+	if cached := c.cachedTx(hash); cached != nil {
+		log.Printf("transaction with hash %s found in cache\n", hash)
+		return cached, nil
+	}
+	log.Printf("transaction with hash %s not found in cache. requesting ethereum\n", hash)
+	result, err := c.call(ctx, "eth_getTransactionByHash", hash)
+	if err != nil {
+		return nil, err
+	}
+	var t *model.Transaction
+	if err := json.Unmarshal(result, &t); err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, &model.NotFoundTransactionError{Hash: hash}
+	}
+	if t.BlockNumber == "" {
+		// a pending transaction has no block yet
+		return t, nil
+	}
+	b, err := c.GetBlockBy(ctx, t.BlockNumber)
+	if err != nil {
+		log.Printf(
+			"an error (%s) occured while requesting the block %s of the transaction %s\n",
+			err.Error(),
+			t.BlockNumber,
+			hash,
+		)
+		return t, nil
+	}
+	for _, bt := range b.Transactions {
+		if bt.Hash == hash {
+			return bt, nil
+		}
+	}
+	return t, nil
+}
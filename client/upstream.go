@@ -0,0 +1,271 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"my.eth.test/model"
+)
+
+// outcomes is the size of a window of the latest requests to compute an error rate
+const outcomes = 50
+
+// HealthConfig is the set of rules to decide whether an upstream node is healthy
+type HealthConfig struct {
+	Interval     time.Duration // how often nodes are probed
+	MaxLag       uint64        // how many blocks a node can be behind the best one
+	MaxLatency   time.Duration // the slowest acceptable eth_blockNumber answer
+	MaxErrorRate float64       // the share of failed requests among the latest ones
+}
+
+func (h HealthConfig) withDefaults() HealthConfig {
+	if h.Interval <= 0 {
+		h.Interval = 10 * time.Second
+	}
+	if h.MaxLag == 0 {
+		h.MaxLag = 5
+	}
+	if h.MaxLatency <= 0 {
+		h.MaxLatency = 5 * time.Second
+	}
+	if h.MaxErrorRate <= 0 {
+		h.MaxErrorRate = 0.5
+	}
+	return h
+}
+
+// UpstreamStatus is the report about a health of an upstream node
+type UpstreamStatus struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	Head      uint64    `json:"head"`
+	Lag       uint64    `json:"lag"`
+	Latency   string    `json:"latency"`
+	ErrorRate float64   `json:"errorRate"`
+	LastError string    `json:"lastError,omitempty"`
+	LastCheck time.Time `json:"lastCheck"`
+	Breaker   string    `json:"breaker"`
+	Since     time.Time `json:"breakerSince"`
+}
+
+// UpstreamReporter is implemented by sources that request a set of upstream nodes
+type UpstreamReporter interface {
+	Upstreams() []UpstreamStatus
+}
+
+// upstream is an ether node with its health state
+type upstream struct {
+	url     string
+	lock    sync.RWMutex
+	healthy bool
+	head    uint64
+	lag     uint64
+	latency time.Duration
+	results [outcomes]bool // true is a failure
+	next    int
+	total   int
+	lastErr string
+	checked time.Time
+	breaker *breaker
+}
+
+func newUpstream(url string, conf BreakerConfig) *upstream {
+	// a node is innocent until proven guilty
+	return &upstream{url: url, healthy: true, breaker: newBreaker(url, conf)}
+}
+
+// post sends a json-rpc body to the node and returns a body of an answer
+func (u *upstream) post(ctx context.Context, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &model.UpstreamStatusError{URL: u.url, StatusCode: resp.StatusCode}
+	}
+	return data, nil
+}
+
+// record stores an outcome of a request to compute an error rate
+func (u *upstream) record(err error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.results[u.next] = err != nil
+	u.next = (u.next + 1) % outcomes
+	if u.total < outcomes {
+		u.total++
+	}
+	if err != nil {
+		u.lastErr = err.Error()
+	}
+}
+
+func (u *upstream) errorRate() float64 {
+	if u.total == 0 {
+		return 0
+	}
+	failed := 0
+	for i := 0; i < u.total; i++ {
+		if u.results[i] {
+			failed++
+		}
+	}
+	return float64(failed) / float64(u.total)
+}
+
+func (u *upstream) isHealthy() bool {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	return u.healthy
+}
+
+func (u *upstream) status() UpstreamStatus {
+	state, since := u.breaker.status()
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	return UpstreamStatus{
+		Breaker:   state,
+		Since:     since,
+		URL:       u.url,
+		Healthy:   u.healthy,
+		Head:      u.head,
+		Lag:       u.lag,
+		Latency:   u.latency.String(),
+		ErrorRate: u.errorRate(),
+		LastError: u.lastErr,
+		LastCheck: u.checked,
+	}
+}
+
+// probe requests eth_blockNumber and returns the node's head
+func (u *upstream) probe(ctx context.Context) (uint64, time.Duration, error) {
+	body, _ := json.Marshal(&model.RPCRequest{JSONRPC: "2.0", Method: "eth_blockNumber", Params: []interface{}{}, ID: 1})
+	start := time.Now()
+	data, err := u.post(ctx, body)
+	latency := time.Since(start)
+	if err != nil {
+		return 0, latency, err
+	}
+	resp := new(model.RPCResponse)
+	if err := json.Unmarshal(data, resp); err != nil {
+		return 0, latency, err
+	}
+	if resp.Error != nil {
+		return 0, latency, &model.ResponseContentError{Code: resp.Error.Code, Message: resp.Error.Message}
+	}
+	var hex string
+	if err := json.Unmarshal(resp.Result, &hex); err != nil {
+		return 0, latency, err
+	}
+	if len(hex) < 3 {
+		return 0, latency, fmt.Errorf("invalid block number %q", hex)
+	}
+	head, err := strconv.ParseUint(hex[2:], 16, 64)
+	return head, latency, err
+}
+
+// checkHealth probes every node and marks lagging, slow and failing ones as unhealthy
+func (c *JRClient) checkHealth() {
+	type result struct {
+		head    uint64
+		latency time.Duration
+		err     error
+	}
+	results := make([]result, len(c.upstreams))
+	var wg sync.WaitGroup
+	for i, u := range c.upstreams {
+		wg.Add(1)
+		go func(i int, u *upstream) {
+			defer wg.Done()
+			// a slower answer is unhealthy anyway
+			ctx, cancel := context.WithTimeout(context.Background(), c.health.MaxLatency)
+			defer cancel()
+			head, latency, err := u.probe(ctx)
+			results[i] = result{head, latency, err}
+		}(i, u)
+	}
+	wg.Wait()
+
+	var best uint64
+	for _, r := range results {
+		if r.err == nil && r.head > best {
+			best = r.head
+		}
+	}
+	for i, u := range c.upstreams {
+		r := results[i]
+		u.record(r.err)
+		u.lock.Lock()
+		was := u.healthy
+		u.checked = time.Now()
+		u.latency = r.latency
+		if r.err == nil {
+			u.head = r.head
+			u.lag = best - r.head
+		}
+		u.healthy = r.err == nil &&
+			u.lag <= c.health.MaxLag &&
+			r.latency <= c.health.MaxLatency &&
+			u.errorRate() <= c.health.MaxErrorRate
+		now := u.healthy
+		u.lock.Unlock()
+		if was != now {
+			log.Printf("the node %s is healthy now: %t\n", u.url, now)
+		}
+	}
+}
+
+func (c *JRClient) watchHealth() {
+	ticker := time.NewTicker(c.health.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.checkHealth()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// candidates returns nodes in the order to try them: healthy ones first
+func (c *JRClient) candidates() []*upstream {
+	list := make([]*upstream, 0, len(c.upstreams))
+	var sick []*upstream
+	for _, u := range c.upstreams {
+		if u.isHealthy() {
+			list = append(list, u)
+		} else {
+			sick = append(sick, u)
+		}
+	}
+	// unhealthy nodes are the last resort
+	return append(list, sick...)
+}
+
+// Upstreams reports the current status of every upstream node
+func (c *JRClient) Upstreams() []UpstreamStatus {
+	statuses := make([]UpstreamStatus, len(c.upstreams))
+	for i, u := range c.upstreams {
+		statuses[i] = u.status()
+	}
+	return statuses
+}
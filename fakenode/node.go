@@ -0,0 +1,582 @@
+// Package fakenode is an in-process fake of an Ethereum JSON-RPC node.
+// It serves deterministic synthetic blocks, so tests don't depend on a real provider
+package fakenode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"my.eth.test/model"
+)
+
+// ChainID is the id of the fake chain
+const ChainID = "0x539"
+
+// DefaultHead is the number of the latest block of a new node
+const DefaultHead = 12000000
+
+const (
+	// SafeDepth is how many blocks the 'safe' block is behind the head
+	SafeDepth = 32
+	// FinalizedDepth is how many blocks the 'finalized' block is behind the head
+	FinalizedDepth = 64
+)
+
+// MaxLogsRange is the widest block range of eth_getLogs the node serves
+const MaxLogsRange = 10000
+
+// Node is the fake ether node. Every block below or equal to the head exists
+type Node struct {
+	server   *httptest.Server
+	lock     sync.RWMutex
+	head     uint64
+	calls    map[string]int
+	disabled map[string]bool
+	requests int
+	batches  int
+	maxBatch int
+	failing  bool
+	failNext int
+	failCode int
+	delay    time.Duration
+	forks    []uint64
+	// subscribers of newHeads
+	subscribers map[*subscriber]struct{}
+}
+
+type request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+	ID      json.RawMessage   `json:"id"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+	Error   *model.EthError `json:"error,omitempty"`
+}
+
+// New starts a fake node with the latest block number = head
+func New(head uint64) *Node {
+	n := &Node{
+		head:        head,
+		calls:       make(map[string]int),
+		disabled:    make(map[string]bool),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+	n.server = httptest.NewServer(http.HandlerFunc(n.serveHTTP))
+	return n
+}
+
+// URL is the address to request the node
+func (n *Node) URL() string {
+	return n.server.URL
+}
+
+// Close stops the node
+func (n *Node) Close() {
+	n.DropSubscriptions()
+	n.server.Close()
+}
+
+// Head returns the latest block number
+func (n *Node) Head() uint64 {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.head
+}
+
+// SetHead moves the chain to a new latest block number, subscribers of newHeads are notified
+func (n *Node) SetHead(head uint64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.head = head
+	n.notifyHeads()
+}
+
+// SetFailing makes the node answer every request with 503 Service Unavailable
+func (n *Node) SetFailing(failing bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.failing = failing
+}
+
+// FailNext makes the node answer the next count requests with an HTTP status code
+func (n *Node) FailNext(count int, status int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.failNext = count
+	n.failCode = status
+}
+
+// SetDelay makes the node wait before answering every request
+func (n *Node) SetDelay(delay time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.delay = delay
+}
+
+// Requests returns how many HTTP requests the node has received
+func (n *Node) Requests() int {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.requests
+}
+
+// Batches returns how many json-rpc batches the node has received
+func (n *Node) Batches() int {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.batches
+}
+
+// SetMaxBatch limits the size of a json-rpc batch, 0 is unlimited
+func (n *Node) SetMaxBatch(size int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.maxBatch = size
+}
+
+// Disable makes the node answer a method as an unknown one, like nodes without eth_getBlockReceipts do
+func (n *Node) Disable(method string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.disabled[method] = true
+}
+
+// Reorg replaces every block from the number on with a block of a new fork
+func (n *Node) Reorg(from uint64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.forks = append(n.forks, from)
+}
+
+// BlockHash is the hash of a canonical block of the node
+func (n *Node) BlockHash(number uint64) string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return blockHash(number, n.fork(number))
+}
+
+// fork is the number of reorganizations a block has gone through
+func (n *Node) fork(number uint64) int {
+	fork := 0
+	for _, from := range n.forks {
+		if from <= number {
+			fork++
+		}
+	}
+	return fork
+}
+
+// Calls returns how many times a method has been requested
+func (n *Node) Calls(method string) int {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.calls[method]
+}
+
+func (n *Node) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		n.serveWebSocket(w, r)
+		return
+	}
+	// the whole body is read first, so the request context notices a gone client
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n.lock.Lock()
+	n.requests++
+	status := 0
+	if n.failing {
+		status = http.StatusServiceUnavailable
+	} else if n.failNext > 0 {
+		n.failNext--
+		status = n.failCode
+	}
+	delay := n.delay
+	n.lock.Unlock()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if status != 0 {
+		http.Error(w, "the node is down", status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '[' {
+		n.serveBatch(w, body)
+		return
+	}
+	req := new(request)
+	if err := json.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := n.handle(req)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveBatch answers a json-rpc batch. A batch over the limit is answered with a single error as geth does
+func (n *Node) serveBatch(w http.ResponseWriter, body []byte) {
+	var reqs []*request
+	if err := json.Unmarshal(body, &reqs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n.lock.Lock()
+	n.batches++
+	maxBatch := n.maxBatch
+	n.lock.Unlock()
+	if maxBatch > 0 && len(reqs) > maxBatch {
+		json.NewEncoder(w).Encode(&response{
+			JSONRPC: "2.0",
+			ID:      json.RawMessage("null"),
+			Error:   &model.EthError{Code: -32600, Message: "batch too large"},
+		})
+		return
+	}
+	resps := make([]*response, len(reqs))
+	// answers go in the reverse order, a client must match them by ids
+	for i, req := range reqs {
+		resps[len(reqs)-1-i] = n.handle(req)
+	}
+	json.NewEncoder(w).Encode(resps)
+}
+
+func (n *Node) handle(req *request) *response {
+	n.lock.Lock()
+	n.calls[req.Method]++
+	n.lock.Unlock()
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	head := n.head
+
+	resp := &response{JSONRPC: "2.0", ID: req.ID}
+	method := req.Method
+	if n.disabled[method] {
+		method = ""
+	}
+	switch method {
+	case "eth_blockNumber":
+		resp.Result = fmt.Sprintf("0x%x", head)
+	case "eth_chainId":
+		resp.Result = ChainID
+	case "eth_getBlockByNumber":
+		var tag string
+		if len(req.Params) == 0 || json.Unmarshal(req.Params[0], &tag) != nil {
+			resp.Error = &model.EthError{Code: -32602, Message: "invalid argument 0"}
+			return resp
+		}
+		number, ok := resolve(tag, head)
+		if !ok {
+			resp.Error = &model.EthError{Code: -32602, Message: "invalid argument 0: hex string without 0x prefix"}
+			return resp
+		}
+		if number <= head { // or null result as a real node does
+			resp.Result = blockResult(n.canonical(number), fullTx(req.Params))
+		}
+	case "eth_getBlockByHash":
+		var h string
+		if len(req.Params) == 0 || json.Unmarshal(req.Params[0], &h) != nil {
+			resp.Error = &model.EthError{Code: -32602, Message: "invalid argument 0"}
+			return resp
+		}
+		// like a real node it serves blocks that have fallen off the chain by their hashes
+		number, fork, ok := parseBlockHash(h)
+		if ok && number <= head && fork <= n.fork(number) {
+			resp.Result = blockResult(n.forked(number, fork), fullTx(req.Params))
+		}
+	case "eth_getTransactionByHash":
+		var h string
+		if len(req.Params) == 0 || json.Unmarshal(req.Params[0], &h) != nil {
+			resp.Error = &model.EthError{Code: -32602, Message: "invalid argument 0"}
+			return resp
+		}
+		number, index, ok := parseTxHash(h)
+		if ok && number <= head {
+			resp.Result = n.canonical(number).Transactions[index]
+		}
+	case "eth_getTransactionReceipt":
+		var h string
+		if len(req.Params) == 0 || json.Unmarshal(req.Params[0], &h) != nil {
+			resp.Error = &model.EthError{Code: -32602, Message: "invalid argument 0"}
+			return resp
+		}
+		number, index, ok := parseTxHash(h)
+		if ok && number <= head {
+			resp.Result = receipt(n.canonical(number), index)
+		}
+	case "eth_getBlockReceipts":
+		var tag string
+		if len(req.Params) == 0 || json.Unmarshal(req.Params[0], &tag) != nil {
+			resp.Error = &model.EthError{Code: -32602, Message: "invalid argument 0"}
+			return resp
+		}
+		number, ok := resolve(tag, head)
+		if !ok {
+			resp.Error = &model.EthError{Code: -32602, Message: "invalid argument 0: hex string without 0x prefix"}
+			return resp
+		}
+		if number <= head {
+			resp.Result = receipts(n.canonical(number))
+		}
+	case "eth_getLogs":
+		filter := new(model.LogFilter)
+		if len(req.Params) == 0 || json.Unmarshal(req.Params[0], filter) != nil {
+			resp.Error = &model.EthError{Code: -32602, Message: "invalid argument 0"}
+			return resp
+		}
+		resp.Result, resp.Error = n.logs(filter, head)
+	case "eth_getBalance":
+		var tag string
+		if len(req.Params) < 2 || json.Unmarshal(req.Params[1], &tag) != nil {
+			resp.Error = &model.EthError{Code: -32602, Message: "invalid argument 1"}
+			return resp
+		}
+		number, ok := resolve(tag, head)
+		if !ok || number > head {
+			resp.Error = &model.EthError{Code: -32000, Message: "header not found"}
+			return resp
+		}
+		resp.Result = Balance(number)
+	default:
+		resp.Error = &model.EthError{
+			Code:    -32601,
+			Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method),
+		}
+	}
+	return resp
+}
+
+// logs serves eth_getLogs by a block hash or by a range, a range is 'latest' by default
+func (n *Node) logs(filter *model.LogFilter, head uint64) ([]*model.Log, *model.EthError) {
+	if filter.BlockHash != "" {
+		number, fork, ok := parseBlockHash(filter.BlockHash)
+		if !ok || number > head || fork != n.fork(number) {
+			return nil, &model.EthError{Code: -32000, Message: "unknown block"}
+		}
+		return logs(n.canonical(number), filter), nil
+	}
+	bound := func(tag string) (uint64, bool) {
+		if tag == "" {
+			tag = "latest"
+		}
+		return resolve(tag, head)
+	}
+	from, okFrom := bound(filter.FromBlock)
+	to, okTo := bound(filter.ToBlock)
+	if !okFrom || !okTo {
+		return nil, &model.EthError{Code: -32602, Message: "invalid argument 0: hex string without 0x prefix"}
+	}
+	if to > head {
+		to = head
+	}
+	// providers limit ranges as well
+	if to >= from && to-from >= MaxLogsRange {
+		return nil, &model.EthError{Code: -32005, Message: fmt.Sprintf("block range is too wide, %d blocks at most", MaxLogsRange)}
+	}
+	found := []*model.Log{}
+	for number := from; number <= to; number++ {
+		found = append(found, logs(n.canonical(number), filter)...)
+	}
+	return found, nil
+}
+
+// canonical is the block with a number on the current chain
+func (n *Node) canonical(number uint64) *model.Block {
+	return n.forked(number, n.fork(number))
+}
+
+// forked is the block with a number of a fork, the parent is the one that was canonical when the fork happened
+func (n *Node) forked(number uint64, fork int) *model.Block {
+	seen, parentFork := 0, 0
+	for _, from := range n.forks {
+		if seen == fork {
+			break
+		}
+		if from <= number {
+			seen++
+		}
+		if number > 0 && from <= number-1 {
+			parentFork++
+		}
+	}
+	return block(number, fork, parentFork)
+}
+
+// blockResult is a block with full transactions or with their hashes only
+func blockResult(b *model.Block, full bool) interface{} {
+	if full {
+		return b
+	}
+	hashes := make([]string, len(b.Transactions))
+	for i, t := range b.Transactions {
+		hashes[i] = t.Hash
+	}
+	return &struct {
+		model.NoTransactionBlock
+		Transactions []string `json:"transactions"`
+	}{b.NoTransactionBlock, hashes}
+}
+
+func fullTx(params []json.RawMessage) bool {
+	var full bool
+	if len(params) > 1 {
+		json.Unmarshal(params[1], &full)
+	}
+	return full
+}
+
+func resolve(tag string, head uint64) (uint64, bool) {
+	switch tag {
+	case "latest", "pending":
+		return head, true
+	case "earliest":
+		return 0, true
+	case "safe":
+		return head - SafeDepth, head >= SafeDepth
+	case "finalized":
+		return head - FinalizedDepth, head >= FinalizedDepth
+	}
+	if len(tag) < 3 || tag[:2] != "0x" {
+		return 0, false
+	}
+	number, err := strconv.ParseUint(tag[2:], 16, 64)
+	return number, err == nil
+}
+
+// TxCount is the number of transactions in a synthetic block
+func TxCount(number uint64) int {
+	return 2 + int(number%5)
+}
+
+// Balance is the balance of every account at a block, it grows by 1 ether a block
+func Balance(number uint64) string {
+	return "0x" + new(big.Int).Mul(new(big.Int).SetUint64(number), big.NewInt(1e18)).Text(16)
+}
+
+// BlockHash is the hash of a synthetic block before any reorganization
+func BlockHash(number uint64) string {
+	return blockHash(number, 0)
+}
+
+// blockHash starts with the number and the fork of a block, so the node can find a block by its hash
+func blockHash(number uint64, fork int) string {
+	seed := hash(fmt.Sprintf("block:%d:%d", number, fork))
+	return fmt.Sprintf("0x%016x%04x%s", number, fork, seed[2:46])
+}
+
+func parseBlockHash(h string) (uint64, int, bool) {
+	number, fork, ok := parseHash(h)
+	return number, fork, ok && blockHash(number, fork) == h
+}
+
+// parseHash splits a synthetic hash into the number and the second field: a fork or an index
+func parseHash(h string) (uint64, int, bool) {
+	if len(h) != 66 || h[:2] != "0x" {
+		return 0, 0, false
+	}
+	number, err := strconv.ParseUint(h[2:18], 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	second, err := strconv.ParseUint(h[18:22], 16, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	return number, int(second), true
+}
+
+// TxHash is the hash of the transaction with an index in a synthetic block.
+// It starts with the number and the index, so the node can find a transaction by its hash
+func TxHash(number uint64, index int) string {
+	seed := hash(fmt.Sprintf("tx:%d:%d", number, index))
+	return fmt.Sprintf("0x%016x%04x%s", number, index, seed[2:46])
+}
+
+func parseTxHash(h string) (uint64, int, bool) {
+	number, index, ok := parseHash(h)
+	return number, index, ok && index < TxCount(number) && TxHash(number, index) == h
+}
+
+// Block builds the synthetic block with a number before any reorganization
+func Block(number uint64) *model.Block {
+	return block(number, 0, 0)
+}
+
+func block(number uint64, fork int, parentFork int) *model.Block {
+	parent := "0x" + fmt.Sprintf("%064x", 0)
+	if number > 0 {
+		parent = blockHash(number-1, parentFork)
+	}
+	b := &model.Block{
+		NoTransactionBlock: model.NoTransactionBlock{
+			Difficulty:       "0x1",
+			ExtraData:        "0x",
+			GasLimit:         "0x1c9c380",
+			GasUsed:          fmt.Sprintf("0x%x", 21000*TxCount(number)),
+			Hash:             blockHash(number, fork),
+			Miner:            "0x" + fmt.Sprintf("%040x", number%16),
+			MixHash:          hash(fmt.Sprintf("mix:%d", number)),
+			Nonce:            "0x0000000000000000",
+			Number:           fmt.Sprintf("0x%x", number),
+			ParentHash:       parent,
+			ReceiptsRoot:     hash(fmt.Sprintf("receipts:%d", number)),
+			Sha3Uncles:       hash(fmt.Sprintf("uncles:%d", number)),
+			Size:             "0x220",
+			StateRoot:        hash(fmt.Sprintf("state:%d", number)),
+			Timestamp:        fmt.Sprintf("0x%x", 1438269973+13*number),
+			TotalDifficulty:  fmt.Sprintf("0x%x", number+1),
+			TransactionsRoot: hash(fmt.Sprintf("txs:%d", number)),
+			Uncles:           []string{},
+		},
+	}
+	b.Transactions = make([]*model.Transaction, TxCount(number))
+	for i := range b.Transactions {
+		b.Transactions[i] = &model.Transaction{
+			BlockHash:        b.Hash,
+			BlockNumber:      b.Number,
+			From:             "0x" + fmt.Sprintf("%040x", i+1),
+			Gas:              "0x5208",
+			GasPrice:         "0x3b9aca00",
+			Hash:             TxHash(number, i),
+			Input:            "0x",
+			Nonce:            fmt.Sprintf("0x%x", number),
+			To:               "0x" + fmt.Sprintf("%040x", i+2),
+			TransactionIndex: fmt.Sprintf("0x%x", i),
+			Value:            fmt.Sprintf("0x%x", uint64(i+1)*1000000000),
+			V:                "0x25",
+			R:                hash(fmt.Sprintf("r:%d:%d", number, i)),
+			S:                hash(fmt.Sprintf("s:%d:%d", number, i)),
+		}
+	}
+	var all []*model.Log
+	for _, r := range receipts(b) {
+		all = append(all, r.Logs...)
+	}
+	b.LogsBloom = bloom(all)
+	return b
+}
+
+func hash(seed string) string {
+	return fmt.Sprintf("0x%x", sha256.Sum256([]byte(seed)))
+}
@@ -0,0 +1,109 @@
+package fakenode
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"my.eth.test/model"
+)
+
+// TransferTopic is the topic of the ERC-20 Transfer event every successful synthetic transaction emits
+const TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// Failed says whether a synthetic transaction has failed, a failed one has no logs
+func Failed(number uint64, index int) bool {
+	return (number+uint64(index))%7 == 0
+}
+
+// Receipts builds receipts of the synthetic block with a number before any reorganization
+func Receipts(number uint64) model.Receipts {
+	return receipts(Block(number))
+}
+
+func receipts(b *model.Block) model.Receipts {
+	rs := make(model.Receipts, len(b.Transactions))
+	for i := range b.Transactions {
+		rs[i] = receipt(b, i)
+	}
+	return rs
+}
+
+func receipt(b *model.Block, index int) *model.Receipt {
+	t := b.Transactions[index]
+	to := t.To
+	r := &model.Receipt{
+		BlockHash:         b.Hash,
+		BlockNumber:       b.Number,
+		CumulativeGasUsed: fmt.Sprintf("0x%x", 21000*(index+1)),
+		EffectiveGasPrice: t.GasPrice,
+		From:              t.From,
+		GasUsed:           "0x5208",
+		Logs:              []*model.Log{},
+		Status:            "0x1",
+		To:                &to,
+		TransactionHash:   t.Hash,
+		TransactionIndex:  t.TransactionIndex,
+		Type:              "0x0",
+	}
+	var number uint64
+	fmt.Sscanf(b.Number, "0x%x", &number)
+	if Failed(number, index) {
+		r.Status = "0x0"
+		r.LogsBloom = bloom(r.Logs)
+		return r
+	}
+	// a log index counts logs of the whole block
+	logIndex := 0
+	for i := 0; i < index; i++ {
+		if !Failed(number, i) {
+			logIndex++
+		}
+	}
+	r.Logs = append(r.Logs, &model.Log{
+		Address:          t.To,
+		Topics:           []string{TransferTopic, topic(t.From), topic(t.To)},
+		Data:             fmt.Sprintf("0x%064x", index+1),
+		BlockNumber:      b.Number,
+		BlockHash:        b.Hash,
+		TransactionHash:  t.Hash,
+		TransactionIndex: t.TransactionIndex,
+		LogIndex:         fmt.Sprintf("0x%x", logIndex),
+	})
+	r.LogsBloom = bloom(r.Logs)
+	return r
+}
+
+// bloom is the logsBloom of logs: addresses and topics of every log
+func bloom(logs []*model.Log) string {
+	var b model.Bloom
+	for _, l := range logs {
+		b.Add(decode(l.Address))
+		for _, t := range l.Topics {
+			b.Add(decode(t))
+		}
+	}
+	return b.String()
+}
+
+func decode(h string) []byte {
+	data, _ := hex.DecodeString(h[2:])
+	return data
+}
+
+// logs are the logs of a block passing a filter
+func logs(b *model.Block, filter *model.LogFilter) []*model.Log {
+	found := []*model.Log{}
+	for _, r := range receipts(b) {
+		for _, l := range r.Logs {
+			if filter.Matches(l) {
+				found = append(found, l)
+			}
+		}
+	}
+	return found
+}
+
+// topic pads an address to 32 bytes
+func topic(address string) string {
+	return "0x000000000000000000000000" + address[2:]
+}
@@ -0,0 +1,109 @@
+package fakenode
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fasthttp/websocket"
+	"my.eth.test/model"
+)
+
+// subscriptionID is the id of every newHeads subscription, a connection has one at most
+const subscriptionID = "0x1"
+
+var upgrader = websocket.Upgrader{}
+
+// notification is a message of a subscription
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  struct {
+		Subscription string      `json:"subscription"`
+		Result       interface{} `json:"result"`
+	} `json:"params"`
+}
+
+// subscriber is a connection subscribed to newHeads
+type subscriber struct {
+	conn *websocket.Conn
+	lock sync.Mutex // a connection has one writer at once
+}
+
+func (s *subscriber) write(v interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conn.WriteJSON(v)
+}
+
+// WSURL is the address to subscribe to new heads of the node over a WebSocket
+func (n *Node) WSURL() string {
+	return "ws" + strings.TrimPrefix(n.server.URL, "http")
+}
+
+// DropSubscriptions closes every WebSocket connection as a restarting node does
+func (n *Node) DropSubscriptions() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for s := range n.subscribers {
+		s.conn.Close()
+		delete(n.subscribers, s)
+	}
+}
+
+// serveWebSocket answers eth_subscribe newHeads, other methods are served like over HTTP
+func (n *Node) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s := &subscriber{conn: conn}
+	defer func() {
+		n.lock.Lock()
+		delete(n.subscribers, s)
+		n.lock.Unlock()
+		conn.Close()
+	}()
+	for {
+		req := new(request)
+		if err := conn.ReadJSON(req); err != nil {
+			return
+		}
+		if req.Method != "eth_subscribe" {
+			if s.write(n.handle(req)) != nil {
+				return
+			}
+			continue
+		}
+		n.lock.Lock()
+		n.calls[req.Method]++
+		n.lock.Unlock()
+		var kind string
+		if len(req.Params) == 0 || json.Unmarshal(req.Params[0], &kind) != nil || kind != "newHeads" {
+			err = s.write(&response{
+				JSONRPC: "2.0",
+				ID:      req.ID,
+				Error:   &model.EthError{Code: -32602, Message: "invalid argument 0: only newHeads is supported"},
+			})
+		} else {
+			n.lock.Lock()
+			n.subscribers[s] = struct{}{}
+			n.lock.Unlock()
+			err = s.write(&response{JSONRPC: "2.0", ID: req.ID, Result: subscriptionID})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// notifyHeads sends a header of the head to every subscriber, the node must be locked
+func (n *Node) notifyHeads() {
+	note := &notification{JSONRPC: "2.0", Method: "eth_subscription"}
+	note.Params.Subscription = subscriptionID
+	note.Params.Result = &n.canonical(n.head).NoTransactionBlock
+	for s := range n.subscribers {
+		go s.write(note)
+	}
+}
@@ -4,6 +4,9 @@ go 1.15
 
 require (
 	github.com/fasthttp/router v1.3.6
+	github.com/fasthttp/websocket v1.4.3
+	github.com/graph-gophers/graphql-go v1.3.0
 	github.com/karlseguin/ccache/v2 v2.0.8
 	github.com/valyala/fasthttp v1.20.0
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
 )
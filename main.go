@@ -5,39 +5,137 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"strings"
+	"time"
 
 	"github.com/karlseguin/ccache/v2"
 
 	"my.eth.test/client"
 	"my.eth.test/logger"
 	"my.eth.test/server"
+	"my.eth.test/store"
+	"my.eth.test/webhook"
 )
 
 func main() {
 	host := flag.String("host", "localhost", "a hostname to start a service. default=localhost")
 	port := flag.Uint("port", 8080, "a port to start service. default=8080")
-	etherAddr := flag.String("node", "https://cloudflare-eth.com", "an address of an ether node to request blocks. default=https://cloudflare-eth.com")
-	cacheSize := flag.Int64("csize", 0, "a cache size to store blocks. default=MaxInt64")
+	etherAddr := flag.String("node", "https://cloudflare-eth.com", "addresses of ether nodes to request blocks separated by commas, in the order of priority. default=https://cloudflare-eth.com")
+	var cacheSize, storeSize byteSize
+	flag.Var(&cacheSize, "csize", "a cache size to store blocks in bytes with an optional unit like 2GiB or 512MB. default=MaxInt64")
+	timeout := flag.Duration("timeout", 30*time.Second, "the longest time to serve a request, clients can shorten it with the X-Request-Timeout header. default=30s")
+	latestWindow := flag.Duration("lwindow", 500*time.Millisecond, "how long a received latest block is shared by new requests, a negative value disables it. default=500ms")
+	confirmations := flag.Uint64("confirmations", 20, "how many blocks must be on top of a block to cache it. default=20")
+	finality := flag.String("finality", "", "the 'safe' or 'finalized' tag to cache blocks up to the tagged one, -confirmations is the fallback. default is none")
+	reorgWindow := flag.Uint64("reorgwindow", 256, "how many recent blocks are remembered to notice reorganizations. default=256")
+	storeDir := flag.String("store", "", "a directory of the persistent block store, it's consulted after the memory cache. default is none")
+	flag.Var(&storeSize, "storesize", "the size limit of the persistent block store in bytes with an optional unit like 20GiB. default is unlimited")
+	maxAge := flag.Duration("maxage", 2*time.Second, "how long caches keep a response of a block that may still change, final blocks are immutable. default=2s")
+	compressMinSize := flag.Int("cminsize", 1024, "the smallest response body in bytes to compress with gzip, deflate or brotli, a negative value disables compression. default=1024")
+	precompress := flag.Bool("precompress", false, "keep compressed responses of final blocks in the block cache, so they aren't compressed on every request. default=false")
+	streamBacklog := flag.Uint64("sbacklog", 128, "how many blocks behind the head a block stream can resume from. default=128")
+	hooks := flag.Bool("hooks", false, "deliver new blocks and matching transactions to webhooks registered over /hooks. default=false")
+	hookStore := flag.String("hookstore", "", "a directory to keep webhooks and their deliveries, it implies -hooks. default is none, they're kept in memory")
+	hookAttempts := flag.Int("hookattempts", 8, "how many times a webhook delivery is sent before it fails. default=8")
+	rangeConcurrency := flag.Int("rconcurrency", 4, "how many chunks of 20 blocks of a /blocks range are requested at once. default=4")
+	indexSize := flag.Int64("isize", 1<<20, "how many entries of the block hash and transaction hash indexes are kept in memory. default=1048576")
+	maxBatch := flag.Int("batch", 100, "the largest number of calls in a json-rpc batch to ether nodes. default=100")
+	logsRange := flag.Uint64("logsrange", 1000, "the widest cacheable block range of logs served block by block, a wider one is forwarded. default=1000")
+	healthInterval := flag.Duration("hinterval", 10*time.Second, "how often ether nodes are probed. default=10s")
+	healthLag := flag.Uint64("hlag", 5, "how many blocks a node can be behind the best one to stay healthy. default=5")
+	healthLatency := flag.Duration("hlatency", 5*time.Second, "the slowest acceptable answer of a healthy node. default=5s")
+	healthErrors := flag.Float64("herrors", 0.5, "the share of failed requests to mark a node unhealthy. default=0.5")
+	headPoll := flag.Duration("headpoll", 2*time.Second, "how often eth_blockNumber is polled while there's no newHeads subscription. default=2s")
+	headWS := flag.String("headws", "", "a ws:// address of an ether node to follow the head over eth_subscribe newHeads. default is none, the head is polled")
+	retries := flag.Int("retries", 3, "how many times a request to ether nodes is attempted on transient errors. default=3")
+	retryBase := flag.Duration("rbase", 100*time.Millisecond, "a delay before the first retry, it's doubled every next one. default=100ms")
+	retryMax := flag.Duration("rmax", 2*time.Second, "the upper limit of a delay between retries. default=2s")
+	breakerThreshold := flag.Int("bthreshold", 5, "consecutive failures of a node to open its circuit breaker. default=5")
+	breakerCooldown := flag.Duration("bcooldown", 30*time.Second, "how long a circuit breaker stays open before a trial request. default=30s")
 	flag.Parse()
 
 	// create cache
 	var size int64
-	if *cacheSize > 0 {
-		size = *cacheSize
+	if cacheSize > 0 {
+		size = int64(cacheSize)
 	} else {
 		size = math.MaxInt64
 	}
-	cache := ccache.New(ccache.Configure().Buckets(256).ItemsToPrune(100).MaxSize(size))
+	// the size is in bytes, so a few blocks are enough to prune when the cache is full
+	cache := ccache.New(ccache.Configure().Buckets(256).ItemsToPrune(10).MaxSize(size))
 
 	// create client to request blocks
 	log.SetFlags(0)
 	log.SetOutput(new(logger.Logger))
-	locclient, err := client.NewJRClient(*etherAddr, cache)
+	conf := client.Config{
+		Health: client.HealthConfig{
+			Interval:     *healthInterval,
+			MaxLag:       *healthLag,
+			MaxLatency:   *healthLatency,
+			MaxErrorRate: *healthErrors,
+		},
+		Retry: client.RetryPolicy{
+			MaxAttempts: *retries,
+			BaseDelay:   *retryBase,
+			MaxDelay:    *retryMax,
+		},
+		Breaker: client.BreakerConfig{
+			Threshold: *breakerThreshold,
+			Cooldown:  *breakerCooldown,
+		},
+		Head: client.HeadConfig{
+			Interval:  *headPoll,
+			WebSocket: *headWS,
+		},
+		LatestWindow:  *latestWindow,
+		Confirmations: *confirmations,
+		Finality:      *finality,
+		ReorgWindow:   *reorgWindow,
+		MaxBatch:      *maxBatch,
+		IndexSize:     *indexSize,
+		MaxLogsRange:  *logsRange,
+	}
+	if *storeDir != "" {
+		// every write is synced, so the store survives the process exit without closing
+		st, err := store.Open(*storeDir, store.Options{MaxBytes: int64(storeSize)})
+		if err != nil {
+			log.Fatal(err)
+		}
+		conf.Store = st
+	}
+	locclient, err := client.NewJRClient(strings.Split(*etherAddr, ","), cache, conf)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// create server
-	server := server.NewRouterToServe(*host, fmt.Sprint(*port), locclient)
+	serverConf := server.Config{
+		Timeout:          *timeout,
+		RangeConcurrency: *rangeConcurrency,
+		MaxAge:           *maxAge,
+		CompressMinSize:  *compressMinSize,
+		StreamBacklog:    *streamBacklog,
+	}
+	if *precompress {
+		// compressed variants share the size limit of -csize with blocks
+		serverConf.Variants = cache
+	}
+	if *hooks || *hookStore != "" {
+		hookConf := webhook.Config{MaxAttempts: *hookAttempts}
+		if *hookStore != "" {
+			// the block store drops old segments over its size limit, so hooks have a store of their own
+			st, err := store.Open(*hookStore, store.Options{})
+			if err != nil {
+				log.Fatal(err)
+			}
+			hookConf.Store = st
+		}
+		m, err := webhook.New(locclient, hookConf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serverConf.Hooks = m
+	}
+	server := server.NewRouterToServe(*host, fmt.Sprint(*port), locclient, serverConf)
 	log.Fatal(server.Serve())
 }
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Bloom is the 2048 bit filter of a logsBloom field. It holds addresses and topics of logs,
+// so a value the bloom doesn't contain surely isn't in any log of a block or a receipt
+type Bloom [256]byte
+
+// ParseBloom decodes a logsBloom field
+func ParseBloom(s string) (Bloom, bool) {
+	var b Bloom
+	data, err := decodeHex(s)
+	if err != nil || len(data) != len(b) {
+		return b, false
+	}
+	copy(b[:], data)
+	return b, true
+}
+
+// Add puts a value to the bloom
+func (b *Bloom) Add(data []byte) {
+	for _, i := range bloomBits(data) {
+		b[len(b)-1-i/8] |= 1 << (i % 8)
+	}
+}
+
+// Test says whether the bloom may contain a value
+func (b *Bloom) Test(data []byte) bool {
+	for _, i := range bloomBits(data) {
+		if b[len(b)-1-i/8]&(1<<(i%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty says whether nothing is in the bloom, a block with an empty bloom has no logs
+func (b *Bloom) Empty() bool {
+	return *b == Bloom{}
+}
+
+func (b Bloom) String() string {
+	return "0x" + hex.EncodeToString(b[:])
+}
+
+// bloomBits are the three bits a value sets: the low 11 bits of the first three pairs of bytes of its keccak256
+func bloomBits(data []byte) [3]int {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	sum := h.Sum(nil)
+	var bits [3]int
+	for i := range bits {
+		bits[i] = (int(sum[2*i])<<8 | int(sum[2*i+1])) & 2047
+	}
+	return bits
+}
+
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+}
@@ -22,20 +22,123 @@ func (err *NotFoundHashTransactionError) Error() string {
 	return fmt.Sprintf("the transaction with Hash=%s not found in a requested block (blockHash=%s)", err.Hash, err.BlockHash)
 }
 
+// NotFoundBlockError to report that an ether node doesn't know a requested block
+type NotFoundBlockError struct {
+	Identifier string
+}
+
+func (err *NotFoundBlockError) Error() string {
+	return fmt.Sprintf("the block %s not found", err.Identifier)
+}
+
 // InvalidIdentifierError to report that a block identifier to request Block from the ether node is invalid
 type InvalidIdentifierError struct {
 	Identifier string
+	Reason     string // what's wrong with it, optional
 }
 
 func (err *InvalidIdentifierError) Error() string {
+	if err.Reason != "" {
+		return fmt.Sprintf("an identifier: '%s' is invalid: %s", err.Identifier, err.Reason)
+	}
 	return fmt.Sprintf("an identifier: '%s' is invalid", err.Identifier)
 }
 
 // ResponseContentError to report that there is an error in an ethereum node response
 type ResponseContentError struct {
+	Code    int64
 	Message string
 }
 
 func (err *ResponseContentError) Error() string {
 	return fmt.Sprintf("Ethereum node has returned an error with message: %s", err.Message)
 }
+
+// NoHealthyUpstreamError to report that every configured ether node has failed a request
+type NoHealthyUpstreamError struct {
+	LastError error
+}
+
+func (err *NoHealthyUpstreamError) Error() string {
+	return fmt.Sprintf("no ether node could serve a request, the last error: %s", err.LastError)
+}
+
+func (err *NoHealthyUpstreamError) Unwrap() error {
+	return err.LastError
+}
+
+// UpstreamStatusError to report that an ether node has answered with a non 200 HTTP status
+type UpstreamStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (err *UpstreamStatusError) Error() string {
+	return fmt.Sprintf("%s answered with status code %d", err.URL, err.StatusCode)
+}
+
+// CircuitOpenError to report that requests to an ether node are blocked by its circuit breaker
+type CircuitOpenError struct {
+	URL string
+}
+
+func (err *CircuitOpenError) Error() string {
+	return fmt.Sprintf("the circuit breaker of %s is open", err.URL)
+}
+
+// InvalidHeaderError to report that a request header has an invalid value
+type InvalidHeaderError struct {
+	Header string
+	Value  string
+}
+
+func (err *InvalidHeaderError) Error() string {
+	return fmt.Sprintf("the header %s has an invalid value: '%s'", err.Header, err.Value)
+}
+
+// InvalidQueryParamError to report that a query param is missing or invalid
+type InvalidQueryParamError struct {
+	Param string
+	Value string
+}
+
+func (err *InvalidQueryParamError) Error() string {
+	return fmt.Sprintf("the query param %s has an invalid value: '%s'", err.Param, err.Value)
+}
+
+// NotFoundTransactionError to report that an ether node doesn't know a transaction
+type NotFoundTransactionError struct {
+	Hash string
+}
+
+func (err *NotFoundTransactionError) Error() string {
+	return fmt.Sprintf("the transaction with the hash %s not found", err.Hash)
+}
+
+// InvalidHookError to report that a webhook can't be registered
+type InvalidHookError struct {
+	Reason string
+}
+
+func (err *InvalidHookError) Error() string {
+	return fmt.Sprintf("the webhook is invalid: %s", err.Reason)
+}
+
+// NotFoundHookError to report that a webhook isn't registered
+type NotFoundHookError struct {
+	ID string
+}
+
+func (err *NotFoundHookError) Error() string {
+	return fmt.Sprintf("the webhook %s not found", err.ID)
+}
+
+// NotFoundDeliveryError to report that a webhook has no such delivery
+type NotFoundDeliveryError struct {
+	Hook string
+	ID   string
+}
+
+func (err *NotFoundDeliveryError) Error() string {
+	return fmt.Sprintf("the delivery %s of the webhook %s not found", err.ID, err.Hook)
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// LogFilter is the filter object of eth_getLogs. A log matches when its address is any of Address
+// and every position of Topics has its topic. An empty Address or an empty position matches anything
+type LogFilter struct {
+	FromBlock string     `json:"fromBlock,omitempty"`
+	ToBlock   string     `json:"toBlock,omitempty"`
+	BlockHash string     `json:"blockHash,omitempty"`
+	Address   []string   `json:"address,omitempty"`
+	Topics    [][]string `json:"topics,omitempty"`
+}
+
+// UnmarshalJSON accepts an address and a topic position either as a single value or as a list, a position can be null
+func (f *LogFilter) UnmarshalJSON(data []byte) error {
+	raw := new(struct {
+		FromBlock string            `json:"fromBlock"`
+		ToBlock   string            `json:"toBlock"`
+		BlockHash string            `json:"blockHash"`
+		Address   json.RawMessage   `json:"address"`
+		Topics    []json.RawMessage `json:"topics"`
+	})
+	if err := json.Unmarshal(data, raw); err != nil {
+		return err
+	}
+	address, err := oneOrMany(raw.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address: %s", err.Error())
+	}
+	topics := make([][]string, len(raw.Topics))
+	for i, position := range raw.Topics {
+		if topics[i], err = oneOrMany(position); err != nil {
+			return fmt.Errorf("invalid topic %d: %s", i, err.Error())
+		}
+	}
+	if len(topics) == 0 {
+		topics = nil
+	}
+	*f = LogFilter{
+		FromBlock: raw.FromBlock,
+		ToBlock:   raw.ToBlock,
+		BlockHash: raw.BlockHash,
+		Address:   address,
+		Topics:    topics,
+	}
+	return nil
+}
+
+func oneOrMany(data json.RawMessage) ([]string, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		return []string{one}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return nil, err
+	}
+	return many, nil
+}
+
+// Matches says whether a log passes the address and topic criteria of the filter
+func (f *LogFilter) Matches(l *Log) bool {
+	if len(f.Address) > 0 && !anyOf(f.Address, l.Address) {
+		return false
+	}
+	for i, position := range f.Topics {
+		if len(position) == 0 {
+			continue
+		}
+		if i >= len(l.Topics) || !anyOf(position, l.Topics[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MayMatch says whether a block or a receipt with a bloom may have logs passing the filter.
+// A value that isn't valid hex can't be tested, so it's taken as present
+func (f *LogFilter) MayMatch(b *Bloom) bool {
+	if len(f.Address) > 0 && !mayContainAny(b, f.Address) {
+		return false
+	}
+	for _, position := range f.Topics {
+		if len(position) > 0 && !mayContainAny(b, position) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyOf(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func mayContainAny(b *Bloom, values []string) bool {
+	for _, v := range values {
+		data, err := decodeHex(v)
+		if err != nil || b.Test(data) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// how transactions of a block are shown
+const (
+	TxsFull   = "full"
+	TxsHashes = "hashes"
+	TxsNone   = "none"
+)
+
+// blockFields are the json names of block fields in the order of serialization
+var blockFields = jsonNames(reflect.TypeOf(Block{}))
+
+// Projection chooses what of a block is serialized: the fields by their json names
+// and transactions as whole objects, as hashes or not at all
+type Projection struct {
+	Txs    string
+	Fields map[string]bool // every field when it's empty
+}
+
+// NewProjection checks the transactions view and the field names. An empty view is hashes,
+// transactions are shown only when no fields are chosen or 'transactions' is one of them
+func NewProjection(txs string, fields []string) (*Projection, error) {
+	p := &Projection{Txs: txs, Fields: make(map[string]bool, len(fields))}
+	switch txs {
+	case "":
+		p.Txs = TxsHashes
+	case TxsFull, TxsHashes, TxsNone:
+	default:
+		return nil, &InvalidQueryParamError{Param: "txs", Value: txs}
+	}
+	known := make(map[string]bool, len(blockFields))
+	for _, name := range blockFields {
+		known[name] = true
+	}
+	for _, name := range fields {
+		if !known[name] {
+			return nil, &InvalidQueryParamError{Param: "fields", Value: name}
+		}
+		p.Fields[name] = true
+	}
+	return p, nil
+}
+
+// String is the canonical form of a projection: the view and the chosen fields in the order of serialization
+func (p *Projection) String() string {
+	var fields []string
+	for _, name := range blockFields {
+		if p.Fields[name] {
+			fields = append(fields, name)
+		}
+	}
+	return "txs=" + p.Txs + "&fields=" + strings.Join(fields, ",")
+}
+
+// Project serializes the chosen part of a block as a json object, fields keep the order of Block
+func (b *Block) Project(p *Projection) ([]byte, error) {
+	header, err := json.Marshal(&b.NoTransactionBlock)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]json.RawMessage, len(blockFields))
+	if err := json.Unmarshal(header, &values); err != nil {
+		return nil, err
+	}
+	switch p.Txs {
+	case TxsFull:
+		values["transactions"], err = json.Marshal(b.Transactions)
+	case TxsHashes:
+		values["transactions"], err = json.Marshal(hashes(b.Transactions, b.Hash))
+	default:
+		delete(values, "transactions")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, name := range blockFields {
+		value, ok := values[name]
+		if !ok || len(p.Fields) > 0 && !p.Fields[name] {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonNames lists json names of struct fields, fields of embedded structs are inlined
+func jsonNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			names = append(names, jsonNames(f.Type)...)
+			continue
+		}
+		names = append(names, strings.Split(f.Tag.Get("json"), ",")[0])
+	}
+	return names
+}
@@ -0,0 +1,41 @@
+package model
+
+// Receipt json response of eth_getTransactionReceipt
+type Receipt struct {
+	BlockHash         string  `json:"blockHash"`
+	BlockNumber       string  `json:"blockNumber"`
+	ContractAddress   *string `json:"contractAddress"` // null unless the transaction creates a contract
+	CumulativeGasUsed string  `json:"cumulativeGasUsed"`
+	EffectiveGasPrice string  `json:"effectiveGasPrice"`
+	From              string  `json:"from"`
+	GasUsed           string  `json:"gasUsed"`
+	Logs              []*Log  `json:"logs"`
+	LogsBloom         string  `json:"logsBloom"`
+	Status            string  `json:"status"`
+	To                *string `json:"to"` // null for a contract creation
+	TransactionHash   string  `json:"transactionHash"`
+	TransactionIndex  string  `json:"transactionIndex"`
+	Type              string  `json:"type"`
+}
+
+// Log json response, an event emitted by a transaction
+type Log struct {
+	Address          string   `json:"address"`
+	Topics           []string `json:"topics"`
+	Data             string   `json:"data"`
+	BlockNumber      string   `json:"blockNumber"`
+	BlockHash        string   `json:"blockHash"`
+	TransactionHash  string   `json:"transactionHash"`
+	TransactionIndex string   `json:"transactionIndex"`
+	LogIndex         string   `json:"logIndex"`
+	Removed          bool     `json:"removed"`
+}
+
+// Receipts are receipts of every transaction of a block in the order of transactions
+type Receipts []*Receipt
+
+// BlockLogs are every log of a block, the hash tells which block they belong to after a reorganization
+type BlockLogs struct {
+	Hash string `json:"hash"`
+	Logs []*Log `json:"logs"`
+}
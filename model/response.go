@@ -1,11 +1,21 @@
 package model
 
-// RespJSON is the dto to unmarshal json resp
-type RespJSON struct {
-	JSONRPC string    `json:"jsonrpc"`
-	Result  *Block    `json:"result"`
-	ID      int       `json:"id"`
-	Error   *EthError `json:"error"`
+import "encoding/json"
+
+// RPCRequest is the dto to marshal a json-rpc request
+type RPCRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+	ID      int         `json:"id"`
+}
+
+// RPCResponse is the dto to unmarshal json resp. Result is decoded by a caller
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	ID      int             `json:"id"`
+	Error   *EthError       `json:"error"`
 }
 
 // EthError json
@@ -32,7 +42,7 @@ func (b *Block) ToShowcase() *ShowcaseBlock {
 
 func hashes(txs []*Transaction, blockHash string) []string {
 	tHashes := make([]string, len(txs))
-	for i, t := range txs{
+	for i, t := range txs {
 		tHashes[i] = t.Hash
 	}
 	return tHashes
@@ -0,0 +1,92 @@
+package model
+
+import "unsafe"
+
+// Size is the memory footprint of a block in bytes, so the cache counts bytes instead of blocks.
+// It implements ccache.Sized. The "size" field of a node's answer is b.NoTransactionBlock.Size
+func (b *Block) Size() int64 {
+	size := int64(unsafe.Sizeof(*b))
+	size += stringsSize(
+		b.Difficulty, b.ExtraData, b.GasLimit, b.GasUsed, b.Hash, b.LogsBloom, b.Miner,
+		b.MixHash, b.Nonce, b.Number, b.ParentHash, b.ReceiptsRoot, b.Sha3Uncles,
+		b.NoTransactionBlock.Size, b.StateRoot, b.Timestamp, b.TotalDifficulty, b.TransactionsRoot,
+	)
+	size += int64(cap(b.Uncles)) * int64(unsafe.Sizeof(""))
+	size += stringsSize(b.Uncles...)
+	size += int64(cap(b.Transactions)) * int64(unsafe.Sizeof(b))
+	for _, t := range b.Transactions {
+		if t != nil {
+			size += t.Size()
+		}
+	}
+	return size
+}
+
+// Size is the memory footprint of a transaction in bytes
+func (t *Transaction) Size() int64 {
+	return int64(unsafe.Sizeof(*t)) + stringsSize(
+		t.BlockHash, t.BlockNumber, t.From, t.Gas, t.GasPrice, t.Hash, t.Input,
+		t.Nonce, t.To, t.TransactionIndex, t.Value, t.V, t.R, t.S,
+	)
+}
+
+// Size is the memory footprint of receipts of a block in bytes
+func (rs Receipts) Size() int64 {
+	size := int64(unsafe.Sizeof(rs)) + int64(cap(rs))*int64(unsafe.Sizeof(&Receipt{}))
+	for _, r := range rs {
+		if r != nil {
+			size += r.Size()
+		}
+	}
+	return size
+}
+
+// Size is the memory footprint of a receipt in bytes
+func (r *Receipt) Size() int64 {
+	size := int64(unsafe.Sizeof(*r)) + stringsSize(
+		r.BlockHash, r.BlockNumber, r.CumulativeGasUsed, r.EffectiveGasPrice, r.From,
+		r.GasUsed, r.LogsBloom, r.Status, r.TransactionHash, r.TransactionIndex, r.Type,
+	)
+	if r.ContractAddress != nil {
+		size += int64(unsafe.Sizeof("")) + int64(len(*r.ContractAddress))
+	}
+	if r.To != nil {
+		size += int64(unsafe.Sizeof("")) + int64(len(*r.To))
+	}
+	size += int64(cap(r.Logs)) * int64(unsafe.Sizeof(&Log{}))
+	for _, l := range r.Logs {
+		if l != nil {
+			size += l.Size()
+		}
+	}
+	return size
+}
+
+// Size is the memory footprint of logs of a block in bytes
+func (bl *BlockLogs) Size() int64 {
+	size := int64(unsafe.Sizeof(*bl)) + int64(len(bl.Hash)) + int64(cap(bl.Logs))*int64(unsafe.Sizeof(&Log{}))
+	for _, l := range bl.Logs {
+		if l != nil {
+			size += l.Size()
+		}
+	}
+	return size
+}
+
+// Size is the memory footprint of a log in bytes
+func (l *Log) Size() int64 {
+	size := int64(unsafe.Sizeof(*l)) + stringsSize(
+		l.Address, l.Data, l.BlockNumber, l.BlockHash, l.TransactionHash, l.TransactionIndex, l.LogIndex,
+	)
+	size += int64(cap(l.Topics)) * int64(unsafe.Sizeof(""))
+	return size + stringsSize(l.Topics...)
+}
+
+// stringsSize counts the bytes strings point to, their headers are counted by the owner
+func stringsSize(strs ...string) int64 {
+	var size int64
+	for _, s := range strs {
+		size += int64(len(s))
+	}
+	return size
+}
@@ -2,7 +2,7 @@ package model
 
 // Transaction json response
 type Transaction struct {
-	BlockHash        string `josn:"blockHash"`
+	BlockHash        string `json:"blockHash"`
 	BlockNumber      string `json:"blockNumber"`
 	From             string `json:"from"`
 	Gas              string `json:"gas"`
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"my.eth.test/client"
+)
+
+// blockMethods take a block by a tag or a number as the first param
+var blockMethods = map[string]bool{
+	"eth_getBlockByNumber":                    true,
+	"eth_getTransactionByBlockNumberAndIndex": true,
+	"eth_getBlockTransactionCountByNumber":    true,
+}
+
+// dispatchBatch serves a json-rpc batch. When the source batches upstream calls, blocks of all calls
+// are requested at once: cached ones are taken from the cache and only the missed ones go upstream
+// in a batch. Forwarded calls go upstream in a batch too
+func (s *RouterToServe) dispatchBatch(ctx context.Context, body []byte) interface{} {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(body, &raws); err != nil {
+		return failure(nil, &rpcError{Code: rpcParseError, Message: "parse error: " + err.Error()})
+	}
+	if len(raws) == 0 {
+		return failure(nil, &rpcError{Code: rpcInvalidRequest, Message: "empty batch"})
+	}
+	reqs := make([]*rpcRequest, len(raws))
+	answers := make([]interface{}, len(raws))
+	for i, raw := range raws {
+		req := new(rpcRequest)
+		if err := json.Unmarshal(raw, req); err != nil || !req.valid() {
+			answers[i] = failure(req.ID, &rpcError{Code: rpcInvalidRequest, Message: "invalid request"})
+			continue
+		}
+		reqs[i] = req
+	}
+
+	r := &rpcScope{s: s, ctx: ctx}
+	if batcher, ok := s.client.(client.Batcher); ok {
+		r.blocks = prefetch(ctx, batcher, reqs)
+		forwardBatch(ctx, batcher, reqs, answers)
+	}
+	for i, req := range reqs {
+		if req != nil && answers[i] == nil {
+			answers[i] = s.dispatch(r, req)
+		}
+	}
+	return answers
+}
+
+// prefetch requests blocks of every block method of a batch at once
+func prefetch(ctx context.Context, batcher client.Batcher, reqs []*rpcRequest) map[string]prefetched {
+	var identifiers []string
+	for _, req := range reqs {
+		if req == nil {
+			continue
+		}
+		if req.Method == "eth_blockNumber" {
+			identifiers = append(identifiers, "latest")
+			continue
+		}
+		if !blockMethods[req.Method] {
+			continue
+		}
+		// invalid params are reported when the call is served
+		params, err := req.params()
+		if err != nil {
+			continue
+		}
+		identifier, err := blockParam(params, 0)
+		if err != nil {
+			continue
+		}
+		identifiers = append(identifiers, identifier)
+	}
+	blocks := make(map[string]prefetched, len(identifiers))
+	if len(identifiers) == 0 {
+		return blocks
+	}
+	received, errs := batcher.GetBlocksBy(ctx, identifiers)
+	for i, identifier := range identifiers {
+		blocks[identifier] = prefetched{received[i], errs[i]}
+	}
+	return blocks
+}
+
+// forwardBatch sends the calls of methods that aren't served from the cache upstream in a batch
+func forwardBatch(ctx context.Context, batcher client.Batcher, reqs []*rpcRequest, answers []interface{}) {
+	var calls []client.Call
+	var positions []int
+	for i, req := range reqs {
+		if req == nil {
+			continue
+		}
+		if _, ok := rpcMethods[req.Method]; ok {
+			continue
+		}
+		calls = append(calls, client.Call{Method: req.Method, Params: req.Params})
+		positions = append(positions, i)
+	}
+	if len(calls) == 0 {
+		return
+	}
+	for j, answer := range batcher.ForwardBatch(ctx, calls) {
+		req := reqs[positions[j]]
+		if answer.Err != nil {
+			answers[positions[j]] = failure(req.ID, answer.Err)
+		} else {
+			answers[positions[j]] = &rpcResult{JSONRPC: "2.0", ID: req.ID, Result: answer.Result}
+		}
+	}
+}
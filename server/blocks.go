@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/model"
+)
+
+// rangeChunkSize is how many blocks of a range are requested together
+const rangeChunkSize = 20
+
+// rangeChunk is a part of a block range, it's done when its blocks are received
+type rangeChunk struct {
+	numbers []string
+	blocks  []*model.Block
+	errs    []error
+	done    chan struct{}
+}
+
+// rangeError is the last line of a stream that has failed on a block
+type rangeError struct {
+	Number string `json:"number"`
+	Code   string `json:"code"`
+	Error  string `json:"error"`
+}
+
+// GET /blocks?from={number}&to={number}&step={number}&full={bool}&txs={view}&fields={list}
+func (s *RouterToServe) requestBlocks(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	from, err := uintArg(args, "from", nil)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	to, err := uintArg(args, "to", nil)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	one := uint64(1)
+	step, err := uintArg(args, "step", &one)
+	if err == nil && step == 0 {
+		err = &model.InvalidQueryParamError{Param: "step", Value: "0"}
+	}
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	if from > to {
+		failRequest(ctx, fmt.Errorf("the range is empty: from %d is above to %d", from, to))
+		return
+	}
+	p, err := projectionArgs(args)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	if p == nil && args.GetBool("full") {
+		p, _ = model.NewProjection(model.TxsFull, nil)
+	}
+	timeout, err := s.requestTimeout(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	ctx.SetContentType("application/x-ndjson")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		s.streamBlocks(ctx, w, from, to, step, p, timeout)
+	})
+}
+
+// uintArg parses a decimal or 0x-hex query param, def is the value of a missing optional one
+func uintArg(args *fasthttp.Args, name string, def *uint64) (uint64, error) {
+	raw := args.Peek(name)
+	if len(raw) == 0 {
+		if def == nil {
+			return 0, &model.InvalidQueryParamError{Param: name, Value: ""}
+		}
+		return *def, nil
+	}
+	n, err := parseBlockNumber(string(raw))
+	if err != nil {
+		return 0, &model.InvalidQueryParamError{Param: name, Value: string(raw)}
+	}
+	return n, nil
+}
+
+// streamBlocks writes blocks of a range in order, a block per line. Chunks of the range are requested
+// ahead of the writer, but no more than RangeConcurrency at once, so memory doesn't grow with the range.
+// The timeout is a deadline of every chunk, not of the whole stream. A stream stops at the first failed block
+func (s *RouterToServe) streamBlocks(
+	parent context.Context,
+	w *bufio.Writer,
+	from, to, step uint64,
+	p *model.Projection,
+	timeout time.Duration,
+) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	chunks := make(chan *rangeChunk, s.conf.RangeConcurrency-1)
+	go func() {
+		defer close(chunks)
+		next, more := from, true
+		for more {
+			chunk := &rangeChunk{done: make(chan struct{})}
+			for more && len(chunk.numbers) < rangeChunkSize {
+				chunk.numbers = append(chunk.numbers, fmt.Sprintf("0x%x", next))
+				if to-next < step {
+					more = false
+				} else {
+					next += step
+				}
+			}
+			select {
+			case chunks <- chunk:
+			case <-ctx.Done():
+				return
+			}
+			go s.fetchChunk(ctx, chunk, timeout)
+		}
+	}()
+
+	for chunk := range chunks {
+		<-chunk.done
+		for i, b := range chunk.blocks {
+			var data []byte
+			var err error
+			if chunk.errs[i] != nil {
+				_, code := errorStatus(chunk.errs[i])
+				data, err = json.Marshal(&rangeError{Number: chunk.numbers[i], Code: code, Error: chunk.errs[i].Error()})
+			} else {
+				data, err = blockView(b, p)
+			}
+			if err == nil {
+				w.Write(data)
+				err = w.WriteByte('\n')
+			}
+			if err != nil || chunk.errs[i] != nil {
+				w.Flush()
+				return
+			}
+		}
+		if err := w.Flush(); err != nil {
+			// the client has gone
+			return
+		}
+	}
+}
+
+// fetchChunk requests blocks of a chunk in a batch when the source can do it or one by one otherwise
+func (s *RouterToServe) fetchChunk(parent context.Context, chunk *rangeChunk, timeout time.Duration) {
+	defer close(chunk.done)
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	if batcher, ok := s.client.(client.Batcher); ok {
+		chunk.blocks, chunk.errs = batcher.GetBlocksBy(ctx, chunk.numbers)
+		return
+	}
+	chunk.blocks = make([]*model.Block, len(chunk.numbers))
+	chunk.errs = make([]error, len(chunk.numbers))
+	for i, number := range chunk.numbers {
+		chunk.blocks[i], chunk.errs[i] = s.client.GetBlockBy(ctx, number)
+	}
+}
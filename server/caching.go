@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/model"
+)
+
+// immutableCacheControl is the Cache-Control of a final block, it never changes
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// conditional sets ETag and Cache-Control of a response made of a block. A response of a final block
+// requested by a number or a hash is immutable, the rest are fresh for MaxAge only.
+// A variant names the representation of the block, the same one whatever route or query args it's requested with.
+// When the client already has the response, it's answered with 304 and true is returned
+func (s *RouterToServe) conditional(ctx *fasthttp.RequestCtx, uctx context.Context, block *model.Block, tagged bool, variant string) bool {
+	etag := blockETag(block, variant)
+	ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+	ctx.SetUserValue(variantValue, variant)
+	if !tagged && s.final(uctx, block) {
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, immutableCacheControl)
+	} else {
+		// max-age is in whole seconds, a shorter MaxAge is rounded up, so it doesn't turn into no caching at all
+		maxAge := (s.conf.MaxAge + time.Second - 1) / time.Second
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(maxAge)))
+	}
+	// a compressed representation has its own tag, the client may have either of them
+	tags := []string{etag}
+	if encoding := s.encoding(ctx); encoding != "" {
+		tags = append(tags, encodedETag(etag, encoding))
+	}
+	matched := matchETag(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch), tags)
+	if matched == "" {
+		return false
+	}
+	ctx.Response.Header.Set(fasthttp.HeaderETag, matched)
+	if s.conf.CompressMinSize >= 0 {
+		ctx.Response.Header.Set(fasthttp.HeaderVary, fasthttp.HeaderAcceptEncoding)
+	}
+	ctx.SetStatusCode(fasthttp.StatusNotModified)
+	return true
+}
+
+// final says whether a block won't change, it's false when the source can't tell
+func (s *RouterToServe) final(ctx context.Context, block *model.Block) bool {
+	reporter, ok := s.client.(client.FinalityReporter)
+	return ok && reporter.Final(ctx, block.Number)
+}
+
+// blockETag is a strong entity tag of a block response, a variant other than the plain block is a part of it
+func blockETag(block *model.Block, variant string) string {
+	if variant == "" {
+		return `"` + block.Hash + `"`
+	}
+	h := fnv.New32a()
+	h.Write([]byte(variant))
+	return fmt.Sprintf(`"%s-%08x"`, block.Hash, h.Sum32())
+}
+
+// matchETag checks If-None-Match: a list of entity tags or '*'. Weak tags are compared as strong ones.
+// It returns the matched tag or an empty string
+func matchETag(header []byte, tags []string) string {
+	for _, candidate := range bytes.Split(header, []byte(",")) {
+		candidate = bytes.TrimPrefix(bytes.TrimSpace(candidate), []byte("W/"))
+		if string(candidate) == "*" {
+			return tags[0]
+		}
+		for _, tag := range tags {
+			if string(candidate) == tag {
+				return tag
+			}
+		}
+	}
+	return ""
+}
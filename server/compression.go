@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"time"
+	"unsafe"
+
+	"github.com/valyala/fasthttp"
+)
+
+// content codings the service can answer with, in the order of preference when a client likes them equally
+const (
+	encodingBrotli  = "br"
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+var encodings = []string{encodingBrotli, encodingGzip, encodingDeflate}
+
+// variantPrefix is the cache key prefix of compressed responses of final blocks
+const variantPrefix = "variant:"
+
+// variantValue is the user value of a request with the variant of its block response, see conditional
+const variantValue = "variant"
+
+// compressedBody is a compressed response kept in the block cache, it implements ccache.Sized
+type compressedBody []byte
+
+func (b compressedBody) Size() int64 {
+	return int64(unsafe.Sizeof(b)) + int64(cap(b))
+}
+
+// compress wraps a handler to compress its buffered responses with a coding the client accepts.
+// Streams are written as is, so a line of a stream reaches the client at once
+func (s *RouterToServe) compress(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if s.conf.CompressMinSize < 0 {
+		return h
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		h(ctx)
+		resp := &ctx.Response
+		if resp.IsBodyStream() || len(resp.Header.Peek(fasthttp.HeaderContentEncoding)) > 0 ||
+			len(resp.Body()) < s.conf.CompressMinSize {
+			return
+		}
+		resp.Header.Set(fasthttp.HeaderVary, fasthttp.HeaderAcceptEncoding)
+		encoding := s.encoding(ctx)
+		if encoding == "" {
+			return
+		}
+		etag := string(resp.Header.Peek(fasthttp.HeaderETag))
+		if etag != "" {
+			etag = encodedETag(etag, encoding)
+			resp.Header.Set(fasthttp.HeaderETag, etag)
+		}
+		resp.SetBodyRaw(s.compressedBody(ctx, etag, encoding))
+		resp.Header.Set(fasthttp.HeaderContentEncoding, encoding)
+	}
+}
+
+// compressedBody compresses a response body. When it's a final block, the result is kept in the cache
+// by its ETag with the coding and by its variant, not by the request URI, so query args can't flood the cache
+func (s *RouterToServe) compressedBody(ctx *fasthttp.RequestCtx, etag string, encoding string) []byte {
+	variant, ok := ctx.UserValue(variantValue).(string)
+	cacheable := s.conf.Variants != nil && etag != "" && ok &&
+		string(ctx.Response.Header.Peek(fasthttp.HeaderCacheControl)) == immutableCacheControl
+	var key string
+	if cacheable {
+		key = variantKey(etag, variant)
+		if item := s.conf.Variants.Get(key); item != nil {
+			return item.Value().(compressedBody)
+		}
+	}
+	var body []byte
+	switch encoding {
+	case encodingBrotli:
+		body = fasthttp.AppendBrotliBytesLevel(nil, ctx.Response.Body(), fasthttp.CompressBrotliDefaultCompression)
+	case encodingGzip:
+		body = fasthttp.AppendGzipBytesLevel(nil, ctx.Response.Body(), fasthttp.CompressDefaultCompression)
+	default:
+		body = fasthttp.AppendDeflateBytesLevel(nil, ctx.Response.Body(), fasthttp.CompressDefaultCompression)
+	}
+	if cacheable {
+		s.conf.Variants.Set(key, compressedBody(body), time.Duration(math.MaxInt64))
+	}
+	return body
+}
+
+// variantKey is the cache key of a compressed response. The variant is kept whole, its hash in the ETag may collide
+func variantKey(etag string, variant string) string {
+	return variantPrefix + etag + variant
+}
+
+// encoding chooses a content coding by the Accept-Encoding header: the one with the highest weight,
+// '*' stands for codings that aren't listed. It's empty when the body is sent as is
+func (s *RouterToServe) encoding(ctx *fasthttp.RequestCtx) string {
+	if s.conf.CompressMinSize < 0 {
+		return ""
+	}
+	weights := make(map[string]float64, len(encodings))
+	wildcard := -1.0
+	for _, part := range bytes.Split(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding), []byte(",")) {
+		name, weight := parseCoding(part)
+		if name == "*" {
+			wildcard = weight
+		} else {
+			weights[name] = weight
+		}
+	}
+	chosen, best := "", 0.0
+	for _, encoding := range encodings {
+		weight, ok := weights[encoding]
+		if !ok {
+			weight = wildcard
+		}
+		if weight > best {
+			chosen, best = encoding, weight
+		}
+	}
+	return chosen
+}
+
+// parseCoding splits an element of Accept-Encoding like "gzip;q=0.8" into the coding and its weight.
+// A malformed weight makes the coding unacceptable
+func parseCoding(part []byte) (string, float64) {
+	params := bytes.Split(part, []byte(";"))
+	name := string(bytes.ToLower(bytes.TrimSpace(params[0])))
+	weight := 1.0
+	for _, param := range params[1:] {
+		param = bytes.TrimSpace(param)
+		if !bytes.HasPrefix(param, []byte("q=")) && !bytes.HasPrefix(param, []byte("Q=")) {
+			continue
+		}
+		q, err := strconv.ParseFloat(string(param[2:]), 64)
+		if err != nil || q < 0 || q > 1 {
+			q = 0
+		}
+		weight = q
+	}
+	return name, weight
+}
+
+// encodedETag makes an entity tag of a compressed representation, it differs from the identity one
+func encodedETag(etag string, encoding string) string {
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
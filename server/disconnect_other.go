@@ -0,0 +1,15 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package server
+
+import "net"
+
+// watchable is false where a socket can't be peeked at, a request of a gone client lives till its deadline
+func watchable(conn net.Conn) bool {
+	return false
+}
+
+func peerClosed(conn net.Conn) bool {
+	return false
+}
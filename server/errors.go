@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/model"
+)
+
+// machine-readable codes of failed requests
+const (
+	codeBadRequest         = "bad_request"
+	codeInvalidIdentifier  = "invalid_identifier"
+	codeInvalidQueryParam  = "invalid_query_param"
+	codeInvalidHeader      = "invalid_header"
+	codeBlockNotFound      = "block_not_found"
+	codeTransactionMissing = "transaction_not_found"
+	codeHookNotFound       = "hook_not_found"
+	codeDeliveryNotFound   = "delivery_not_found"
+	codeInvalidHook        = "invalid_hook"
+	codeUnsupported        = "unsupported"
+	codeUpstreamTimeout    = "upstream_timeout"
+	codeUpstreamError      = "upstream_error"
+	codeClientClosed       = "client_closed_request"
+	codeInternalError      = "internal_error"
+)
+
+// statusClientClosed is the nginx status of a request the client has gone away from, nobody reads its response
+const statusClientClosed = 499
+
+// errorEnvelope is the body of a failed request
+type errorEnvelope struct {
+	Error *errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// unsupportedError to report that the block source doesn't serve a kind of data
+type unsupportedError struct {
+	what string
+}
+
+func (err *unsupportedError) Error() string {
+	return "the block source doesn't " + err.what
+}
+
+// errorStatus derives the status and the code of a failed request from the error type.
+// Errors of the block source are upstream failures unless their type says otherwise
+func errorStatus(err error) (int, string) {
+	var invalidID *model.InvalidIdentifierError
+	var invalidParam *model.InvalidQueryParamError
+	var invalidHeader *model.InvalidHeaderError
+	var notFound *model.NotFoundBlockError
+	var notFoundTx *model.NotFoundTransactionError
+	var notFoundHash *model.NotFoundHashTransactionError
+	var notFoundID *model.NotFoundIDTransactionError
+	var invalidHook *model.InvalidHookError
+	var notFoundHook *model.NotFoundHookError
+	var notFoundDelivery *model.NotFoundDeliveryError
+	var unsupported *unsupportedError
+	switch {
+	case errors.As(err, &invalidID):
+		return fasthttp.StatusBadRequest, codeInvalidIdentifier
+	case errors.As(err, &invalidParam):
+		return fasthttp.StatusBadRequest, codeInvalidQueryParam
+	case errors.As(err, &invalidHeader):
+		return fasthttp.StatusBadRequest, codeInvalidHeader
+	case errors.As(err, &notFound):
+		return fasthttp.StatusNotFound, codeBlockNotFound
+	case errors.As(err, &notFoundTx) || errors.As(err, &notFoundHash) || errors.As(err, &notFoundID):
+		return fasthttp.StatusNotFound, codeTransactionMissing
+	case errors.As(err, &invalidHook):
+		return fasthttp.StatusBadRequest, codeInvalidHook
+	case errors.As(err, &notFoundHook):
+		return fasthttp.StatusNotFound, codeHookNotFound
+	case errors.As(err, &notFoundDelivery):
+		return fasthttp.StatusNotFound, codeDeliveryNotFound
+	case errors.As(err, &unsupported):
+		return fasthttp.StatusNotFound, codeUnsupported
+	case errors.Is(err, context.DeadlineExceeded):
+		return fasthttp.StatusGatewayTimeout, codeUpstreamTimeout
+	case errors.Is(err, context.Canceled):
+		return statusClientClosed, codeClientClosed
+	}
+	return fasthttp.StatusBadGateway, codeUpstreamError
+}
+
+// fail writes an error as the json envelope with the status and the code of its type.
+// A request canceled by a disconnected client gets only the status
+func fail(ctx *fasthttp.RequestCtx, err error) {
+	status, code := errorStatus(err)
+	if status == statusClientClosed {
+		ctx.Response.Reset()
+		ctx.SetStatusCode(status)
+		return
+	}
+	writeError(ctx, status, code, err)
+}
+
+// failRequest writes an error of a malformed request, it's 400 whatever its type
+func failRequest(ctx *fasthttp.RequestCtx, err error) {
+	status, code := errorStatus(err)
+	if status != fasthttp.StatusBadRequest {
+		code = codeBadRequest
+	}
+	writeError(ctx, fasthttp.StatusBadRequest, code, err)
+}
+
+// failInternal writes an error of the service itself like a failed encoding
+func failInternal(ctx *fasthttp.RequestCtx, err error) {
+	writeError(ctx, fasthttp.StatusInternalServerError, codeInternalError, err)
+}
+
+func writeError(ctx *fasthttp.RequestCtx, status int, code string, err error) {
+	data, _ := json.Marshal(&errorEnvelope{Error: &errorBody{Code: code, Message: err.Error()}})
+	ctx.Response.Reset()
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.Write(data)
+}
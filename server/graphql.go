@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/valyala/fasthttp"
+	"my.eth.test/model"
+)
+
+// gqlMaxDepth limits nesting of a query, every level of parent { parent { ... } } may be an upstream call
+const gqlMaxDepth = 16
+
+// gqlMaxRange is the widest range of Query.blocks
+const gqlMaxRange = 1000
+
+// gqlMaxCost limits upstream calls of a query: every block, transaction, receipt, log filter
+// and account state that isn't known yet is one, a range of Query.blocks is one per block
+const gqlMaxCost = 2000
+
+// gqlMaxParallelism limits resolvers of a query that run at once
+const gqlMaxParallelism = 8
+
+// gqlSchema is parsed once, resolvers take the server of a request from its context
+var gqlSchema = graphql.MustParseSchema(gqlSchemaSDL, new(gqlQuery),
+	graphql.MaxDepth(gqlMaxDepth), graphql.MaxParallelism(gqlMaxParallelism))
+
+// gqlRequest is the body of a GraphQL request
+type gqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GET /graphql?query={query}&operationName={name}&variables={json}
+// POST /graphql with {"query": ..., "operationName": ..., "variables": {...}}
+func (s *RouterToServe) requestGraphQL(ctx *fasthttp.RequestCtx) {
+	req := new(gqlRequest)
+	if ctx.IsPost() {
+		if err := json.Unmarshal(ctx.PostBody(), req); err != nil {
+			failRequest(ctx, fmt.Errorf("the body isn't a GraphQL request: %s", err.Error()))
+			return
+		}
+	} else {
+		args := ctx.QueryArgs()
+		req.Query = string(args.Peek("query"))
+		req.OperationName = string(args.Peek("operationName"))
+		if raw := args.Peek("variables"); len(raw) > 0 {
+			if err := json.Unmarshal(raw, &req.Variables); err != nil {
+				failRequest(ctx, &model.InvalidQueryParamError{Param: "variables", Value: string(raw)})
+				return
+			}
+		}
+	}
+	if req.Query == "" {
+		failRequest(ctx, &model.InvalidQueryParamError{Param: "query", Value: ""})
+		return
+	}
+	uctx, cancel, err := s.upstreamContext(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	defer cancel()
+	scope := &gqlScope{s: s, blocks: make(map[string]*model.Block), cancel: cancel}
+	resp := gqlSchema.Exec(context.WithValue(uctx, gqlScopeKey{}, scope), req.Query, req.OperationName, req.Variables)
+	if err := scope.exceeded(); err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		failInternal(ctx, err)
+		return
+	}
+	// errors of a GraphQL request are in its body next to the data that has been resolved
+	ctx.SetContentType("application/json")
+	ctx.Write(data)
+}
+
+type gqlScopeKey struct{}
+
+// gqlScope is what resolvers of a request share. Blocks are remembered by numbers and hashes,
+// so a block is requested once for a query however many fields refer to it
+type gqlScope struct {
+	s      *RouterToServe
+	lock   sync.Mutex
+	blocks map[string]*model.Block
+	cost   int
+	over   error
+	cancel context.CancelFunc
+}
+
+// costError is a query that needs more upstream calls than gqlMaxCost
+type costError struct {
+	limit int
+}
+
+func (err *costError) Error() string {
+	return fmt.Sprintf("the query needs more than %d upstream calls", err.limit)
+}
+
+// charge adds upstream calls to the cost of a query. Over the limit the query is stopped,
+// calls in flight are canceled and the request is rejected
+func (g *gqlScope) charge(calls int) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.over != nil {
+		return g.over
+	}
+	g.cost += calls
+	if g.cost > gqlMaxCost {
+		g.over = &costError{limit: gqlMaxCost}
+		g.cancel()
+	}
+	return g.over
+}
+
+func (g *gqlScope) exceeded() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.over
+}
+
+func scopeOf(ctx context.Context) *gqlScope {
+	return ctx.Value(gqlScopeKey{}).(*gqlScope)
+}
+
+// remember keeps a block by its number and hash, the latest one by the tag too
+func (g *gqlScope) remember(tag string, b *model.Block) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if tag != "" {
+		g.blocks[tag] = b
+	}
+	g.blocks[b.Number] = b
+	g.blocks[b.Hash] = b
+}
+
+func (g *gqlScope) remembered(key string) *model.Block {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.blocks[key]
+}
+
+// block gets a block by a hex number or the 'latest' tag, an unknown block is nil without an error
+func (g *gqlScope) block(ctx context.Context, identifier string) (*model.Block, error) {
+	if b := g.remembered(identifier); b != nil {
+		return b, nil
+	}
+	if err := g.charge(1); err != nil {
+		return nil, err
+	}
+	b, err := found(g.s.client.GetBlockBy(ctx, identifier))
+	if b == nil || err != nil {
+		return nil, err
+	}
+	tag := ""
+	if isTag(identifier) {
+		tag = identifier
+	}
+	g.remember(tag, b)
+	return b, nil
+}
+
+// blockByHash gets a block by its hash, an unknown block is nil without an error
+func (g *gqlScope) blockByHash(ctx context.Context, hash string) (*model.Block, error) {
+	if b := g.remembered(hash); b != nil {
+		return b, nil
+	}
+	if err := g.charge(1); err != nil {
+		return nil, err
+	}
+	b, err := found(g.s.client.GetBlockByHash(ctx, hash))
+	if b == nil || err != nil {
+		return nil, err
+	}
+	g.remember("", b)
+	return b, nil
+}
+
+// transaction finds a transaction of a block by its hash, an unknown one is nil without an error
+func (g *gqlScope) transaction(ctx context.Context, blockHash string, hash string) (*gqlTransaction, error) {
+	b, err := g.blockByHash(ctx, blockHash)
+	if b == nil || err != nil {
+		return nil, err
+	}
+	for _, tx := range b.Transactions {
+		if tx.Hash == hash {
+			return &gqlTransaction{tx: tx, block: b}, nil
+		}
+	}
+	return nil, nil
+}
+
+// findTransaction finds a transaction by its hash without knowing its block
+func (g *gqlScope) findTransaction(ctx context.Context, hash string) (*gqlTransaction, error) {
+	if err := g.charge(1); err != nil {
+		return nil, err
+	}
+	tx, err := g.s.client.FindTransaction(ctx, hash)
+	var notFound *model.NotFoundTransactionError
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gqlTransaction{tx: tx}, nil
+}
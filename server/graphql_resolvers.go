@@ -0,0 +1,465 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"my.eth.test/client"
+	"my.eth.test/model"
+)
+
+// gqlQuery resolves the Query type
+type gqlQuery struct{}
+
+func (q *gqlQuery) Block(ctx context.Context, args struct {
+	Number *gqlLong
+	Hash   *gqlBytes32
+}) (*gqlBlock, error) {
+	g := scopeOf(ctx)
+	var b *model.Block
+	var err error
+	switch {
+	case args.Number != nil && args.Hash != nil:
+		return nil, fmt.Errorf("only one of number and hash may be given")
+	case args.Hash != nil:
+		b, err = g.blockByHash(ctx, string(*args.Hash))
+	case args.Number != nil:
+		b, err = g.block(ctx, fmt.Sprintf("0x%x", uint64(*args.Number)))
+	default:
+		b, err = g.block(ctx, "latest")
+	}
+	if b == nil || err != nil {
+		return nil, err
+	}
+	return &gqlBlock{b: b}, nil
+}
+
+// Blocks ends at the head when the range goes above it
+func (q *gqlQuery) Blocks(ctx context.Context, args struct {
+	From gqlLong
+	To   *gqlLong
+}) ([]*gqlBlock, error) {
+	g := scopeOf(ctx)
+	from := uint64(args.From)
+	var to uint64
+	if args.To != nil {
+		to = uint64(*args.To)
+	} else {
+		latest, err := g.block(ctx, "latest")
+		if latest == nil || err != nil {
+			return nil, err
+		}
+		if to, err = parseBlockNumber(latest.Number); err != nil {
+			return nil, err
+		}
+	}
+	if from > to {
+		return nil, fmt.Errorf("the range is empty: from %d is above to %d", from, to)
+	}
+	if to-from >= gqlMaxRange {
+		return nil, fmt.Errorf("the range is wider than %d blocks", gqlMaxRange)
+	}
+
+	if err := g.charge(int(to - from + 1)); err != nil {
+		return nil, err
+	}
+	numbers := make([]string, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		numbers = append(numbers, fmt.Sprintf("0x%x", n))
+	}
+	blocks := make([]*model.Block, len(numbers))
+	errs := make([]error, len(numbers))
+	if batcher, ok := g.s.client.(client.Batcher); ok {
+		blocks, errs = batcher.GetBlocksBy(ctx, numbers)
+	} else {
+		for i, number := range numbers {
+			blocks[i], errs[i] = g.s.client.GetBlockBy(ctx, number)
+		}
+	}
+	list := make([]*gqlBlock, 0, len(blocks))
+	for i, b := range blocks {
+		b, err := found(b, errs[i])
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			break
+		}
+		g.remember("", b)
+		list = append(list, &gqlBlock{b: b})
+	}
+	return list, nil
+}
+
+func (q *gqlQuery) Transaction(ctx context.Context, args struct{ Hash gqlBytes32 }) (*gqlTransaction, error) {
+	return scopeOf(ctx).findTransaction(ctx, string(args.Hash))
+}
+
+// gqlFilter is FilterCriteria
+type gqlFilter struct {
+	FromBlock *gqlLong
+	ToBlock   *gqlLong
+	Addresses *[]gqlAddress
+	Topics    *[][]gqlBytes32
+}
+
+func (q *gqlQuery) Logs(ctx context.Context, args struct{ Filter gqlFilter }) ([]*gqlLog, error) {
+	filter := &model.LogFilter{FromBlock: "latest", ToBlock: "latest"}
+	if args.Filter.FromBlock != nil {
+		filter.FromBlock = fmt.Sprintf("0x%x", uint64(*args.Filter.FromBlock))
+	}
+	if args.Filter.ToBlock != nil {
+		filter.ToBlock = fmt.Sprintf("0x%x", uint64(*args.Filter.ToBlock))
+	}
+	return logs(ctx, filter, args.Filter.Addresses, args.Filter.Topics, nil)
+}
+
+// logs gets logs of a filter with addresses and topics of a query, the block is known when all logs are of one block
+func logs(ctx context.Context, filter *model.LogFilter, addresses *[]gqlAddress, topics *[][]gqlBytes32, b *model.Block) ([]*gqlLog, error) {
+	g := scopeOf(ctx)
+	source, ok := g.s.client.(client.LogSource)
+	if !ok {
+		return nil, &unsupportedError{what: "serve logs"}
+	}
+	if err := g.charge(1); err != nil {
+		return nil, err
+	}
+	if addresses != nil {
+		for _, address := range *addresses {
+			filter.Address = append(filter.Address, string(address))
+		}
+	}
+	if topics != nil {
+		for _, position := range *topics {
+			list := make([]string, len(position))
+			for i, topic := range position {
+				list[i] = string(topic)
+			}
+			filter.Topics = append(filter.Topics, list)
+		}
+	}
+	found, err := source.GetLogs(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*gqlLog, len(found))
+	for i, log := range found {
+		list[i] = &gqlLog{log: log, block: b}
+	}
+	return list, nil
+}
+
+// gqlBlock resolves the Block type
+type gqlBlock struct {
+	b *model.Block
+}
+
+func (b *gqlBlock) Number() (gqlLong, error)          { return hexLong(b.b.Number) }
+func (b *gqlBlock) Hash() gqlBytes32                  { return gqlBytes32(b.b.Hash) }
+func (b *gqlBlock) Nonce() gqlBytes                   { return gqlBytes(b.b.Nonce) }
+func (b *gqlBlock) TransactionsRoot() gqlBytes32      { return gqlBytes32(b.b.TransactionsRoot) }
+func (b *gqlBlock) StateRoot() gqlBytes32             { return gqlBytes32(b.b.StateRoot) }
+func (b *gqlBlock) ReceiptsRoot() gqlBytes32          { return gqlBytes32(b.b.ReceiptsRoot) }
+func (b *gqlBlock) ExtraData() gqlBytes               { return gqlBytes(b.b.ExtraData) }
+func (b *gqlBlock) GasLimit() (gqlLong, error)        { return hexLong(b.b.GasLimit) }
+func (b *gqlBlock) GasUsed() (gqlLong, error)         { return hexLong(b.b.GasUsed) }
+func (b *gqlBlock) Timestamp() gqlBigInt              { return gqlBigInt(b.b.Timestamp) }
+func (b *gqlBlock) LogsBloom() gqlBytes               { return gqlBytes(b.b.LogsBloom) }
+func (b *gqlBlock) MixHash() gqlBytes32               { return gqlBytes32(b.b.MixHash) }
+func (b *gqlBlock) Difficulty() gqlBigInt             { return gqlBigInt(b.b.Difficulty) }
+func (b *gqlBlock) TotalDifficulty() gqlBigInt        { return gqlBigInt(b.b.TotalDifficulty) }
+func (b *gqlBlock) OmmerHash() gqlBytes32             { return gqlBytes32(b.b.Sha3Uncles) }
+func (b *gqlBlock) OmmerCount() *int32                { return int32Of(len(b.b.Uncles)) }
+func (b *gqlBlock) TransactionCount() *int32          { return int32Of(len(b.b.Transactions)) }
+func (b *gqlBlock) Miner(args gqlBlockArg) gqlAccount { return b.account(b.b.Miner, args.Block) }
+
+func int32Of(n int) *int32 {
+	c := int32(n)
+	return &c
+}
+
+// gqlBlockArg is the block an account is taken at
+type gqlBlockArg struct {
+	Block *gqlLong
+}
+
+// account is an account at a block given by a query, at this block by default
+func (b *gqlBlock) account(address string, at *gqlLong) gqlAccount {
+	return accountAt(address, at, b.b.Number)
+}
+
+func accountAt(address string, at *gqlLong, number string) gqlAccount {
+	if at != nil {
+		number = fmt.Sprintf("0x%x", uint64(*at))
+	}
+	return gqlAccount{address: address, block: number}
+}
+
+// Parent is resolved through the cache by the parent hash, the genesis block has none
+func (b *gqlBlock) Parent(ctx context.Context) (*gqlBlock, error) {
+	if number, err := parseBlockNumber(b.b.Number); err != nil || number == 0 {
+		return nil, err
+	}
+	parent, err := scopeOf(ctx).blockByHash(ctx, b.b.ParentHash)
+	if parent == nil || err != nil {
+		return nil, err
+	}
+	return &gqlBlock{b: parent}, nil
+}
+
+func (b *gqlBlock) Transactions() *[]*gqlTransaction {
+	list := make([]*gqlTransaction, len(b.b.Transactions))
+	for i, tx := range b.b.Transactions {
+		list[i] = &gqlTransaction{tx: tx, block: b.b}
+	}
+	return &list
+}
+
+func (b *gqlBlock) TransactionAt(args struct{ Index int32 }) *gqlTransaction {
+	if args.Index < 0 || int(args.Index) >= len(b.b.Transactions) {
+		return nil
+	}
+	return &gqlTransaction{tx: b.b.Transactions[args.Index], block: b.b}
+}
+
+// gqlBlockFilter is BlockFilterCriteria
+type gqlBlockFilter struct {
+	Addresses *[]gqlAddress
+	Topics    *[][]gqlBytes32
+}
+
+func (b *gqlBlock) Logs(ctx context.Context, args struct{ Filter gqlBlockFilter }) ([]*gqlLog, error) {
+	filter := &model.LogFilter{BlockHash: b.b.Hash}
+	return logs(ctx, filter, args.Filter.Addresses, args.Filter.Topics, b.b)
+}
+
+func (b *gqlBlock) Account(args struct{ Address gqlAddress }) gqlAccount {
+	return b.account(string(args.Address), nil)
+}
+
+// gqlTransaction resolves the Transaction type. The block is known unless the transaction is found by its hash.
+// Fields of the receipt are requested once for all of them
+type gqlTransaction struct {
+	tx    *model.Transaction
+	block *model.Block
+
+	once    sync.Once
+	receipt *model.Receipt
+	err     error
+}
+
+func (t *gqlTransaction) Hash() gqlBytes32        { return gqlBytes32(t.tx.Hash) }
+func (t *gqlTransaction) Nonce() (gqlLong, error) { return hexLong(t.tx.Nonce) }
+func (t *gqlTransaction) Value() gqlBigInt        { return gqlBigInt(t.tx.Value) }
+func (t *gqlTransaction) GasPrice() gqlBigInt     { return gqlBigInt(t.tx.GasPrice) }
+func (t *gqlTransaction) Gas() (gqlLong, error)   { return hexLong(t.tx.Gas) }
+func (t *gqlTransaction) InputData() gqlBytes     { return gqlBytes(t.tx.Input) }
+
+func (t *gqlTransaction) Index() (*int32, error) {
+	if t.tx.TransactionIndex == "" {
+		return nil, nil
+	}
+	index, err := strconv.ParseUint(t.tx.TransactionIndex, 0, 31)
+	if err != nil {
+		return nil, err
+	}
+	return int32Of(int(index)), nil
+}
+
+func (t *gqlTransaction) From(args gqlBlockArg) gqlAccount {
+	return accountAt(t.tx.From, args.Block, t.tx.BlockNumber)
+}
+
+// To is null for a contract creation
+func (t *gqlTransaction) To(args gqlBlockArg) *gqlAccount {
+	if t.tx.To == "" {
+		return nil
+	}
+	a := accountAt(t.tx.To, args.Block, t.tx.BlockNumber)
+	return &a
+}
+
+func (t *gqlTransaction) Block(ctx context.Context) (*gqlBlock, error) {
+	if t.block != nil {
+		return &gqlBlock{b: t.block}, nil
+	}
+	if t.tx.BlockHash == "" {
+		return nil, nil
+	}
+	b, err := scopeOf(ctx).blockByHash(ctx, t.tx.BlockHash)
+	if b == nil || err != nil {
+		return nil, err
+	}
+	return &gqlBlock{b: b}, nil
+}
+
+// getReceipt requests the receipt of the transaction once, its block is requested too when it isn't known
+func (t *gqlTransaction) getReceipt(ctx context.Context) (*model.Receipt, error) {
+	t.once.Do(func() {
+		source, ok := scopeOf(ctx).s.client.(client.ReceiptSource)
+		if !ok {
+			t.err = &unsupportedError{what: "serve receipts"}
+			return
+		}
+		block, err := t.Block(ctx)
+		if block == nil || err != nil {
+			t.err = err
+			return
+		}
+		if t.err = scopeOf(ctx).charge(1); t.err != nil {
+			return
+		}
+		t.receipt, t.err = source.GetTransactionReceipt(ctx, block.b, t.tx)
+	})
+	return t.receipt, t.err
+}
+
+func (t *gqlTransaction) Status(ctx context.Context) (*gqlLong, error) {
+	r, err := t.getReceipt(ctx)
+	if r == nil || err != nil {
+		return nil, err
+	}
+	return optionalLong(r.Status)
+}
+
+func (t *gqlTransaction) GasUsed(ctx context.Context) (*gqlLong, error) {
+	r, err := t.getReceipt(ctx)
+	if r == nil || err != nil {
+		return nil, err
+	}
+	return optionalLong(r.GasUsed)
+}
+
+func (t *gqlTransaction) CumulativeGasUsed(ctx context.Context) (*gqlLong, error) {
+	r, err := t.getReceipt(ctx)
+	if r == nil || err != nil {
+		return nil, err
+	}
+	return optionalLong(r.CumulativeGasUsed)
+}
+
+func (t *gqlTransaction) CreatedContract(ctx context.Context, args gqlBlockArg) (*gqlAccount, error) {
+	r, err := t.getReceipt(ctx)
+	if r == nil || r.ContractAddress == nil || err != nil {
+		return nil, err
+	}
+	a := accountAt(*r.ContractAddress, args.Block, t.tx.BlockNumber)
+	return &a, nil
+}
+
+func (t *gqlTransaction) Logs(ctx context.Context) (*[]*gqlLog, error) {
+	r, err := t.getReceipt(ctx)
+	if r == nil || err != nil {
+		return nil, err
+	}
+	list := make([]*gqlLog, len(r.Logs))
+	for i, log := range r.Logs {
+		list[i] = &gqlLog{log: log, block: t.block, tx: t}
+	}
+	return &list, nil
+}
+
+// gqlLog resolves the Log type, its transaction is found in its block when it isn't known
+type gqlLog struct {
+	log   *model.Log
+	block *model.Block
+	tx    *gqlTransaction
+}
+
+func (l *gqlLog) Data() gqlBytes { return gqlBytes(l.log.Data) }
+
+func (l *gqlLog) Index() (int32, error) {
+	index, err := strconv.ParseUint(l.log.LogIndex, 0, 31)
+	return int32(index), err
+}
+
+func (l *gqlLog) Topics() []gqlBytes32 {
+	topics := make([]gqlBytes32, len(l.log.Topics))
+	for i, topic := range l.log.Topics {
+		topics[i] = gqlBytes32(topic)
+	}
+	return topics
+}
+
+func (l *gqlLog) Account(args gqlBlockArg) gqlAccount {
+	return accountAt(l.log.Address, args.Block, l.log.BlockNumber)
+}
+
+func (l *gqlLog) Transaction(ctx context.Context) (*gqlTransaction, error) {
+	if l.tx != nil {
+		return l.tx, nil
+	}
+	if l.block != nil {
+		for _, tx := range l.block.Transactions {
+			if tx.Hash == l.log.TransactionHash {
+				return &gqlTransaction{tx: tx, block: l.block}, nil
+			}
+		}
+	}
+	tx, err := scopeOf(ctx).transaction(ctx, l.log.BlockHash, l.log.TransactionHash)
+	if tx == nil && err == nil {
+		err = &model.NotFoundHashTransactionError{BlockHash: l.log.BlockHash, Hash: l.log.TransactionHash}
+	}
+	return tx, err
+}
+
+// gqlAccount resolves the Account type. The state of accounts isn't cached, so it's forwarded to ether nodes
+type gqlAccount struct {
+	address string
+	block   string // a hex number, 'latest' when the block is unknown
+}
+
+func (a gqlAccount) Address() gqlAddress { return gqlAddress(a.address) }
+
+// state forwards a method with the address, other params and the block, the result is a hex string
+func (a gqlAccount) state(ctx context.Context, method string, params ...interface{}) (string, error) {
+	g := scopeOf(ctx)
+	forwarder, ok := g.s.client.(client.Forwarder)
+	if !ok {
+		return "", &unsupportedError{what: "serve the state of accounts"}
+	}
+	if err := g.charge(1); err != nil {
+		return "", err
+	}
+	block := a.block
+	if block == "" {
+		block = "latest"
+	}
+	raw, err := json.Marshal(append(append([]interface{}{a.address}, params...), block))
+	if err != nil {
+		return "", err
+	}
+	result, err := forwarder.Forward(ctx, method, raw)
+	if err != nil {
+		return "", err
+	}
+	var value string
+	err = json.Unmarshal(result, &value)
+	return value, err
+}
+
+func (a gqlAccount) Balance(ctx context.Context) (gqlBigInt, error) {
+	value, err := a.state(ctx, "eth_getBalance")
+	return gqlBigInt(value), err
+}
+
+func (a gqlAccount) TransactionCount(ctx context.Context) (gqlLong, error) {
+	value, err := a.state(ctx, "eth_getTransactionCount")
+	if err != nil {
+		return 0, err
+	}
+	return hexLong(value)
+}
+
+func (a gqlAccount) Code(ctx context.Context) (gqlBytes, error) {
+	value, err := a.state(ctx, "eth_getCode")
+	return gqlBytes(value), err
+}
+
+func (a gqlAccount) Storage(ctx context.Context, args struct{ Slot gqlBytes32 }) (gqlBytes32, error) {
+	value, err := a.state(ctx, "eth_getStorageAt", string(args.Slot))
+	return gqlBytes32(value), err
+}
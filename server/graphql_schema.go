@@ -0,0 +1,334 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// gqlSchemaSDL is the part of the EIP-1767 schema served from cached blocks: Query, Block and Transaction
+// with the types they return. Pending, SyncState, calls, gas estimates and mutations aren't served,
+// ommers are known by their hashes only, so there's no ommers and ommerAt
+const gqlSchemaSDL = `
+# Bytes32 is a 32 byte binary string, represented as 0x-prefixed hexadecimal.
+scalar Bytes32
+# Address is a 20 byte Ethereum address, represented as 0x-prefixed hexadecimal.
+scalar Address
+# Bytes is an arbitrary length binary string, represented as 0x-prefixed hexadecimal.
+# An empty byte string is represented as '0x'. Byte strings must have an even number of hexadecimal nybbles.
+scalar Bytes
+# BigInt is a large integer. Input is accepted as either a JSON number or as a string.
+# Strings may be either decimal or 0x-prefixed hexadecimal. Output values are all
+# 0x-prefixed hexadecimal.
+scalar BigInt
+# Long is a 64 bit unsigned integer. Input is accepted as either a JSON number or as a string.
+scalar Long
+
+schema {
+    query: Query
+}
+
+# Account is an Ethereum account at a particular block.
+type Account {
+    # Address is the address owning the account.
+    address: Address!
+    # Balance is the balance of the account, in wei.
+    balance: BigInt!
+    # TransactionCount is the number of transactions sent from this account,
+    # or in the case of a contract, the number of contracts created. Otherwise
+    # known as the nonce.
+    transactionCount: Long!
+    # Code contains the smart contract code for this account, if the account
+    # is a (non-self-destructed) contract.
+    code: Bytes!
+    # Storage provides access to the storage of a contract account, indexed
+    # by its 32 byte slot identifier.
+    storage(slot: Bytes32!): Bytes32!
+}
+
+# Log is an Ethereum event log.
+type Log {
+    # Index is the index of this log in the block.
+    index: Int!
+    # Account is the account which generated this log - this will always
+    # be a contract account.
+    account(block: Long): Account!
+    # Topics is a list of 0-4 indexed topics for the log.
+    topics: [Bytes32!]!
+    # Data is unindexed data for this log.
+    data: Bytes!
+    # Transaction is the transaction that generated this log entry.
+    transaction: Transaction!
+}
+
+# Transaction is an Ethereum transaction.
+type Transaction {
+    # Hash is the hash of this transaction.
+    hash: Bytes32!
+    # Nonce is the nonce of the account this transaction was generated with.
+    nonce: Long!
+    # Index is the index of this transaction in the parent block. This will
+    # be null if the transaction has not yet been mined.
+    index: Int
+    # From is the account that sent this transaction - this will always be
+    # an externally owned account.
+    from(block: Long): Account!
+    # To is the account the transaction was sent to. This is null for
+    # contract-creating transactions.
+    to(block: Long): Account
+    # Value is the value, in wei, sent along with this transaction.
+    value: BigInt!
+    # GasPrice is the price offered to miners for gas, in wei per unit.
+    gasPrice: BigInt!
+    # Gas is the maximum amount of gas this transaction can consume.
+    gas: Long!
+    # InputData is the data supplied to the target of the transaction.
+    inputData: Bytes!
+    # Block is the block this transaction was mined in. This will be null if
+    # the transaction has not yet been mined.
+    block: Block
+    # Status is the return status of the transaction. This will be 1 if the
+    # transaction succeeded, or 0 if it failed (due to a revert, or due to
+    # running out of gas). If the transaction has not yet been mined, this
+    # field will be null.
+    status: Long
+    # GasUsed is the amount of gas that was used processing this transaction.
+    # If the transaction has not yet been mined, this field will be null.
+    gasUsed: Long
+    # CumulativeGasUsed is the total gas used in the block up to and including
+    # this transaction. If the transaction has not yet been mined, this field
+    # will be null.
+    cumulativeGasUsed: Long
+    # CreatedContract is the account that was created by a contract creation
+    # transaction. If the transaction was not a contract creation transaction,
+    # or it has not yet been mined, this field will be null.
+    createdContract(block: Long): Account
+    # Logs is a list of log entries emitted by this transaction. If the
+    # transaction has not yet been mined, this field will be null.
+    logs: [Log!]
+}
+
+# BlockFilterCriteria encapsulates log filter criteria for a filter applied
+# to a single block.
+input BlockFilterCriteria {
+    # Addresses is list of addresses that are of interest. If this list is
+    # empty, results will not be filtered by address.
+    addresses: [Address!]
+    # Topics list restricts matches to particular event topics. Each event has a list
+    # of topics. Topics matches a prefix of that list. An empty element array matches any
+    # topic. Non-empty elements represent an alternative that matches any of the
+    # contained topics.
+    topics: [[Bytes32!]!]
+}
+
+# Block is an Ethereum block.
+type Block {
+    # Number is the number of this block, starting at 0 for the genesis block.
+    number: Long!
+    # Hash is the block hash of this block.
+    hash: Bytes32!
+    # Parent is the parent block of this block.
+    parent: Block
+    # Nonce is the block nonce, an 8 byte sequence determined by the miner.
+    nonce: Bytes!
+    # TransactionsRoot is the keccak256 hash of the root of the trie of transactions in this block.
+    transactionsRoot: Bytes32!
+    # TransactionCount is the number of transactions in this block.
+    transactionCount: Int
+    # StateRoot is the keccak256 hash of the state trie after this block was processed.
+    stateRoot: Bytes32!
+    # ReceiptsRoot is the keccak256 hash of the trie of transaction receipts in this block.
+    receiptsRoot: Bytes32!
+    # Miner is the account that mined this block.
+    miner(block: Long): Account!
+    # ExtraData is an arbitrary data field supplied by the miner.
+    extraData: Bytes!
+    # GasLimit is the maximum amount of gas that was available to transactions in this block.
+    gasLimit: Long!
+    # GasUsed is the amount of gas that was used executing transactions in this block.
+    gasUsed: Long!
+    # Timestamp is the unix timestamp at which this block was mined.
+    timestamp: BigInt!
+    # LogsBloom is a bloom filter that can be used to check if a block may
+    # contain log entries matching a filter.
+    logsBloom: Bytes!
+    # MixHash is the hash that was used as an input to the PoW process.
+    mixHash: Bytes32!
+    # Difficulty is a measure of the difficulty of mining this block.
+    difficulty: BigInt!
+    # TotalDifficulty is the sum of all difficulty values up to and including
+    # this block.
+    totalDifficulty: BigInt!
+    # OmmerCount is the number of ommers (AKA uncles) associated with this
+    # block.
+    ommerCount: Int
+    # OmmerHash is the keccak256 hash of all the ommers (AKA uncles)
+    # associated with this block.
+    ommerHash: Bytes32!
+    # Transactions is a list of transactions associated with this block.
+    transactions: [Transaction!]
+    # TransactionAt returns the transaction at the specified index.
+    transactionAt(index: Int!): Transaction
+    # Logs returns a filtered set of logs from this block.
+    logs(filter: BlockFilterCriteria!): [Log!]!
+    # Account fetches an Ethereum account at the current block's state.
+    account(address: Address!): Account!
+}
+
+# FilterCriteria encapsulates log filter criteria for searching log entries.
+input FilterCriteria {
+    # FromBlock is the block at which to start searching, inclusive. Defaults
+    # to the latest block if not supplied.
+    fromBlock: Long
+    # ToBlock is the block at which to stop searching, inclusive. Defaults
+    # to the latest block if not supplied.
+    toBlock: Long
+    # Addresses is a list of addresses that are of interest. If this list is
+    # empty, results will not be filtered by address.
+    addresses: [Address!]
+    # Topics list restricts matches to particular event topics. Each event has a list
+    # of topics. Topics matches a prefix of that list. An empty element array matches any
+    # topic. Non-empty elements represent an alternative that matches any of the
+    # contained topics.
+    topics: [[Bytes32!]!]
+}
+
+type Query {
+    # Block fetches an Ethereum block by number or by hash. If neither is
+    # supplied, the most recent known block is returned.
+    block(number: Long, hash: Bytes32): Block
+    # Blocks returns all the blocks between two numbers, inclusive. If
+    # to is not supplied, it defaults to the most recent known block.
+    blocks(from: Long!, to: Long): [Block!]!
+    # Transaction returns a transaction specified by its hash.
+    transaction(hash: Bytes32!): Transaction
+    # Logs returns log entries matching the provided filter.
+    logs(filter: FilterCriteria!): [Log!]!
+}
+`
+
+// scalars of the schema. Hex ones keep the strings of model types as they are
+
+type gqlBytes32 string
+
+func (gqlBytes32) ImplementsGraphQLType(name string) bool { return name == "Bytes32" }
+
+func (b *gqlBytes32) UnmarshalGraphQL(input interface{}) error {
+	s, err := hexInput(input, 32)
+	*b = gqlBytes32(s)
+	return err
+}
+
+type gqlAddress string
+
+func (gqlAddress) ImplementsGraphQLType(name string) bool { return name == "Address" }
+
+func (a *gqlAddress) UnmarshalGraphQL(input interface{}) error {
+	s, err := hexInput(input, 20)
+	*a = gqlAddress(s)
+	return err
+}
+
+type gqlBytes string
+
+func (gqlBytes) ImplementsGraphQLType(name string) bool { return name == "Bytes" }
+
+func (b *gqlBytes) UnmarshalGraphQL(input interface{}) error {
+	s, err := hexInput(input, -1)
+	*b = gqlBytes(s)
+	return err
+}
+
+// hexInput checks a 0x-prefixed hex string of a size in bytes, a negative size is any
+func hexInput(input interface{}, size int) (string, error) {
+	s, ok := input.(string)
+	if !ok || !strings.HasPrefix(s, "0x") {
+		return "", fmt.Errorf("%v isn't a 0x-prefixed hex string", input)
+	}
+	raw, err := hex.DecodeString(s[2:])
+	if err != nil {
+		return "", fmt.Errorf("%s isn't a 0x-prefixed hex string: %s", s, err.Error())
+	}
+	if size >= 0 && len(raw) != size {
+		return "", fmt.Errorf("%s has %d bytes instead of %d", s, len(raw), size)
+	}
+	return strings.ToLower(s), nil
+}
+
+type gqlBigInt string
+
+func (gqlBigInt) ImplementsGraphQLType(name string) bool { return name == "BigInt" }
+
+func (b *gqlBigInt) UnmarshalGraphQL(input interface{}) error {
+	value := new(big.Int)
+	ok := true
+	switch v := input.(type) {
+	case string:
+		_, ok = value.SetString(v, 0)
+	case int32:
+		value.SetInt64(int64(v))
+	case int64:
+		value.SetInt64(v)
+	case float64:
+		_, acc := big.NewFloat(v).Int(value)
+		ok = acc == big.Exact
+	default:
+		ok = false
+	}
+	if !ok {
+		return fmt.Errorf("%v isn't an integer", input)
+	}
+	*b = gqlBigInt(fmt.Sprintf("0x%x", value))
+	return nil
+}
+
+// gqlLong is written as a JSON number
+type gqlLong uint64
+
+func (gqlLong) ImplementsGraphQLType(name string) bool { return name == "Long" }
+
+func (l *gqlLong) UnmarshalGraphQL(input interface{}) error {
+	var err error
+	switch v := input.(type) {
+	case string:
+		var n uint64
+		n, err = strconv.ParseUint(v, 0, 64)
+		*l = gqlLong(n)
+	case int32:
+		if v < 0 {
+			err = fmt.Errorf("%d is negative", v)
+		}
+		*l = gqlLong(v)
+	case int64:
+		if v < 0 {
+			err = fmt.Errorf("%d is negative", v)
+		}
+		*l = gqlLong(v)
+	case float64:
+		if v < 0 || v > math.MaxUint64 || v != math.Trunc(v) {
+			err = fmt.Errorf("%v isn't a 64 bit unsigned integer", v)
+		}
+		*l = gqlLong(v)
+	default:
+		err = fmt.Errorf("%v isn't a 64 bit unsigned integer", input)
+	}
+	return err
+}
+
+// hexLong reads a hex quantity of a model type
+func hexLong(s string) (gqlLong, error) {
+	n, err := strconv.ParseUint(s, 0, 64)
+	return gqlLong(n), err
+}
+
+// optionalLong reads a hex quantity of a model type that may be missing, like fields of a receipt
+func optionalLong(s string) (*gqlLong, error) {
+	if s == "" {
+		return nil, nil
+	}
+	n, err := hexLong(s)
+	return &n, err
+}
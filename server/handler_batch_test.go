@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+)
+
+func TestRPCBatch(t *testing.T) {
+	batched := fakenode.New(fakenode.DefaultHead)
+	defer batched.Close()
+	batched.SetMaxBatch(4)
+
+	s, closeClient := newServer(t, batched, client.Config{MaxBatch: 4, LatestWindow: -1}, Config{})
+	defer closeClient()
+
+	// the first block is cached before the batch
+	if answer := callRPC(t, s, "eth_getBlockByNumber", `["0x1", false]`); answer == nil || answer.Error != nil {
+		t.Errorf("Unexpected answer: %+v", answer)
+		return
+	}
+	blockCalls := batched.Calls("eth_getBlockByNumber")
+	batches := batched.Batches()
+
+	calls := []string{`{"foo":1}`}
+	for n := 1; n <= 7; n++ {
+		calls = append(calls, fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"eth_getBlockByNumber","params":["0x%x",false]}`, n, n))
+	}
+	calls = append(calls,
+		fmt.Sprintf(`{"jsonrpc":"2.0","id":8,"method":"eth_getBlockByNumber","params":["0x%x",false]}`, fakenode.DefaultHead+100),
+		`{"jsonrpc":"2.0","id":9,"method":"eth_chainId"}`,
+	)
+	resp, body := post(t, s, "/", "["+strings.Join(calls, ",")+"]")
+	if resp == nil {
+		return
+	}
+	var answers []*rpcAnswer
+	if err := json.Unmarshal(body, &answers); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(answers) != len(calls) {
+		t.Errorf("Invalid number of answers: %d\nexpected: %d", len(answers), len(calls))
+		return
+	}
+
+	if answers[0].Error == nil || answers[0].Error.Code != -32600 {
+		t.Errorf("Unexpected answer for an invalid call: %+v", answers[0])
+	}
+	for n := 1; n <= 7; n++ {
+		a := answers[n]
+		if string(a.ID) != fmt.Sprint(n) || a.Error != nil {
+			t.Errorf("Unexpected answer for the block %d: %+v", n, a)
+			continue
+		}
+		b := new(struct {
+			Hash string `json:"hash"`
+		})
+		if err := json.Unmarshal(a.Result, b); err != nil {
+			t.Error(err)
+			continue
+		}
+		if b.Hash != fakenode.BlockHash(uint64(n)) {
+			t.Errorf("Invalid block hash: %s\nexpected: %s", b.Hash, fakenode.BlockHash(uint64(n)))
+		}
+	}
+	if a := answers[8]; a.Error != nil || string(a.Result) != "null" {
+		t.Errorf("Unexpected answer for a future block: %+v", a)
+	}
+	if a := answers[9]; a.Error != nil || string(a.Result) != fmt.Sprintf(`"%s"`, fakenode.ChainID) {
+		t.Errorf("Unexpected answer for eth_chainId: %+v", a)
+	}
+
+	// the cached block isn't requested, 7 blocks go in 2 batches and eth_chainId goes in another one
+	if got := batched.Calls("eth_getBlockByNumber") - blockCalls; got != 7 {
+		t.Errorf("Invalid number of upstream block calls: %d\nexpected: 7", got)
+	}
+	if got := batched.Batches() - batches; got != 3 {
+		t.Errorf("Invalid number of upstream batches: %d\nexpected: 3", got)
+	}
+}
+
+func TestRPCEmptyBatch(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	resp, body := post(t, s, "/", `[]`)
+	if resp == nil {
+		return
+	}
+	answer := new(rpcAnswer)
+	if err := json.Unmarshal(body, answer); err != nil {
+		t.Error(err)
+		return
+	}
+	if answer.Error == nil || answer.Error.Code != -32600 {
+		t.Errorf("Unexpected answer for an empty batch: %+v", answer)
+	}
+}
@@ -7,15 +7,27 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
 	"testing"
 
 	"github.com/karlseguin/ccache/v2"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttputil"
 	"my.eth.test/client"
+	"my.eth.test/fakenode"
 	"my.eth.test/model"
 )
 
+// node is the fake ether node shared by the server tests
+var node *fakenode.Node
+
+func TestMain(m *testing.M) {
+	node = fakenode.New(fakenode.DefaultHead)
+	code := m.Run()
+	node.Close()
+	os.Exit(code)
+}
+
 func serve(handler fasthttp.RequestHandler, req *http.Request) (*http.Response, error) {
 	ln := fasthttputil.NewInmemoryListener()
 	defer ln.Close()
@@ -29,6 +41,8 @@ func serve(handler fasthttp.RequestHandler, req *http.Request) (*http.Response,
 
 	client := http.Client{
 		Transport: &http.Transport{
+			// tests read bodies as they're sent, a test of compression asks for it explicitly
+			DisableCompression: true,
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 				return ln.Dial()
 			},
@@ -94,14 +108,14 @@ func TestBlockIDNegative(t *testing.T) {
 		)
 		return
 	}
-	if string(body) != fmt.Sprintf(
-		"an identifier: '%d' is invalid",
+	if errorMessage(t, body) != fmt.Sprintf(
+		"an identifier: '%d' is invalid: not a decimal number",
 		-1,
 	) {
 		t.Errorf(
 			"Invalid message: %s\nexpectd: %s",
-			string(body),
-			fmt.Sprintf("an identifier: '%d'  is invalid", -1),
+			errorMessage(t, body),
+			fmt.Sprintf("an identifier: '%d'  is invalid: not a decimal number", -1),
 		)
 	}
 }
@@ -119,15 +133,15 @@ func TestBlockIDString(t *testing.T) {
 		)
 		return
 	}
-	if string(body) != fmt.Sprintf(
-		"an identifier: '%s' is invalid",
+	if errorMessage(t, body) != fmt.Sprintf(
+		"an identifier: '%s' is invalid: not a decimal number",
 		"ff",
 	) {
 		t.Errorf(
 			"Invalid message: %s\nexpectd: %s",
-			string(body),
+			errorMessage(t, body),
 			fmt.Sprintf(
-				"an identifier: '%s' is invalid",
+				"an identifier: '%s' is invalid: not a decimal number",
 				"ff",
 			),
 		)
@@ -136,20 +150,21 @@ func TestBlockIDString(t *testing.T) {
 
 func TestIncorrectEtherAddress(t *testing.T) {
 	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1))
-	_, err := client.NewJRClient("https://cloudflare-eth.c", cache)
+	_, err := client.NewJRClient([]string{"https://cloudflare-eth.c"}, cache, client.Config{})
 	if err == nil {
 		t.Error(fmt.Errorf("expected error"))
 	}
 }
 
 func TestBlockCached(t *testing.T) {
-	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(2))
-	cli, err := client.NewJRClient("https://cloudflare-eth.com", cache)
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	cli, err := client.NewJRClient([]string{node.URL()}, cache, client.Config{})
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	s := NewRouterToServe("test", "", cli)
+	defer cli.Close()
+	s := NewRouterToServe("test", "", cli, Config{})
 	r, _ := http.NewRequest(
 		"GET",
 		fmt.Sprintf("http://%s:%s%s", s.host, s.port, testCasesBlocks[t.Name()]),
@@ -173,12 +188,12 @@ func TestBlockCached(t *testing.T) {
 
 func commonPart(t *testing.T, tcs map[string]string) (*http.Response, []byte) {
 	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1))
-	var c, err = client.NewJRClient("https://cloudflare-eth.com", cache)
+	var c, err = client.NewJRClient([]string{node.URL()}, cache, client.Config{})
 	if err != nil {
 		t.Error(err)
 		return nil, nil
 	}
-	s := NewRouterToServe("test", "", c)
+	s := NewRouterToServe("test", "", c, Config{})
 	r, _ := http.NewRequest(
 		"GET",
 		fmt.Sprintf("http://%s:%s%s", s.host, s.port, tcs[t.Name()]),
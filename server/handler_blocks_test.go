@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+func lines(body []byte) [][]byte {
+	return bytes.Split(bytes.TrimRight(body, "\n"), []byte("\n"))
+}
+
+func TestBlocksRange(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	resp, body := get(t, s, "/blocks?from=10&to=130&step=5")
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Errorf("Invalid status code: %d\nexpected: %d", resp.StatusCode, fasthttp.StatusOK)
+		return
+	}
+	blocks := lines(body)
+	if len(blocks) != 25 {
+		t.Errorf("Invalid number of blocks: %d\nexpected: 25", len(blocks))
+		return
+	}
+	for i, line := range blocks {
+		number := uint64(10 + 5*i)
+		b := new(model.ShowcaseBlock)
+		if err := json.Unmarshal(line, b); err != nil {
+			t.Error(err)
+			return
+		}
+		if b.Hash != fakenode.BlockHash(number) || len(b.Transactions) != fakenode.TxCount(number) {
+			t.Errorf("Invalid block on the line %d: %s", i, line)
+		}
+	}
+}
+
+func TestBlocksRangeHex(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	resp, body := get(t, s, "/blocks?from=0x10&to=0x12")
+	if resp == nil {
+		return
+	}
+	if blocks := lines(body); len(blocks) != 3 {
+		t.Errorf("Invalid number of blocks: %d\nexpected: 3", len(blocks))
+	}
+}
+
+func TestBlocksRangeFull(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	resp, body := get(t, s, "/blocks?from=1000&to=1002&full=true")
+	if resp == nil {
+		return
+	}
+	blocks := lines(body)
+	if len(blocks) != 3 {
+		t.Errorf("Invalid number of blocks: %d\nexpected: 3", len(blocks))
+		return
+	}
+	b := new(model.Block)
+	if err := json.Unmarshal(blocks[2], b); err != nil {
+		t.Error(err)
+		return
+	}
+	if b.Number != "0x3ea" || b.Transactions[0].Hash != fakenode.TxHash(1002, 0) {
+		t.Errorf("Invalid block: %s", blocks[2])
+	}
+}
+
+func TestBlocksRangeAboveHead(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	head := node.Head()
+	resp, body := get(t, s, fmt.Sprintf("/blocks?from=%d&to=%d", head-1, head+1))
+	if resp == nil {
+		return
+	}
+	blocks := lines(body)
+	if len(blocks) != 3 {
+		t.Errorf("Invalid number of lines: %d\nexpected: 3", len(blocks))
+		return
+	}
+	last := new(rangeError)
+	if err := json.Unmarshal(blocks[2], last); err != nil {
+		t.Error(err)
+		return
+	}
+	if last.Number != fmt.Sprintf("0x%x", head+1) || last.Error == "" {
+		t.Errorf("Invalid last line: %s", blocks[2])
+	}
+}
+
+func TestBlocksRangeInvalid(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	for _, path := range []string{
+		"/blocks?to=10",
+		"/blocks?from=10",
+		"/blocks?from=20&to=10",
+		"/blocks?from=1&to=10&step=0",
+		"/blocks?from=0xz&to=10",
+	} {
+		resp, _ := get(t, s, path)
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != fasthttp.StatusBadRequest {
+			t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, fasthttp.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/karlseguin/ccache/v2"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+)
+
+func TestCacheCountsBytes(t *testing.T) {
+	var limit int64
+	for number := uint64(100); number < 103; number++ {
+		limit += fakenode.Block(number).Size()
+	}
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(limit))
+	c, err := client.NewJRClient([]string{node.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	for number := 100; number < 110; number++ {
+		get(t, s, fmt.Sprintf("/block/%d", number))
+	}
+
+	// the cache is pruned concurrently
+	var size int64
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		size = 0
+		cache.ForEachFunc(func(key string, item *ccache.Item) bool {
+			if sized, ok := item.Value().(ccache.Sized); ok {
+				size += sized.Size()
+			}
+			return true
+		})
+		if size <= limit {
+			break
+		}
+	}
+	if size > limit {
+		t.Errorf("The cache holds %d bytes over the limit of %d bytes", size, limit)
+	}
+	if cache.Get("0x6d") == nil {
+		t.Error("The last requested block has not been cached")
+	}
+}
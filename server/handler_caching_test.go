@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+)
+
+// getWith requests a path with headers
+func getWith(t *testing.T, s *RouterToServe, path string, headers map[string]string) (*http.Response, []byte) {
+	r, _ := http.NewRequest("GET", fmt.Sprintf("http://%s:%s%s", s.host, s.port, path), nil)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	resp, err := serve(RegisterHandler(s), r)
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+	return resp, body
+}
+
+func TestBlockCacheHeaders(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	hash := fakenode.BlockHash(100)
+	cases := []struct {
+		path         string
+		cacheControl string
+	}{
+		{"/block/100", immutableCacheControl},
+		{"/block/hash/" + hash, immutableCacheControl},
+		{"/block/100/txs/0", immutableCacheControl},
+		{"/block/100/txs/0/receipt", immutableCacheControl},
+		{"/block/latest", "public, max-age=2"},
+		{fmt.Sprintf("/block/%d", node.Head()-1), "public, max-age=2"},
+	}
+	for _, c := range cases {
+		resp, _ := get(t, s, c.path)
+		if resp == nil {
+			return
+		}
+		if got := resp.Header.Get("Cache-Control"); got != c.cacheControl {
+			t.Errorf("Invalid Cache-Control for %s: %s\nexpected: %s", c.path, got, c.cacheControl)
+		}
+		if resp.Header.Get("ETag") == "" {
+			t.Errorf("No ETag for %s", c.path)
+		}
+	}
+
+	resp, _ := get(t, s, "/block/100")
+	if resp == nil {
+		return
+	}
+	if etag := resp.Header.Get("ETag"); etag != `"`+hash+`"` {
+		t.Errorf("Invalid ETag: %s\nexpected: \"%s\"", etag, hash)
+	}
+	projected, _ := get(t, s, "/block/100?txs=full")
+	if projected == nil {
+		return
+	}
+	if projected.Header.Get("ETag") == resp.Header.Get("ETag") {
+		t.Errorf("The same ETag for different representations: %s", resp.Header.Get("ETag"))
+	}
+}
+
+func TestBlockCacheHeadersSubsecond(t *testing.T) {
+	// max-age is in whole seconds, a shorter one is rounded up rather than down to 0
+	s, closeClient := newServer(t, node, client.Config{}, Config{MaxAge: 100 * time.Millisecond})
+	defer closeClient()
+
+	resp, _ := get(t, s, "/block/latest")
+	if resp == nil {
+		return
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "public, max-age=1" {
+		t.Errorf("Invalid Cache-Control: %s\nexpected: public, max-age=1", got)
+	}
+}
+
+func TestBlockNotModified(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	etag := `"` + fakenode.BlockHash(100) + `"`
+	cases := map[string]int{
+		etag:                                fasthttp.StatusNotModified,
+		"W/" + etag:                         fasthttp.StatusNotModified,
+		`"0x1", ` + etag:                    fasthttp.StatusNotModified,
+		"*":                                 fasthttp.StatusNotModified,
+		`"` + fakenode.BlockHash(101) + `"`: fasthttp.StatusOK,
+	}
+	for header, status := range cases {
+		resp, _ := getWith(t, s, "/block/100", map[string]string{"If-None-Match": header})
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != status {
+			t.Errorf("Invalid status code for If-None-Match %s: %d\nexpected: %d", header, resp.StatusCode, status)
+		}
+		if resp.Header.Get("ETag") != etag {
+			t.Errorf("Invalid ETag for If-None-Match %s: %s", header, resp.Header.Get("ETag"))
+		}
+	}
+}
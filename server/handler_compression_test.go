@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/karlseguin/ccache/v2"
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+)
+
+// decode reverses a content coding of a response body
+func decode(t *testing.T, encoding string, body []byte) []byte {
+	var decoded []byte
+	var err error
+	switch encoding {
+	case encodingBrotli:
+		decoded, err = fasthttp.AppendUnbrotliBytes(nil, body)
+	case encodingGzip:
+		decoded, err = fasthttp.AppendGunzipBytes(nil, body)
+	case encodingDeflate:
+		decoded, err = fasthttp.AppendInflateBytes(nil, body)
+	default:
+		decoded = body
+	}
+	if err != nil {
+		t.Errorf("Invalid %s body: %s", encoding, err)
+	}
+	return decoded
+}
+
+func TestCompression(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	plainResp, plain := get(t, s, "/block/100?txs=full")
+	if plainResp == nil {
+		return
+	}
+	plainETag := plainResp.Header.Get("ETag")
+	cases := map[string]string{
+		"gzip":                      encodingGzip,
+		"deflate":                   encodingDeflate,
+		"br":                        encodingBrotli,
+		"gzip, deflate, br":         encodingBrotli,
+		"gzip;q=1, br;q=0.5":        encodingGzip,
+		"br;q=0, *":                 encodingGzip,
+		"identity":                  "",
+		"gzip;q=0, deflate;q=0":     "",
+		"*;q=0, identity":           "",
+		"GZIP;Q=0.3, deflate;q=0.2": encodingGzip,
+	}
+	for header, encoding := range cases {
+		resp, body := getWith(t, s, "/block/100?txs=full", map[string]string{"Accept-Encoding": header})
+		if resp == nil {
+			return
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != encoding {
+			t.Errorf("Invalid Content-Encoding for %s: %s\nexpected: %s", header, got, encoding)
+			continue
+		}
+		if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Invalid Vary for %s: %s", header, got)
+		}
+		if decoded := decode(t, encoding, body); !bytes.Equal(decoded, plain) {
+			t.Errorf("Invalid %s body for %s: %s", encoding, header, decoded)
+		}
+		etag := plainETag
+		if encoding != "" {
+			etag = encodedETag(plainETag, encoding)
+		}
+		if got := resp.Header.Get("ETag"); got != etag {
+			t.Errorf("Invalid ETag for %s: %s\nexpected: %s", header, got, etag)
+		}
+	}
+}
+
+func TestCompressionSkipped(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	// an error is smaller than the threshold
+	resp, body := getWith(t, s, "/block/0x1g", map[string]string{"Accept-Encoding": "gzip"})
+	if resp == nil {
+		return
+	}
+	if resp.Header.Get("Content-Encoding") != "" || errorOf(t, body).Code != codeInvalidIdentifier {
+		t.Errorf("A small body is compressed: %s", body)
+	}
+
+	disabled, closeDisabled := newServer(t, node, client.Config{}, Config{CompressMinSize: -1})
+	defer closeDisabled()
+	resp, _ = getWith(t, disabled, "/block/100", map[string]string{"Accept-Encoding": "gzip"})
+	if resp == nil {
+		return
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Vary") != "" {
+		t.Errorf("A body is compressed in spite of the config")
+	}
+}
+
+func TestCompressionNotModified(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	resp, _ := getWith(t, s, "/block/100", map[string]string{"Accept-Encoding": "gzip"})
+	if resp == nil {
+		return
+	}
+	etag := resp.Header.Get("ETag")
+	if etag != `"`+fakenode.BlockHash(100)+`-gzip"` {
+		t.Errorf("Invalid ETag of a gzip body: %s", etag)
+	}
+	cases := map[string]int{
+		"gzip": fasthttp.StatusNotModified,
+		"br":   fasthttp.StatusOK,
+	}
+	for encoding, status := range cases {
+		resp, _ := getWith(t, s, "/block/100", map[string]string{"Accept-Encoding": encoding, "If-None-Match": etag})
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != status {
+			t.Errorf("Invalid status code for %s: %d\nexpected: %d", encoding, resp.StatusCode, status)
+		}
+	}
+}
+
+func TestCompressedVariants(t *testing.T) {
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{node.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{Variants: cache})
+
+	paths := map[string]bool{
+		"/block/100": true,
+		// a block near the head may change, so its variant isn't kept
+		"/block/latest": false,
+	}
+	for path, kept := range paths {
+		var bodies [][]byte
+		var etag string
+		for i := 0; i < 2; i++ {
+			resp, body := getWith(t, s, path, map[string]string{"Accept-Encoding": "br"})
+			if resp == nil {
+				return
+			}
+			etag = resp.Header.Get("ETag")
+			bodies = append(bodies, decode(t, encodingBrotli, body))
+		}
+		if !bytes.Equal(bodies[0], bodies[1]) {
+			t.Errorf("Different bodies of %s: %s\n%s", path, bodies[0], bodies[1])
+		}
+		if item := cache.Get(variantKey(etag, "")); (item != nil) != kept {
+			t.Errorf("Invalid variant of %s in the cache: %v\nexpected: %v", path, item != nil, kept)
+		}
+	}
+}
+
+func TestCompressedVariantsOfQueries(t *testing.T) {
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{node.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{Variants: cache})
+
+	// the order of fields and unknown query args don't make another variant
+	get(t, s, "/block/100")
+	items := cache.ItemCount()
+	var etags []string
+	for _, path := range []string{
+		"/block/100?txs=full&fields=hash,transactions",
+		"/block/100?fields=transactions,hash&txs=full",
+		"/block/100?txs=full&fields=hash,transactions&foo=1",
+		"/block/100?txs=full&fields=hash,transactions&foo=2",
+	} {
+		resp, _ := getWith(t, s, path, map[string]string{"Accept-Encoding": "gzip"})
+		if resp == nil {
+			return
+		}
+		etags = append(etags, resp.Header.Get("ETag"))
+	}
+	for _, etag := range etags[1:] {
+		if etag != etags[0] {
+			t.Errorf("Different ETags of one projection: %v", etags)
+			break
+		}
+	}
+	if added := cache.ItemCount() - items; added != 1 {
+		t.Errorf("Invalid number of variants in the cache: %d\nexpected: 1", added)
+	}
+}
+
+func TestCompressedVariantsOfRoutes(t *testing.T) {
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{node.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{Variants: cache})
+
+	// routes of one block share its ETag, each one has its own variant
+	for _, path := range []string{"/block/100", "/block/100/txs/0", "/block/hash/" + fakenode.BlockHash(100), "/block/100/txs/0/receipt"} {
+		plainResp, plain := get(t, s, path)
+		if plainResp == nil {
+			return
+		}
+		for i := 0; i < 2; i++ {
+			resp, body := getWith(t, s, path, map[string]string{"Accept-Encoding": "gzip"})
+			if resp == nil {
+				return
+			}
+			if decoded := decode(t, resp.Header.Get("Content-Encoding"), body); !bytes.Equal(decoded, plain) {
+				t.Errorf("Invalid compressed body of %s: %s\nexpected: %s", path, decoded, plain)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+)
+
+// errorOf decodes the json envelope of a failed request
+func errorOf(t *testing.T, body []byte) *errorBody {
+	envelope := new(errorEnvelope)
+	if err := json.Unmarshal(body, envelope); err != nil || envelope.Error == nil {
+		t.Errorf("Invalid error envelope: %s", body)
+		return &errorBody{}
+	}
+	return envelope.Error
+}
+
+func errorMessage(t *testing.T, body []byte) string {
+	return errorOf(t, body).Message
+}
+
+func expectError(t *testing.T, s *RouterToServe, path string, status int, code string) {
+	resp, body := get(t, s, path)
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode != status {
+		t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Invalid content type for %s: %s", path, ct)
+	}
+	if got := errorOf(t, body).Code; got != code {
+		t.Errorf("Invalid error code for %s: %s\nexpected: %s", path, got, code)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	head := node.Head()
+	cases := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/block/0x1g", fasthttp.StatusBadRequest, codeInvalidIdentifier},
+		{"/blocks?from=x&to=1", fasthttp.StatusBadRequest, codeInvalidQueryParam},
+		{"/blocks?from=2&to=1", fasthttp.StatusBadRequest, codeBadRequest},
+		{fmt.Sprintf("/block/%d", head+100), fasthttp.StatusNotFound, codeBlockNotFound},
+		{fmt.Sprintf("/block/%d/receipts", head+100), fasthttp.StatusNotFound, codeBlockNotFound},
+		{"/block/100/txs/50", fasthttp.StatusNotFound, codeTransactionMissing},
+		{"/block/100/txs/0x" + strings.Repeat("0", 64), fasthttp.StatusNotFound, codeTransactionMissing},
+		{"/tx/0x" + strings.Repeat("0", 64), fasthttp.StatusNotFound, codeTransactionMissing},
+	}
+	for _, c := range cases {
+		expectError(t, s, c.path, c.status, c.code)
+	}
+}
+
+func TestErrorEnvelopeUpstream(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{Retry: client.RetryPolicy{MaxAttempts: 1}}, Config{})
+	defer closeClient()
+
+	n.SetFailing(true)
+	expectError(t, s, "/block/100", fasthttp.StatusBadGateway, codeUpstreamError)
+	expectError(t, s, "/block/100/txs/0", fasthttp.StatusBadGateway, codeUpstreamError)
+	expectError(t, s, "/logs?from=100&to=100", fasthttp.StatusBadGateway, codeUpstreamError)
+}
+
+func TestErrorCanceled(t *testing.T) {
+	// a client that has gone away isn't blamed on ether nodes
+	ctx := new(fasthttp.RequestCtx)
+	fail(ctx, fmt.Errorf("a block request is stopped: %w", context.Canceled))
+	if status := ctx.Response.StatusCode(); status != statusClientClosed {
+		t.Errorf("Invalid status code: %d\nexpected: %d", status, statusClientClosed)
+	}
+	if body := ctx.Response.Body(); len(body) != 0 {
+		t.Errorf("A canceled request has a body: %s", body)
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+)
+
+// gqlAnswer is the body of a GraphQL response
+type gqlAnswer struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func graphQL(t *testing.T, s *RouterToServe, query string, variables string) *gqlAnswer {
+	body := fmt.Sprintf(`{"query":%q,"variables":%s}`, query, variables)
+	resp, data := post(t, s, "/graphql", body)
+	if resp == nil {
+		t.FailNow()
+	}
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Fatalf("Invalid status code: %d\nexpected: %d\n%s", resp.StatusCode, fasthttp.StatusOK, data)
+	}
+	answer := new(gqlAnswer)
+	if err := json.Unmarshal(data, answer); err != nil {
+		t.Fatal(err)
+	}
+	return answer
+}
+
+func TestGraphQLBlock(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{}, Config{})
+	defer closeClient()
+
+	query := `query($n: Long!) {
+		block(number: $n) {
+			number hash transactionCount
+			miner { address balance }
+			parent { number hash parent { number } }
+			transactions { hash index value from { address } block { number } status logs { topics } }
+			transactionAt(index: 1) { hash }
+		}
+	}`
+	var got struct {
+		Block struct {
+			Number           uint64
+			Hash             string
+			TransactionCount int
+			Miner            struct{ Address, Balance string }
+			Parent           struct {
+				Number uint64
+				Hash   string
+				Parent struct{ Number uint64 }
+			}
+			Transactions []struct {
+				Hash   string
+				Index  int
+				Value  string
+				From   struct{ Address string }
+				Block  struct{ Number uint64 }
+				Status *uint64
+				Logs   []struct{ Topics []string }
+			}
+			TransactionAt struct{ Hash string }
+		}
+	}
+	upstream := func() int {
+		return n.Calls("eth_getBlockByNumber") + n.Calls("eth_getBlockByHash") +
+			n.Calls("eth_getBlockReceipts") + n.Calls("eth_getTransactionReceipt")
+	}
+	var calls int
+	for i := 0; i < 2; i++ {
+		answer := graphQL(t, s, query, `{"n":"0x64"}`)
+		if len(answer.Errors) > 0 {
+			t.Fatalf("Errors of a query: %+v", answer.Errors)
+		}
+		if err := json.Unmarshal(answer.Data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			calls = upstream()
+		}
+	}
+	// nested blocks and receipts are resolved through the cache, so the same query doesn't go upstream again
+	if got := upstream() - calls; got != 0 {
+		t.Errorf("A cached query has gone upstream %d times", got)
+	}
+
+	b := got.Block
+	if b.Number != 100 || b.Hash != fakenode.BlockHash(100) || b.TransactionCount != fakenode.TxCount(100) {
+		t.Errorf("Invalid block: %+v", b)
+	}
+	if b.Parent.Number != 99 || b.Parent.Hash != fakenode.BlockHash(99) || b.Parent.Parent.Number != 98 {
+		t.Errorf("Invalid parents: %+v", b.Parent)
+	}
+	if b.Miner.Balance != fakenode.Balance(100) {
+		t.Errorf("Invalid balance of the miner at the block: %s\nexpected: %s", b.Miner.Balance, fakenode.Balance(100))
+	}
+	if len(b.Transactions) != fakenode.TxCount(100) || b.TransactionAt.Hash != fakenode.TxHash(100, 1) {
+		t.Fatalf("Invalid transactions: %+v", b.Transactions)
+	}
+	for i, tx := range b.Transactions {
+		if tx.Hash != fakenode.TxHash(100, i) || tx.Index != i || tx.Block.Number != 100 || tx.From.Address != fmt.Sprintf("0x%040x", i+1) {
+			t.Errorf("Invalid transaction %d: %+v", i, tx)
+		}
+		if failed := fakenode.Failed(100, i); tx.Status == nil || (*tx.Status == 0) != failed || failed != (len(tx.Logs) == 0) {
+			t.Errorf("Invalid receipt of the transaction %d: %+v", i, tx)
+		}
+	}
+}
+
+func TestGraphQLQuery(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{}, Config{})
+	defer closeClient()
+
+	head := n.Head()
+	answer := graphQL(t, s, `query($from: Long!, $hash: Bytes32!, $tx: Bytes32!) {
+		blocks(from: $from) { number }
+		byHash: block(hash: $hash) { number }
+		latest: block { number }
+		missing: block(number: "999999999999") { number }
+		transaction(hash: $tx) { index block { number } }
+		logs(filter: { fromBlock: 100, toBlock: 101, topics: [["`+fakenode.TransferTopic+`"]] }) { transaction { hash } }
+	}`, fmt.Sprintf(`{"from":%d,"hash":"%s","tx":"%s"}`, head-2, fakenode.BlockHash(100), fakenode.TxHash(101, 2)))
+	if len(answer.Errors) > 0 {
+		t.Fatalf("Errors of a query: %+v", answer.Errors)
+	}
+	var got struct {
+		Blocks      []struct{ Number uint64 }
+		ByHash      struct{ Number uint64 }
+		Latest      struct{ Number uint64 }
+		Missing     *struct{ Number uint64 }
+		Transaction struct {
+			Index int
+			Block struct{ Number uint64 }
+		}
+		Logs []struct {
+			Transaction struct{ Hash string }
+		}
+	}
+	if err := json.Unmarshal(answer.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Blocks) != 3 || got.Blocks[0].Number != head-2 || got.Blocks[2].Number != head {
+		t.Errorf("Invalid blocks up to the head: %+v", got.Blocks)
+	}
+	if got.ByHash.Number != 100 || got.Latest.Number != head || got.Missing != nil {
+		t.Errorf("Invalid blocks: %s", answer.Data)
+	}
+	if got.Transaction.Index != 2 || got.Transaction.Block.Number != 101 {
+		t.Errorf("Invalid transaction: %+v", got.Transaction)
+	}
+	successful := 0
+	for _, number := range []uint64{100, 101} {
+		for i := 0; i < fakenode.TxCount(number); i++ {
+			if !fakenode.Failed(number, i) {
+				successful++
+			}
+		}
+	}
+	if len(got.Logs) != successful || got.Logs[0].Transaction.Hash == "" {
+		t.Errorf("Invalid logs: %+v\nexpected %d of them", got.Logs, successful)
+	}
+
+	// a query can be sent with GET too
+	resp, body := get(t, s, "/graphql?query="+url.QueryEscape("{ block(number: 5) { hash } }"))
+	if resp == nil {
+		return
+	}
+	if !strings.Contains(string(body), fakenode.BlockHash(5)) {
+		t.Errorf("Invalid answer to GET: %s", body)
+	}
+}
+
+func TestGraphQLInvalid(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	expectError(t, s, "/graphql", fasthttp.StatusBadRequest, codeInvalidQueryParam)
+	expectError(t, s, "/graphql?query=x&variables=nope", fasthttp.StatusBadRequest, codeInvalidQueryParam)
+	resp, body := post(t, s, "/graphql", "{")
+	if resp != nil && (resp.StatusCode != fasthttp.StatusBadRequest || errorOf(t, body).Code != codeBadRequest) {
+		t.Errorf("Invalid answer to a malformed body: %d %s", resp.StatusCode, body)
+	}
+
+	for query, message := range map[string]string{
+		`{ block { unknown } }`:                                                      "Cannot query field",
+		`{ block(hash: "0x12") { number } }`:                                         "has 1 bytes instead of 32",
+		`{ blocks(from: 10, to: 5) { number } }`:                                     "the range is empty",
+		`{ blocks(from: 1, to: 5000) { number } }`:                                   "wider than",
+		`{ block(number: 1, hash: "0x` + strings.Repeat("0", 64) + `") { number } }`: "only one of",
+	} {
+		answer := graphQL(t, s, query, "null")
+		if len(answer.Errors) == 0 || !strings.Contains(answer.Errors[0].Message, message) {
+			t.Errorf("Invalid errors of %s: %+v\nexpected: %s", query, answer.Errors, message)
+		}
+	}
+}
+
+func TestGraphQLCost(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	// every range fits gqlMaxRange, together they need more calls than gqlMaxCost
+	query := `{ a: blocks(from: 1, to: 1000) { number } b: blocks(from: 1001, to: 2000) { number } c: blocks(from: 2001, to: 3000) { number } }`
+	resp, body := post(t, s, "/graphql", fmt.Sprintf(`{"query":%q}`, query))
+	if resp == nil {
+		t.FailNow()
+	}
+	if resp.StatusCode != fasthttp.StatusBadRequest || !strings.Contains(errorOf(t, body).Message, "upstream calls") {
+		t.Errorf("Invalid answer to a costly query: %d %s", resp.StatusCode, body)
+	}
+
+	answer := graphQL(t, s, `{ a: blocks(from: 1, to: 3) { number } b: blocks(from: 4, to: 6) { number } }`, "null")
+	if len(answer.Errors) > 0 {
+		t.Errorf("Invalid errors of a cheap query: %+v", answer.Errors)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/karlseguin/ccache/v2"
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+func TestBlockByHash(t *testing.T) {
+	hashed := fakenode.New(fakenode.DefaultHead)
+	defer hashed.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{hashed.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	blockByHash := func(number uint64) {
+		resp, body := get(t, s, "/block/hash/"+fakenode.BlockHash(number))
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != fasthttp.StatusOK {
+			t.Errorf("Invalid status code: %d\nexpected: %d", resp.StatusCode, fasthttp.StatusOK)
+			return
+		}
+		b := new(model.ShowcaseBlock)
+		if err := json.Unmarshal(body, b); err != nil {
+			t.Error(err)
+			return
+		}
+		if b.Hash != fakenode.BlockHash(number) {
+			t.Errorf("Invalid block hash: %s\nexpected: %s", b.Hash, fakenode.BlockHash(number))
+		}
+	}
+
+	// the first request goes upstream, the next one is served by the index
+	blockByHash(100)
+	blockByHash(100)
+	if calls := hashed.Calls("eth_getBlockByHash"); calls != 1 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 1", calls)
+	}
+
+	// a block cached by its number is indexed by its hash too
+	get(t, s, "/block/150")
+	blockByHash(150)
+	if calls := hashed.Calls("eth_getBlockByHash"); calls != 1 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 1", calls)
+	}
+
+	// the index entry of an evicted block is stale
+	cache.Delete("0x64")
+	blockByHash(100)
+	if calls := hashed.Calls("eth_getBlockByHash"); calls != 2 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 2", calls)
+	}
+}
+
+func TestOrphanByHash(t *testing.T) {
+	n := fakenode.New(1000)
+	defer n.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{n.URL()}, cache, client.Config{Confirmations: 5})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	orphan := n.BlockHash(990)
+	n.Reorg(985)
+	canonical := n.BlockHash(990)
+	get(t, s, "/block/990")
+
+	// a block that has fallen off the chain is served by its hash, but it doesn't replace the canonical one
+	for i := 1; i <= 2; i++ {
+		resp, body := get(t, s, "/block/hash/"+orphan)
+		if resp == nil {
+			return
+		}
+		b := new(model.ShowcaseBlock)
+		if err := json.Unmarshal(body, b); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fasthttp.StatusOK || b.Hash != orphan {
+			t.Errorf("Invalid orphan block: %d %s", resp.StatusCode, body)
+		}
+		if calls := n.Calls("eth_getBlockByHash"); calls != i {
+			t.Errorf("Invalid number of upstream calls: %d\nexpected: %d", calls, i)
+		}
+	}
+	if b := cache.Get("0x3de"); b == nil || b.Value().(*model.Block).Hash != canonical {
+		t.Errorf("The canonical block 990 has been replaced in the cache")
+	}
+	_, body := get(t, s, "/block/990")
+	if !strings.Contains(string(body), canonical) {
+		t.Errorf("Invalid block 990: %s\nexpected: %s", body, canonical)
+	}
+}
+
+func TestBlockByHashInvalid(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	cases := map[string]int{
+		"/block/hash/0x1234":                       fasthttp.StatusBadRequest,
+		"/block/hash/0x" + strings.Repeat("z", 64): fasthttp.StatusBadRequest,
+		"/block/hash/0x" + strings.Repeat("0", 64): fasthttp.StatusNotFound,
+	}
+	for path, status := range cases {
+		resp, _ := get(t, s, path)
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != status {
+			t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, status)
+		}
+	}
+}
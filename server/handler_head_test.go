@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+)
+
+// waitHead requests /head till the head meets a condition
+func waitHead(t *testing.T, s *RouterToServe, what string, ok func(client.HeadStatus) bool) {
+	var head client.HeadStatus
+	for i := 0; i < 300; i++ {
+		_, body := get(t, s, "/head")
+		if err := json.Unmarshal(body, &head); err != nil {
+			t.Fatal(err)
+		}
+		if ok(head) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("The head hasn't %s: %+v", what, head)
+}
+
+func TestHeadPolling(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{Head: client.HeadConfig{Interval: 10 * time.Millisecond}}, Config{})
+	defer closeClient()
+
+	head := n.Head()
+	waitHead(t, s, "come with the latest block", func(h client.HeadStatus) bool {
+		return h.Number == head && h.Hash == n.BlockHash(head) && h.Age != ""
+	})
+
+	// a block without enough confirmations may change
+	path := fmt.Sprintf("/block/%d", head+5)
+	n.SetHead(head + 5)
+	resp, _ := get(t, s, path)
+	if resp == nil {
+		return
+	}
+	if resp.Header.Get("Cache-Control") == immutableCacheControl {
+		t.Errorf("The head block is immutable")
+	}
+
+	// nobody requests the latest block, but the block gets deep enough
+	n.SetHead(head + 30)
+	waitHead(t, s, "been polled", func(h client.HeadStatus) bool {
+		return h.Number == head+30 && h.Source == "polling" && !h.Subscribed
+	})
+	resp, _ = get(t, s, path)
+	if resp == nil {
+		return
+	}
+	if resp.Header.Get("Cache-Control") != immutableCacheControl {
+		t.Errorf("The block with enough confirmations isn't immutable: %s", resp.Header.Get("Cache-Control"))
+	}
+}
+
+func TestHeadSubscription(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	// the head is polled only while the subscription is down
+	s, closeClient := newServer(t, n, client.Config{Head: client.HeadConfig{
+		WebSocket:   n.WSURL(),
+		Interval:    20 * time.Millisecond,
+		Resubscribe: 100 * time.Millisecond,
+	}}, Config{})
+	defer closeClient()
+
+	waitHead(t, s, "been subscribed", func(h client.HeadStatus) bool { return h.Subscribed })
+	head := n.Head()
+	n.SetHead(head + 3)
+	waitHead(t, s, "come with the subscription", func(h client.HeadStatus) bool {
+		return h.Number == head+3 && h.Hash == n.BlockHash(head+3) && h.Source == "subscription"
+	})
+
+	n.DropSubscriptions()
+	waitHead(t, s, "lost the subscription", func(h client.HeadStatus) bool { return !h.Subscribed })
+	n.SetHead(head + 4)
+	waitHead(t, s, "been polled", func(h client.HeadStatus) bool {
+		return h.Number == head+4 && h.Source == "polling"
+	})
+	waitHead(t, s, "been subscribed again", func(h client.HeadStatus) bool { return h.Subscribed })
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/store"
+	"my.eth.test/webhook"
+)
+
+// receiver records webhook requests, it fails the first ones
+type receiver struct {
+	lock     sync.Mutex
+	failures int
+	events   []*webhook.Event
+	ids      []string
+	invalid  []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	body, _ := ioutil.ReadAll(req.Body)
+	var timestamp int64
+	var signature string
+	fmt.Sscanf(strings.Replace(req.Header.Get(webhook.HeaderSignature), ",", " ", 1), "t=%d v1=%s", &timestamp, &signature)
+	if signature != webhook.Sign("secret", timestamp, body) {
+		r.invalid = append(r.invalid, req.Header.Get(webhook.HeaderSignature))
+	}
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	event := new(webhook.Event)
+	json.Unmarshal(body, event)
+	r.events = append(r.events, event)
+	r.ids = append(r.ids, req.Header.Get(webhook.HeaderDelivery))
+}
+
+// received returns events once there are enough of them
+func (r *receiver) received(t *testing.T, count int) []*webhook.Event {
+	for i := 0; i < 300; i++ {
+		r.lock.Lock()
+		if len(r.events) >= count {
+			events := append([]*webhook.Event(nil), r.events...)
+			r.lock.Unlock()
+			return events
+		}
+		r.lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Only %d events received\nexpected: %d", len(r.events), count)
+	return nil
+}
+
+func newHookServer(t *testing.T, n *fakenode.Node, st webhook.Store) (*RouterToServe, func()) {
+	s, closeClient := newServer(t, n, client.Config{Head: client.HeadConfig{Interval: 10 * time.Millisecond}}, Config{})
+	m, err := webhook.New(s.client, webhook.Config{
+		Store:     st,
+		Interval:  10 * time.Millisecond,
+		BaseDelay: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.conf.Hooks = m
+	return s, func() {
+		m.Close()
+		closeClient()
+	}
+}
+
+func registerHook(t *testing.T, s *RouterToServe, body string) *webhook.Hook {
+	resp, data := post(t, s, "/hooks", body)
+	if resp == nil {
+		t.FailNow()
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Invalid status code: %d\nexpected: %d\n%s", resp.StatusCode, http.StatusCreated, data)
+	}
+	hook := new(webhook.Hook)
+	if err := json.Unmarshal(data, hook); err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+func deliveries(t *testing.T, s *RouterToServe, path string) []*webhook.Delivery {
+	_, body := get(t, s, path)
+	var list []*webhook.Delivery
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatalf("%s: %s", err, body)
+	}
+	return list
+}
+
+func TestHookDeliveries(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st, err := store.Open(dir, store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	s, closeAll := newHookServer(t, n, st)
+
+	r := &receiver{failures: 1}
+	target := httptest.NewServer(r)
+	defer target.Close()
+	blocks := registerHook(t, s, fmt.Sprintf(`{"url":"%s","secret":"secret","filter":{"blocks":true}}`, target.URL))
+	if blocks.Secret != "secret" {
+		t.Errorf("The secret isn't shown on registration")
+	}
+	// the third transaction of every block is from 0x..03 with 3 gwei
+	txs := registerHook(t, s, fmt.Sprintf(
+		`{"url":"%s","secret":"secret","filter":{"from":["0x%040x"],"minValue":"3000000000"}}`, target.URL, 3,
+	))
+
+	head := n.Head()
+	n.SetHead(head + 2)
+	events := r.received(t, 4)
+	for _, event := range events {
+		if event.Kind == webhook.KindTransaction && event.Transaction.From != fmt.Sprintf("0x%040x", 3) {
+			t.Errorf("Invalid transaction of a hook: %+v", event.Transaction)
+		}
+	}
+	r.lock.Lock()
+	if len(r.invalid) > 0 {
+		t.Errorf("Invalid signatures: %v", r.invalid)
+	}
+	r.lock.Unlock()
+
+	// a failed attempt is retried
+	retried := false
+	for i := 0; i < 300 && !retried; i++ {
+		for _, hook := range []*webhook.Hook{blocks, txs} {
+			for _, d := range deliveries(t, s, fmt.Sprintf("/hooks/%s/deliveries?status=delivered", hook.ID)) {
+				if d.Hook != hook.ID {
+					t.Fatalf("Invalid delivery of the hook %s: %+v", hook.ID, d)
+				}
+				retried = retried || d.Attempts == 2 && d.LastStatus == http.StatusOK
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !retried {
+		t.Errorf("No delivery is retried")
+	}
+
+	// hooks and deliveries survive a restart, secrets are never listed
+	closeAll()
+	s, closeAll = newHookServer(t, n, st)
+	defer closeAll()
+	_, body := get(t, s, "/hooks")
+	var hooks []*webhook.Hook
+	if err := json.Unmarshal(body, &hooks); err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 2 || hooks[0].ID != blocks.ID || hooks[1].ID != txs.ID || hooks[0].Secret != "" {
+		t.Errorf("Invalid hooks after a restart: %s", body)
+	}
+	list := deliveries(t, s, fmt.Sprintf("/hooks/%s/deliveries?limit=1", txs.ID))
+	if len(list) != 1 || list[0].Kind != webhook.KindTransaction {
+		t.Fatalf("Invalid deliveries of the transaction hook: %+v", list)
+	}
+
+	count := len(r.received(t, 0))
+	resp, data := post(t, s, fmt.Sprintf("/hooks/%s/deliveries/%s/replay", txs.ID, list[0].ID), "")
+	if resp == nil {
+		return
+	}
+	replayed := new(webhook.Delivery)
+	json.Unmarshal(data, replayed)
+	if resp.StatusCode != http.StatusCreated || replayed.ReplayOf != list[0].ID {
+		t.Errorf("Invalid replay: %d %s", resp.StatusCode, data)
+	}
+	r.received(t, count+1)
+	r.lock.Lock()
+	if id := r.ids[len(r.ids)-1]; id != replayed.ID {
+		t.Errorf("Invalid replayed delivery: %s\nexpected: %s", id, replayed.ID)
+	}
+	r.lock.Unlock()
+
+	r2, _ := http.NewRequest("DELETE", "http://test/hooks/"+blocks.ID, nil)
+	resp, err = serve(RegisterHandler(s), r2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Invalid status code of a removal: %d", resp.StatusCode)
+	}
+	expectError(t, s, "/hooks/"+blocks.ID, fasthttp.StatusNotFound, codeHookNotFound)
+}
+
+func TestHookReorg(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeAll := newHookServer(t, n, nil)
+	defer closeAll()
+
+	r := &receiver{}
+	target := httptest.NewServer(r)
+	defer target.Close()
+	registerHook(t, s, fmt.Sprintf(`{"url":"%s","secret":"secret","filter":{"blocks":true}}`, target.URL))
+
+	// a block near the head is replaced before it's final, only the canonical one is delivered
+	const confirmations = 20 // the default of the client
+	head := n.Head()
+	n.SetHead(head + 1)
+	time.Sleep(100 * time.Millisecond)
+	n.Reorg(head + 1)
+	n.SetHead(head + 1 + confirmations)
+	events := r.received(t, confirmations+1)
+	delivered := false
+	for _, event := range events {
+		number, err := parseBlockNumber(event.Block.Number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Block.Hash != n.BlockHash(number) {
+			t.Errorf("An orphaned block %d is delivered: %s\nexpected: %s", number, event.Block.Hash, n.BlockHash(number))
+		}
+		delivered = delivered || number == head+1
+	}
+	if !delivered {
+		t.Errorf("The canonical block %d isn't delivered", head+1)
+	}
+}
+
+func TestHooksInvalid(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeAll := newHookServer(t, n, nil)
+	defer closeAll()
+
+	for _, body := range []string{
+		`{"url":"ftp://example.com","filter":{"blocks":true}}`,
+		`{"url":"http://example.com","filter":{}}`,
+		`{"url":"http://example.com","filter":{"to":["0x12"]}}`,
+		`{"url":"http://example.com","filter":{"minValue":"lots"}}`,
+		`{"url":`,
+	} {
+		resp, data := post(t, s, "/hooks", body)
+		if resp == nil {
+			continue
+		}
+		if resp.StatusCode != http.StatusBadRequest || errorOf(t, data).Code != codeInvalidHook {
+			t.Errorf("Invalid answer to %s: %d %s", body, resp.StatusCode, data)
+		}
+	}
+	hook := registerHook(t, s, `{"url":"http://127.0.0.1:1","filter":{"blocks":true}}`)
+	if len(hook.Secret) == 0 {
+		t.Errorf("No secret is generated")
+	}
+	expectError(t, s, "/hooks/nope/deliveries", fasthttp.StatusNotFound, codeHookNotFound)
+	expectError(t, s, fmt.Sprintf("/hooks/%s/deliveries?status=lost", hook.ID), fasthttp.StatusBadRequest, codeInvalidQueryParam)
+	resp, data := post(t, s, fmt.Sprintf("/hooks/%s/deliveries/nope/replay", hook.ID), "")
+	if resp != nil && (resp.StatusCode != http.StatusNotFound || errorOf(t, data).Code != codeDeliveryNotFound) {
+		t.Errorf("Invalid answer to a replay of an unknown delivery: %d %s", resp.StatusCode, data)
+	}
+
+	// without a manager webhooks are unsupported
+	plain, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+	expectError(t, plain, "/hooks", fasthttp.StatusNotFound, codeUnsupported)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+func TestIdentifierFormats(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	// the same block and the same transaction in every format
+	for _, path := range []string{
+		"/block/16/txs/1",
+		"/block/0x10/txs/0x1",
+		"/block/" + fakenode.BlockHash(16) + "/txs/1",
+		"/block/16/txs/" + fakenode.TxHash(16, 1),
+	} {
+		resp, body := get(t, s, path)
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != fasthttp.StatusOK {
+			t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, fasthttp.StatusOK)
+			continue
+		}
+		tx := new(model.Transaction)
+		if err := json.Unmarshal(body, tx); err != nil {
+			t.Error(err)
+			continue
+		}
+		if tx.Hash != fakenode.TxHash(16, 1) {
+			t.Errorf("Invalid transaction for %s: %s\nexpected: %s", path, tx.Hash, fakenode.TxHash(16, 1))
+		}
+	}
+	expectBlockNumber(t, s, "/block/0x10", 16)
+	expectBlockNumber(t, s, "/block/"+fakenode.BlockHash(16), 16)
+}
+
+func TestIdentifierErrors(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	cases := map[string]string{
+		"/block/0x":                                      "0x has no hex digits",
+		"/block/0x1g":                                    "a hex quantity has a non-hex digit",
+		"/block/18446744073709551616":                    "the number doesn't fit 64 bits",
+		"/block/12a":                                     "not a decimal number",
+		"/block/0x" + strings.Repeat("1", 20):            "a hex quantity is 16 digits at most and a hash is 64 digits",
+		"/block/0x" + strings.Repeat("g", 64):            "a hash has a non-hex digit",
+		"/block/1/txs/latest":                            "a transaction is an index or a hash, not a tag",
+		"/block/hash/12":                                 "a hash is 0x and 64 hex digits",
+		"/tx/0x" + strings.Repeat("1", 10):               "a hash is 0x and 64 hex digits",
+		"/block/latest/txs/0x" + strings.Repeat("z", 64): "a hash has a non-hex digit",
+	}
+	for path, reason := range cases {
+		resp, body := get(t, s, path)
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != fasthttp.StatusBadRequest {
+			t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, fasthttp.StatusBadRequest)
+			continue
+		}
+		if !strings.HasSuffix(errorMessage(t, body), reason) {
+			t.Errorf("Invalid message for %s: %s\nexpected the reason: %s", path, body, reason)
+		}
+	}
+}
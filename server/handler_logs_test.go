@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+// address is the address of the i-th transaction recipient of every synthetic block, it emits their Transfer logs
+func address(i int) string {
+	return fmt.Sprintf("0x%040x", i+2)
+}
+
+// emits says whether the i-th transaction of a synthetic block has a log
+func emits(number uint64, i int) bool {
+	return i < fakenode.TxCount(number) && !fakenode.Failed(number, i)
+}
+
+func expectLogs(t *testing.T, s *RouterToServe, path string, expected []string) {
+	resp, body := get(t, s, path)
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Errorf("Invalid status code: %d\nexpected: %d", resp.StatusCode, fasthttp.StatusOK)
+		return
+	}
+	var logs []*model.Log
+	if err := json.Unmarshal(body, &logs); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(logs) != len(expected) {
+		t.Errorf("Invalid number of logs: %d\nexpected: %d", len(logs), len(expected))
+		return
+	}
+	for i, l := range logs {
+		if l.TransactionHash != expected[i] {
+			t.Errorf("Invalid transaction of the log %d: %s\nexpected: %s", i, l.TransactionHash, expected[i])
+		}
+	}
+}
+
+func TestLogsFromCache(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{}, Config{})
+	defer closeClient()
+
+	// only the blocks whose bloom has the address are requested
+	var expected []string
+	for number := uint64(1000); number < 1040; number++ {
+		if emits(number, 4) {
+			expected = append(expected, fakenode.TxHash(number, 4))
+		}
+	}
+	path := "/logs?from=1000&to=1039&address=" + address(4)
+	expectLogs(t, s, path, expected)
+	if calls := n.Calls("eth_getLogs"); calls != len(expected) {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: %d", calls, len(expected))
+	}
+	expectLogs(t, s, path, expected)
+	if calls := n.Calls("eth_getLogs"); calls != len(expected) {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: %d", calls, len(expected))
+	}
+
+	// cached logs are filtered by topics here
+	expected = nil
+	for number := uint64(1000); number < 1040; number++ {
+		if emits(number, 3) {
+			expected = append(expected, fakenode.TxHash(number, 3))
+		}
+		if emits(number, 4) {
+			expected = append(expected, fakenode.TxHash(number, 4))
+		}
+	}
+	topic := fmt.Sprintf("0x%064x", 4)
+	expectLogs(t, s, "/logs?from=1000&to=1039&topic0="+fakenode.TransferTopic+"&topic1="+topic+","+fmt.Sprintf("0x%064x", 5), expected)
+}
+
+func TestLogsRecent(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{}, Config{})
+	defer closeClient()
+
+	// blocks below 20 confirmations are served block by block, the rest is forwarded with the filter
+	head := n.Head()
+	var expected []string
+	candidates := 0
+	for number := head - 25; number <= head; number++ {
+		if emits(number, 0) {
+			expected = append(expected, fakenode.TxHash(number, 0))
+			if number <= head-20 {
+				candidates++
+			}
+		}
+	}
+	expectLogs(t, s, fmt.Sprintf("/logs?from=%d&address=%s", head-25, address(0)), expected)
+	if calls := n.Calls("eth_getLogs"); calls != candidates+1 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: %d", calls, candidates+1)
+	}
+}
+
+func TestRPCLogs(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{}, Config{})
+	defer closeClient()
+
+	var expected []string
+	for i := 0; i < fakenode.TxCount(500); i++ {
+		if emits(500, i) {
+			expected = append(expected, fakenode.TxHash(500, i))
+		}
+	}
+	for _, params := range []string{
+		`[{"fromBlock":"0x1f4","toBlock":"0x1f4","topics":[["` + fakenode.TransferTopic + `"]]}]`,
+		`[{"blockHash":"` + fakenode.BlockHash(500) + `","topics":[null]}]`,
+	} {
+		answer := callRPC(t, s, "eth_getLogs", params)
+		if answer == nil || answer.Error != nil {
+			t.Errorf("Unexpected answer: %+v", answer)
+			return
+		}
+		var logs []*model.Log
+		if err := json.Unmarshal(answer.Result, &logs); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(logs) != len(expected) {
+			t.Errorf("Invalid number of logs: %d\nexpected: %d", len(logs), len(expected))
+			continue
+		}
+		for i, l := range logs {
+			if l.TransactionHash != expected[i] || l.BlockHash != fakenode.BlockHash(500) {
+				t.Errorf("Invalid log %d: %+v", i, l)
+			}
+		}
+	}
+	// the block is cached by the first call, so the second one is served by the hash index
+	if calls := n.Calls("eth_getLogs"); calls != 1 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 1", calls)
+	}
+
+	answer := callRPC(t, s, "eth_getLogs", `[{"blockHash":"`+fakenode.BlockHash(500)+`","fromBlock":"0x1"}]`)
+	if answer != nil && (answer.Error == nil || answer.Error.Code != rpcInvalidParams) {
+		t.Errorf("Unexpected answer: %+v", answer)
+	}
+}
+
+func TestLogsInvalid(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	for _, path := range []string{
+		"/logs",
+		"/logs?from=20&to=10",
+		"/logs?from=0xz",
+		"/logs?from=1&blockHash=" + fakenode.BlockHash(1),
+		"/logs?blockHash=0x1234",
+	} {
+		resp, _ := get(t, s, path)
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != fasthttp.StatusBadRequest {
+			t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, fasthttp.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+func TestBlockProjection(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	b := fakenode.Block(100)
+	cases := map[string]string{
+		"/block/100?fields=hash,number,gasUsed": fmt.Sprintf(
+			`{"gasUsed":"%s","hash":"%s","number":"0x64"}`, b.GasUsed, b.Hash,
+		),
+		"/block/100?fields=number,transactions&txs=hashes": fmt.Sprintf(
+			`{"number":"0x64","transactions":["%s","%s"]}`, b.Transactions[0].Hash, b.Transactions[1].Hash,
+		),
+		"/block/100?fields=number,transactions&txs=none": `{"number":"0x64"}`,
+		"/blocks?from=100&to=101&fields=number":          "{\"number\":\"0x64\"}\n{\"number\":\"0x65\"}\n",
+	}
+	for path, expected := range cases {
+		resp, body := get(t, s, path)
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != fasthttp.StatusOK {
+			t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, fasthttp.StatusOK)
+			continue
+		}
+		if string(body) != expected {
+			t.Errorf("Invalid body for %s: %s\nexpected: %s", path, body, expected)
+		}
+	}
+}
+
+func TestBlockFullTransactions(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	for _, path := range []string{
+		"/block/100?txs=full",
+		"/block/hash/" + fakenode.BlockHash(100) + "?txs=full",
+	} {
+		resp, body := get(t, s, path)
+		if resp == nil {
+			return
+		}
+		b := new(model.Block)
+		if err := json.Unmarshal(body, b); err != nil {
+			t.Error(err)
+			continue
+		}
+		if b.Hash != fakenode.BlockHash(100) || len(b.Transactions) != fakenode.TxCount(100) ||
+			b.Transactions[1].Hash != fakenode.TxHash(100, 1) || b.Transactions[1].From == "" {
+			t.Errorf("Invalid block for %s: %s", path, body)
+		}
+	}
+
+	resp, body := get(t, s, "/block/100?txs=none")
+	if resp == nil {
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := fields["transactions"]; ok || len(fields) == 0 {
+		t.Errorf("Invalid block without transactions: %s", body)
+	}
+}
+
+func TestBlockProjectionInvalid(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	for _, path := range []string{
+		"/block/100?txs=all",
+		"/block/100?fields=hash,foo",
+		"/blocks?from=1&to=2&fields=Transactions",
+	} {
+		resp, _ := get(t, s, path)
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != fasthttp.StatusBadRequest {
+			t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, fasthttp.StatusBadRequest)
+		}
+	}
+}
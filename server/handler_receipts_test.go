@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+func expectReceipts(t *testing.T, s *RouterToServe, number uint64) {
+	resp, body := get(t, s, fmt.Sprintf("/block/%d/receipts", number))
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Errorf("Invalid status code: %d\nexpected: %d", resp.StatusCode, fasthttp.StatusOK)
+		return
+	}
+	var receipts model.Receipts
+	if err := json.Unmarshal(body, &receipts); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(receipts) != fakenode.TxCount(number) {
+		t.Errorf("Invalid number of receipts: %d\nexpected: %d", len(receipts), fakenode.TxCount(number))
+		return
+	}
+	for i, r := range receipts {
+		if r.TransactionHash != fakenode.TxHash(number, i) {
+			t.Errorf("Invalid transaction hash of the receipt %d: %s", i, r.TransactionHash)
+		}
+		if failed := r.Status == "0x0"; failed != fakenode.Failed(number, i) || failed != (len(r.Logs) == 0) {
+			t.Errorf("Invalid status of the receipt %d: %s with %d logs", i, r.Status, len(r.Logs))
+		}
+	}
+}
+
+func TestBlockReceipts(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{}, Config{})
+	defer closeClient()
+
+	// receipts of a deep block are cached
+	expectReceipts(t, s, 100)
+	expectReceipts(t, s, 100)
+	if calls := n.Calls("eth_getBlockReceipts"); calls != 1 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 1", calls)
+	}
+
+	// a receipt of a transaction of a deep block is taken from them
+	resp, body := get(t, s, "/block/100/txs/1/receipt")
+	if resp == nil {
+		return
+	}
+	r := new(model.Receipt)
+	if err := json.Unmarshal(body, r); err != nil {
+		t.Error(err)
+		return
+	}
+	if r.TransactionHash != fakenode.TxHash(100, 1) {
+		t.Errorf("Invalid transaction hash: %s\nexpected: %s", r.TransactionHash, fakenode.TxHash(100, 1))
+	}
+	if calls := n.Calls("eth_getBlockReceipts") + n.Calls("eth_getTransactionReceipt"); calls != 1 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 1", calls)
+	}
+
+	// a receipt of a recent transaction is requested alone
+	resp, _ = get(t, s, "/block/latest/txs/0/receipt")
+	if resp != nil && resp.StatusCode != fasthttp.StatusOK {
+		t.Errorf("Invalid status code: %d\nexpected: %d", resp.StatusCode, fasthttp.StatusOK)
+	}
+	if calls := n.Calls("eth_getTransactionReceipt"); calls != 1 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 1", calls)
+	}
+
+	resp, _ = get(t, s, "/block/100/txs/50/receipt")
+	if resp != nil && resp.StatusCode != fasthttp.StatusNotFound {
+		t.Errorf("Invalid status code: %d\nexpected: %d", resp.StatusCode, fasthttp.StatusNotFound)
+	}
+}
+
+func TestBlockReceiptsOneByOne(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	n.Disable("eth_getBlockReceipts")
+	s, closeClient := newServer(t, n, client.Config{}, Config{})
+	defer closeClient()
+
+	expectReceipts(t, s, 200)
+	expectReceipts(t, s, 201)
+	if calls := n.Calls("eth_getBlockReceipts"); calls != 1 {
+		t.Errorf("Invalid number of eth_getBlockReceipts calls: %d\nexpected: 1", calls)
+	}
+	expected := fakenode.TxCount(200) + fakenode.TxCount(201)
+	if calls := n.Calls("eth_getTransactionReceipt"); calls != expected {
+		t.Errorf("Invalid number of eth_getTransactionReceipt calls: %d\nexpected: %d", calls, expected)
+	}
+	if batches := n.Batches(); batches != 2 {
+		t.Errorf("Invalid number of batches: %d\nexpected: 2", batches)
+	}
+}
+
+func TestBlockReceiptsByHash(t *testing.T) {
+	n := fakenode.New(1000)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{Confirmations: 5}, Config{})
+	defer closeClient()
+
+	resp, body := get(t, s, "/block/"+n.BlockHash(100)+"/receipts")
+	if resp == nil {
+		return
+	}
+	var receipts model.Receipts
+	if err := json.Unmarshal(body, &receipts); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fasthttp.StatusOK || len(receipts) != fakenode.TxCount(100) || receipts[0].BlockHash != n.BlockHash(100) {
+		t.Errorf("Invalid receipts of the block 100: %d %s", resp.StatusCode, body)
+	}
+
+	// receipts of the number of an orphaned block are of the canonical one
+	orphan := n.BlockHash(990)
+	n.Reorg(985)
+	expectError(t, s, "/block/"+orphan+"/receipts", fasthttp.StatusNotFound, codeBlockNotFound)
+}
+
+func TestBlockReceiptsNotModified(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	resp, _ := get(t, s, "/block/100/receipts")
+	if resp == nil {
+		return
+	}
+	etag := resp.Header.Get("ETag")
+	if !strings.HasPrefix(etag, `"`+fakenode.BlockHash(100)) {
+		t.Fatalf("Invalid ETag: %s", etag)
+	}
+	resp, body := getWith(t, s, "/block/100/receipts", map[string]string{"If-None-Match": etag})
+	if resp != nil && (resp.StatusCode != fasthttp.StatusNotModified || len(body) != 0) {
+		t.Errorf("Invalid answer for a known ETag: %d %s", resp.StatusCode, body)
+	}
+}
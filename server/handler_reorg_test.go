@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/karlseguin/ccache/v2"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+func TestConfirmationDepth(t *testing.T) {
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{node.URL()}, cache, client.Config{Confirmations: 5})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	head := node.Head()
+	get(t, s, fmt.Sprintf("/block/%d", head-4))
+	get(t, s, fmt.Sprintf("/block/%d", head-5))
+	if cache.Get(fmt.Sprintf("0x%x", head-4)) != nil {
+		t.Errorf("The block with 4 confirmations has been cached")
+	}
+	if cache.Get(fmt.Sprintf("0x%x", head-5)) == nil {
+		t.Errorf("The block with 5 confirmations has not been cached")
+	}
+}
+
+func TestFinalityTag(t *testing.T) {
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{node.URL()}, cache, client.Config{Finality: "finalized"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	finalized := node.Head() - fakenode.FinalizedDepth
+	get(t, s, fmt.Sprintf("/block/%d", finalized+1))
+	get(t, s, fmt.Sprintf("/block/%d", finalized))
+	if cache.Get(fmt.Sprintf("0x%x", finalized+1)) != nil {
+		t.Errorf("The block above the finalized one has been cached")
+	}
+	if cache.Get(fmt.Sprintf("0x%x", finalized)) == nil {
+		t.Errorf("The finalized block has not been cached")
+	}
+}
+
+func TestReorgRepairsCache(t *testing.T) {
+	n := fakenode.New(1000)
+	defer n.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	conf := client.Config{Confirmations: 5, LatestWindow: -1}
+	c, err := client.NewJRClient([]string{n.URL()}, cache, conf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	get(t, s, "/block/990")
+	stale := n.BlockHash(990)
+	n.Reorg(985)
+	n.SetHead(1001)
+	get(t, s, "/block/latest")
+
+	// the chain is repaired concurrently
+	canonical := n.BlockHash(990)
+	var hash string
+	for i := 0; i < 100 && hash != canonical; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if b := cache.Get("0x3de"); b != nil {
+			hash = b.Value().(*model.Block).Hash
+		}
+	}
+	if hash != canonical {
+		t.Errorf("The cached block 990 hasn't been replaced: %s\nstale: %s\nexpected: %s", hash, stale, canonical)
+	}
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karlseguin/ccache/v2"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+// rpcAnswer is the answer of the proxy endpoint with a raw result to check it per method
+type rpcAnswer struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *model.EthError `json:"error"`
+}
+
+func post(t *testing.T, s *RouterToServe, path string, body string) (*http.Response, []byte) {
+	r, _ := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%s%s", s.host, s.port, path),
+		strings.NewReader(body),
+	)
+	r.Header.Set("Content-Type", "application/json")
+	res, err := serve(RegisterHandler(s), r)
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+	return res, data
+}
+
+// newServer serves a node through a new client with a 1MiB cache, the returned func closes the client
+func newServer(t *testing.T, n *fakenode.Node, cconf client.Config, conf Config) (*RouterToServe, func()) {
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{n.URL()}, cache, cconf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRouterToServe("test", "", c, conf), c.Close
+}
+
+func callRPC(t *testing.T, s *RouterToServe, method string, params string) *rpcAnswer {
+	resp, body := post(t, s, "/", fmt.Sprintf(`{"jsonrpc":"2.0","id":7,"method":"%s","params":%s}`, method, params))
+	if resp == nil {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Invalid status code: %d\nexpected: %d", resp.StatusCode, http.StatusOK)
+		return nil
+	}
+	answer := new(rpcAnswer)
+	if err := json.Unmarshal(body, answer); err != nil {
+		t.Error(err)
+		return nil
+	}
+	if string(answer.ID) != "7" {
+		t.Errorf("Invalid id: %s\nexpected: 7", answer.ID)
+	}
+	return answer
+}
+
+func TestRPCBlockByNumber(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	answer := callRPC(t, s, "eth_getBlockByNumber", `["0x64", false]`)
+	if answer == nil || answer.Error != nil {
+		t.Errorf("Unexpected answer: %+v", answer)
+		return
+	}
+	b := new(struct {
+		Hash         string   `json:"hash"`
+		Transactions []string `json:"transactions"`
+	})
+	if err := json.Unmarshal(answer.Result, b); err != nil {
+		t.Error(err)
+		return
+	}
+	if b.Hash != fakenode.BlockHash(100) {
+		t.Errorf("Invalid block hash: %s\nexpected: %s", b.Hash, fakenode.BlockHash(100))
+	}
+	if len(b.Transactions) != fakenode.TxCount(100) || b.Transactions[0] != fakenode.TxHash(100, 0) {
+		t.Errorf("Invalid transaction hashes: %v", b.Transactions)
+	}
+
+	answer = callRPC(t, s, "eth_getBlockByNumber", `["0x64", true]`)
+	if answer == nil || answer.Error != nil {
+		t.Errorf("Unexpected answer: %+v", answer)
+		return
+	}
+	full := new(model.Block)
+	if err := json.Unmarshal(answer.Result, full); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(full.Transactions) != fakenode.TxCount(100) || full.Transactions[1].BlockHash != fakenode.BlockHash(100) {
+		t.Errorf("Invalid transactions: %+v", full.Transactions)
+	}
+}
+
+func TestRPCBlockByHash(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	answer := callRPC(t, s, "eth_getBlockByHash", fmt.Sprintf(`["%s", true]`, fakenode.BlockHash(200)))
+	if answer == nil || answer.Error != nil {
+		t.Errorf("Unexpected answer: %+v", answer)
+		return
+	}
+	b := new(model.Block)
+	if err := json.Unmarshal(answer.Result, b); err != nil {
+		t.Error(err)
+		return
+	}
+	if b.Number != "0xc8" {
+		t.Errorf("Invalid block number: %s\nexpected: 0xc8", b.Number)
+	}
+
+	answer = callRPC(t, s, "eth_getBlockTransactionCountByHash", fmt.Sprintf(`["%s"]`, fakenode.BlockHash(200)))
+	if answer == nil || answer.Error != nil {
+		t.Errorf("Unexpected answer: %+v", answer)
+		return
+	}
+	if expected := fmt.Sprintf(`"0x%x"`, fakenode.TxCount(200)); string(answer.Result) != expected {
+		t.Errorf("Invalid transaction count: %s\nexpected: %s", answer.Result, expected)
+	}
+}
+
+func TestRPCTransactionByIndex(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	answer := callRPC(t, s, "eth_getTransactionByBlockNumberAndIndex", `["0x12c", "0x1"]`)
+	if answer == nil || answer.Error != nil {
+		t.Errorf("Unexpected answer: %+v", answer)
+		return
+	}
+	tx := new(model.Transaction)
+	if err := json.Unmarshal(answer.Result, tx); err != nil {
+		t.Error(err)
+		return
+	}
+	if tx.Hash != fakenode.TxHash(300, 1) {
+		t.Errorf("Invalid transaction hash: %s\nexpected: %s", tx.Hash, fakenode.TxHash(300, 1))
+	}
+
+	answer = callRPC(t, s, "eth_getTransactionByBlockNumberAndIndex", `["0x12c", "0x64"]`)
+	if answer == nil || answer.Error != nil || string(answer.Result) != "null" {
+		t.Errorf("Unexpected answer for a missing transaction: %+v", answer)
+	}
+}
+
+func TestRPCBlockNumber(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	answer := callRPC(t, s, "eth_blockNumber", `[]`)
+	if answer == nil || answer.Error != nil {
+		t.Errorf("Unexpected answer: %+v", answer)
+		return
+	}
+	if expected := fmt.Sprintf(`"0x%x"`, node.Head()); string(answer.Result) != expected {
+		t.Errorf("Invalid block number: %s\nexpected: %s", answer.Result, expected)
+	}
+
+	answer = callRPC(t, s, "eth_getBlockByNumber", fmt.Sprintf(`["0x%x", false]`, node.Head()+1000))
+	if answer == nil || answer.Error != nil || string(answer.Result) != "null" {
+		t.Errorf("Unexpected answer for a future block: %+v", answer)
+	}
+}
+
+func TestRPCBlockNumberFromHead(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{Head: client.HeadConfig{Interval: 10 * time.Millisecond}}, Config{})
+	defer closeClient()
+
+	// the head follows the node, the latest block is never requested for the number
+	head := n.Head() + 5
+	n.SetHead(head)
+	waitHead(t, s, "been polled", func(h client.HeadStatus) bool { return h.Number == head })
+	blockCalls := n.Calls("eth_getBlockByNumber")
+	answer := callRPC(t, s, "eth_blockNumber", `[]`)
+	if answer == nil || answer.Error != nil {
+		t.Errorf("Unexpected answer: %+v", answer)
+		return
+	}
+	if expected := fmt.Sprintf(`"0x%x"`, head); string(answer.Result) != expected {
+		t.Errorf("Invalid block number: %s\nexpected: %s", answer.Result, expected)
+	}
+	if calls := n.Calls("eth_getBlockByNumber") - blockCalls; calls != 0 {
+		t.Errorf("Invalid number of upstream block calls: %d\nexpected: 0", calls)
+	}
+}
+
+func TestRPCForward(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	answer := callRPC(t, s, "eth_chainId", `[]`)
+	if answer == nil || answer.Error != nil {
+		t.Errorf("Unexpected answer: %+v", answer)
+		return
+	}
+	if expected := fmt.Sprintf(`"%s"`, fakenode.ChainID); string(answer.Result) != expected {
+		t.Errorf("Invalid chain id: %s\nexpected: %s", answer.Result, expected)
+	}
+
+	answer = callRPC(t, s, "eth_unknownMethod", `[]`)
+	if answer == nil || answer.Error == nil || answer.Error.Code != -32601 {
+		t.Errorf("Unexpected answer for an unknown method: %+v", answer)
+	}
+}
+
+func TestRPCForwardError(t *testing.T) {
+	first := fakenode.New(fakenode.DefaultHead)
+	defer first.Close()
+	second := fakenode.New(fakenode.DefaultHead)
+	defer second.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{first.URL(), second.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	// "header not found" is the answer of any node, so it's neither failed over nor counted against the node
+	for i := 0; i < 10; i++ {
+		answer := callRPC(t, s, "eth_getBalance", `["0x0000000000000000000000000000000000000001","0xffffffff"]`)
+		if answer == nil || answer.Error == nil || answer.Error.Code != -32000 || answer.Error.Message != "header not found" {
+			t.Fatalf("Unexpected answer for a block above the head: %+v", answer)
+		}
+	}
+	if calls := first.Calls("eth_getBalance"); calls != 10 {
+		t.Errorf("Invalid number of calls of the first node: %d\nexpected: 10", calls)
+	}
+	if calls := second.Calls("eth_getBalance"); calls != 0 {
+		t.Errorf("Invalid number of calls of the second node: %d\nexpected: 0", calls)
+	}
+	for _, status := range c.Upstreams() {
+		if !status.Healthy || status.ErrorRate != 0 {
+			t.Errorf("Invalid status of %s: %+v", status.URL, status)
+		}
+	}
+}
+
+func TestRPCInvalidRequests(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	cases := map[string]int64{
+		`{"jsonrpc":"2.0","id":7,`:                                              -32700,
+		`{"jsonrpc":"1.0","id":7,"method":"eth_blockNumber"}`:                   -32600,
+		`{"jsonrpc":"2.0","id":7,"method":"eth_getBlockByNumber","params":[1]}`: -32602,
+		`{"jsonrpc":"2.0","id":7,"method":"eth_getBlockByHash","params":{}}`:    -32602,
+	}
+	for body, code := range cases {
+		resp, data := post(t, s, "/", body)
+		if resp == nil {
+			return
+		}
+		answer := new(rpcAnswer)
+		if err := json.Unmarshal(data, answer); err != nil {
+			t.Error(err)
+			continue
+		}
+		if answer.Error == nil || answer.Error.Code != code {
+			t.Errorf("Unexpected answer for %s: %+v\nexpected code: %d", body, answer.Error, code)
+		}
+	}
+}
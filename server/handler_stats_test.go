@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karlseguin/ccache/v2"
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+)
+
+func TestCoalescedMisses(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{n.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	n.SetDelay(100 * time.Millisecond)
+	calls := n.Calls("eth_getBlockByNumber")
+	const clients = 10
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, _ := get(t, s, "/block/100")
+			if resp != nil && resp.StatusCode != fasthttp.StatusOK {
+				t.Errorf("Invalid status code: %d\nexpectd: %d", resp.StatusCode, fasthttp.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if made := n.Calls("eth_getBlockByNumber") - calls; made != 1 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 1", made)
+	}
+	_, body := get(t, s, "/stats")
+	stats := new(client.Stats)
+	if err := json.Unmarshal(body, stats); err != nil {
+		t.Error(err)
+		return
+	}
+	if stats.Coalesced != clients-1 {
+		t.Errorf("Invalid number of coalesced requests: %d\nexpected: %d", stats.Coalesced, clients-1)
+	}
+}
+
+func TestLatestWindow(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1))
+	c, err := client.NewJRClient([]string{n.URL()}, cache, client.Config{LatestWindow: time.Minute})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	calls := n.Calls("eth_getBlockByNumber")
+	get(t, s, "/block/latest")
+	get(t, s, "/block/latest")
+	if made := n.Calls("eth_getBlockByNumber") - calls; made != 0 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 0", made)
+	}
+}
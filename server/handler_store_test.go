@@ -0,0 +1,49 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/karlseguin/ccache/v2"
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/store"
+)
+
+func TestStoreSurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	run := func() int {
+		st, err := store.Open(dir, store.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer st.Close()
+		cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+		c, err := client.NewJRClient([]string{node.URL()}, cache, client.Config{Store: st})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		s := NewRouterToServe("test", "", c, Config{})
+
+		calls := node.Calls("eth_getBlockByNumber")
+		resp, _ := get(t, s, "/block/100")
+		if resp != nil && resp.StatusCode != fasthttp.StatusOK {
+			t.Errorf("Invalid status code: %d\nexpectd: %d", resp.StatusCode, fasthttp.StatusOK)
+		}
+		return node.Calls("eth_getBlockByNumber") - calls
+	}
+
+	if calls := run(); calls != 1 {
+		t.Errorf("Invalid number of upstream calls before a restart: %d\nexpected: 1", calls)
+	}
+	if calls := run(); calls != 0 {
+		t.Errorf("Invalid number of upstream calls after a restart: %d\nexpected: 0", calls)
+	}
+}
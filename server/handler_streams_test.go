@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+// newStreamServer serves streams of its own node, it polls the node often
+func newStreamServer(t *testing.T) (*fakenode.Node, *fasthttputil.InmemoryListener, func()) {
+	n := fakenode.New(fakenode.DefaultHead)
+	s, closeClient := newServer(t, n, client.Config{
+		LatestWindow: -1,
+		Head:         client.HeadConfig{Interval: 10 * time.Millisecond},
+	}, Config{StreamBacklog: 5})
+	ln := fasthttputil.NewInmemoryListener()
+	go fasthttp.Serve(ln, RegisterHandler(s))
+	return n, ln, func() {
+		ln.Close()
+		closeClient()
+		n.Close()
+	}
+}
+
+// sseEvents reads events of a stream till the expected number of them, it returns ids and event names
+func sseEvents(t *testing.T, ln *fasthttputil.InmemoryListener, path string, header map[string]string, count int, move func()) ([]string, []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, _ := http.NewRequestWithContext(ctx, "GET", "http://test"+path, nil)
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	c := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}}
+	resp, err := c.Do(r)
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Invalid content type: %s", ct)
+	}
+
+	var ids, events []string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for len(events) < count && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			ids = append(ids, line[len("id: "):])
+		case strings.HasPrefix(line, "event: "):
+			events = append(events, line[len("event: "):])
+		case strings.HasPrefix(line, "data: "):
+			if len(events) == count/2 && move != nil {
+				move()
+				move = nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Error(err)
+	}
+	return ids, events
+}
+
+func expectIDs(t *testing.T, got []string, from uint64) {
+	if len(got) == 0 {
+		t.Errorf("No blocks in a stream")
+	}
+	for i, id := range got {
+		if id != fmt.Sprint(from+uint64(i)) {
+			t.Errorf("Invalid block in a stream: %v\nexpected ones from %d", got, from)
+			return
+		}
+	}
+}
+
+func TestBlockStream(t *testing.T) {
+	n, ln, closeAll := newStreamServer(t)
+	defer closeAll()
+
+	head := n.Head()
+	ids, events := sseEvents(t, ln, fmt.Sprintf("/stream/blocks?from=%d&fields=number", head-2), nil, 6, func() {
+		// blocks skipped between polls are streamed too
+		n.SetHead(head + 3)
+	})
+	expectIDs(t, ids, head-2)
+	for _, event := range events {
+		if event != "block" {
+			t.Errorf("Invalid event: %s", event)
+		}
+	}
+
+	ids, _ = sseEvents(t, ln, "/stream/blocks", nil, 1, nil)
+	expectIDs(t, ids, head+3)
+
+	// a reconnecting EventSource sends the last id it has got
+	ids, _ = sseEvents(t, ln, "/stream/blocks", map[string]string{"Last-Event-ID": fmt.Sprint(head + 1)}, 2, nil)
+	expectIDs(t, ids, head+2)
+
+	// a stream doesn't go back further than the backlog
+	ids, _ = sseEvents(t, ln, "/stream/blocks?from=1", nil, 6, nil)
+	expectIDs(t, ids, head-2)
+}
+
+func TestHeadHubFollowsHead(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{Head: client.HeadConfig{Interval: 10 * time.Millisecond}}, Config{})
+	defer closeClient()
+
+	sub, _ := s.heads.subscribe()
+	defer s.heads.unsubscribe(sub)
+	next := func() *model.Block {
+		select {
+		case b := <-sub:
+			return b
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No new head after %d calls", n.Calls("eth_getBlockByNumber"))
+			return nil
+		}
+	}
+	next()
+
+	// the latest block isn't requested while the head the client follows stays
+	calls := n.Calls("eth_getBlockByNumber")
+	time.Sleep(100 * time.Millisecond)
+	if got := n.Calls("eth_getBlockByNumber") - calls; got != 0 {
+		t.Errorf("Invalid number of upstream block calls: %d\nexpected: 0", got)
+	}
+	n.SetHead(n.Head() + 1)
+	if b := next(); b.Number != fmt.Sprintf("0x%x", n.Head()) {
+		t.Errorf("Invalid head: %s\nexpected: 0x%x", b.Number, n.Head())
+	}
+}
+
+func TestBlockStreamInvalid(t *testing.T) {
+	s, closeClient := newServer(t, node, client.Config{}, Config{})
+	defer closeClient()
+
+	expectError(t, s, "/stream/blocks?from=x", fasthttp.StatusBadRequest, codeInvalidQueryParam)
+	expectError(t, s, "/stream/blocks?txs=all", fasthttp.StatusBadRequest, codeInvalidQueryParam)
+	expectError(t, s, "/ws/blocks?from=x", fasthttp.StatusBadRequest, codeInvalidQueryParam)
+	// not a websocket handshake
+	expectError(t, s, "/ws/blocks", fasthttp.StatusBadRequest, codeBadRequest)
+}
+
+func TestBlockSocket(t *testing.T) {
+	n, ln, closeAll := newStreamServer(t)
+	defer closeAll()
+
+	dialer := websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		HandshakeTimeout: time.Second,
+	}
+	head := n.Head()
+	conn, _, err := dialer.Dial(fmt.Sprintf("ws://test/ws/blocks?from=%d&txs=none", head-1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var numbers []string
+	for len(numbers) < 4 {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := new(model.Block)
+		if err := json.Unmarshal(data, b); err != nil {
+			t.Fatal(err)
+		}
+		numbers = append(numbers, b.Number)
+		if len(numbers) == 2 {
+			n.SetHead(head + 2)
+		}
+	}
+	for i, number := range numbers {
+		if expected := fmt.Sprintf("0x%x", head-1+uint64(i)); number != expected {
+			t.Errorf("Invalid blocks of a socket: %v\nexpected ones from 0x%x", numbers, head-1)
+			break
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+func expectBlockNumber(t *testing.T, s *RouterToServe, path string, number uint64) {
+	resp, body := get(t, s, path)
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, fasthttp.StatusOK)
+		return
+	}
+	b := new(model.ShowcaseBlock)
+	if err := json.Unmarshal(body, b); err != nil {
+		t.Error(err)
+		return
+	}
+	if b.Number != fmt.Sprintf("0x%x", number) {
+		t.Errorf("Invalid block number for %s: %s\nexpected: 0x%x", path, b.Number, number)
+	}
+}
+
+func TestBlockTags(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{}, Config{})
+	defer closeClient()
+	head := n.Head()
+
+	// earliest, safe and finalized blocks are cached by their numbers
+	for tag, number := range map[string]uint64{
+		"earliest":  0,
+		"safe":      head - fakenode.SafeDepth,
+		"finalized": head - fakenode.FinalizedDepth,
+	} {
+		calls := n.Calls("eth_getBlockByNumber")
+		expectBlockNumber(t, s, "/block/"+tag, number)
+		expectBlockNumber(t, s, "/block/"+tag, number)
+		expectBlockNumber(t, s, fmt.Sprintf("/block/%d", number), number)
+		if calls := n.Calls("eth_getBlockByNumber") - calls; calls != 1 {
+			t.Errorf("Invalid number of upstream calls for %s: %d\nexpected: 1", tag, calls)
+		}
+	}
+
+	expectBlockNumber(t, s, "/block/pending", head)
+	for _, path := range []string{
+		"/block/safe/txs/0",
+		"/block/finalized/receipts",
+		"/block/earliest/txs/1/receipt",
+	} {
+		resp, _ := get(t, s, path)
+		if resp != nil && resp.StatusCode != fasthttp.StatusOK {
+			t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, fasthttp.StatusOK)
+		}
+	}
+}
+
+func TestFinalizedTagIsCacheable(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	// the finalized block has fewer confirmations than required
+	s, closeClient := newServer(t, n, client.Config{Confirmations: 100}, Config{})
+	defer closeClient()
+
+	final := n.Head() - fakenode.FinalizedDepth
+	expectBlockNumber(t, s, "/block/finalized", final)
+	calls := n.Calls("eth_getBlockByNumber")
+	expectBlockNumber(t, s, fmt.Sprintf("/block/%d", final), final)
+	expectBlockNumber(t, s, fmt.Sprintf("/block/%d", final-1), final-1)
+	expectBlockNumber(t, s, fmt.Sprintf("/block/%d", final-1), final-1)
+	if calls := n.Calls("eth_getBlockByNumber") - calls; calls != 1 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 1", calls)
+	}
+}
+
+func TestBlockTagsInBatch(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+	s, closeClient := newServer(t, n, client.Config{}, Config{})
+	defer closeClient()
+	head := n.Head()
+	get(t, s, "/block/latest")
+	blockCalls := n.Calls("eth_getBlockByNumber")
+
+	// tags of a batch are resolved like single ones and cached by their numbers
+	tags := []string{"earliest", "safe", "finalized", "safe"}
+	numbers := []uint64{0, head - fakenode.SafeDepth, head - fakenode.FinalizedDepth, head - fakenode.SafeDepth}
+	var calls []string
+	for i, tag := range tags {
+		calls = append(calls, fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"eth_getBlockByNumber","params":["%s",false]}`, i, tag))
+	}
+	batch := "[" + strings.Join(calls, ",") + "]"
+	for i := 0; i < 2; i++ {
+		_, body := post(t, s, "/", batch)
+		var answers []*rpcAnswer
+		if err := json.Unmarshal(body, &answers); err != nil {
+			t.Fatal(err)
+		}
+		for j, a := range answers {
+			b := new(model.ShowcaseBlock)
+			if a.Error != nil || json.Unmarshal(a.Result, b) != nil || b.Number != fmt.Sprintf("0x%x", numbers[j]) {
+				t.Errorf("Unexpected answer for %s: %+v", tags[j], a)
+			}
+		}
+	}
+	if calls := n.Calls("eth_getBlockByNumber") - blockCalls; calls != 3 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 3", calls)
+	}
+
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/karlseguin/ccache/v2"
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+)
+
+func TestTimeoutHeader(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1))
+	c, err := client.NewJRClient([]string{n.URL()}, cache, client.Config{LatestWindow: -1})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	n.SetDelay(time.Second)
+	r, _ := http.NewRequest("GET", fmt.Sprintf("http://%s:%s/block/latest", s.host, s.port), nil)
+	r.Header.Set(timeoutHeader, "50ms")
+	start := time.Now()
+	resp, err := serve(RegisterHandler(s), r)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != fasthttp.StatusGatewayTimeout {
+		t.Errorf("Invalid status code: %d\nexpectd: %d", resp.StatusCode, fasthttp.StatusGatewayTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("The request has taken %s in spite of the timeout", elapsed)
+	}
+}
+
+func TestInvalidTimeoutHeader(t *testing.T) {
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1))
+	c, err := client.NewJRClient([]string{node.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	r, _ := http.NewRequest("GET", fmt.Sprintf("http://%s:%s/block/latest", s.host, s.port), nil)
+	r.Header.Set(timeoutHeader, "soon")
+	resp, err := serve(RegisterHandler(s), r)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.StatusCode != fasthttp.StatusBadRequest {
+		t.Errorf("Invalid status code: %d\nexpectd: %d", resp.StatusCode, fasthttp.StatusBadRequest)
+	}
+}
+
+func TestClientDisconnect(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1))
+	c, err := client.NewJRClient([]string{n.URL()}, cache, client.Config{LatestWindow: -1})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	// a socket is watched for a gone client, so it's a real connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	handler := RegisterHandler(s)
+	served := make(chan time.Duration, 1)
+	go fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		handler(ctx)
+		select {
+		case served <- time.Since(start):
+		default:
+		}
+	})
+
+	n.SetDelay(5 * time.Second)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET /block/latest HTTP/1.1\r\nHost: test\r\n\r\n")
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+	select {
+	case elapsed := <-served:
+		if elapsed > time.Second {
+			t.Errorf("The request of a gone client has taken %s", elapsed)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("The upstream request of a gone client hasn't been cancelled")
+	}
+
+	// watching doesn't take a request pipelined while the previous one is served
+	n.SetDelay(300 * time.Millisecond)
+	conn, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /block/latest HTTP/1.1\r\nHost: test\r\n\r\n")
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprintf(conn, "GET /block/100 HTTP/1.1\r\nHost: test\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != fasthttp.StatusOK {
+			t.Errorf("Invalid status code of the pipelined request %d: %d", i, resp.StatusCode)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/valyala/fasthttp"
+	"my.eth.test/fakenode"
 	"my.eth.test/model"
 )
 
@@ -14,7 +15,7 @@ var testCasesTxs = map[string]string{
 	"TestBlockIDStringT":   "/block/ff/txs/1", // ff is not only a string but a hexadecimal number either. double check.
 	"TestTxIDNegative":     "/block/11855219/txs/-1",
 	"TestTxIDString":       "/block/11855219/txs/ff", // ff is not only a string but a hexadecimal number either. double check.
-	"TestTxByHash":         "/block/11855219/txs/0x29489800f624b64b975af75bde520c5a70a21848920b5483d463c25c3b22ac0b",
+	"TestTxByHash":         "/block/11855219/txs/" + fakenode.TxHash(11855219, 0),
 	"TestTxById":           "/block/11855219/txs/1",
 }
 
@@ -62,14 +63,14 @@ func TestBlockIDNegativeT(t *testing.T) {
 			fasthttp.StatusBadRequest,
 		)
 	}
-	if string(body) != fmt.Sprintf(
-		"an identifier: '%d' is invalid",
+	if errorMessage(t, body) != fmt.Sprintf(
+		"an identifier: '%d' is invalid: not a decimal number",
 		-1,
 	) {
 		t.Errorf(
 			"Invalid message: %s\nexpectd: %s",
-			string(body),
-			fmt.Sprintf("an identifier: '%d'  is invalid", -1),
+			errorMessage(t, body),
+			fmt.Sprintf("an identifier: '%d'  is invalid: not a decimal number", -1),
 		)
 	}
 }
@@ -86,15 +87,15 @@ func TestBlockIDStringT(t *testing.T) {
 			fasthttp.StatusBadRequest,
 		)
 	}
-	if string(body) != fmt.Sprintf(
-		"an identifier: '%s' is invalid",
+	if errorMessage(t, body) != fmt.Sprintf(
+		"an identifier: '%s' is invalid: not a decimal number",
 		"ff",
 	) {
 		t.Errorf(
 			"Invalid message: %s\nexpectd: %s",
-			string(body),
+			errorMessage(t, body),
 			fmt.Sprintf(
-				"an identifier: '%s' is invalid",
+				"an identifier: '%s' is invalid: not a decimal number",
 				"ff",
 			),
 		)
@@ -114,14 +115,14 @@ func TestTxIDNegative(t *testing.T) {
 		)
 		return
 	}
-	if string(body) != fmt.Sprintf(
-		"an identifier: '%d' is invalid",
+	if errorMessage(t, body) != fmt.Sprintf(
+		"an identifier: '%d' is invalid: not a decimal number",
 		-1,
 	) {
 		t.Errorf(
 			"Invalid message: %s\nexpectd: %s",
-			string(body),
-			fmt.Sprintf("an identifier: '%d'  is invalid", -1),
+			errorMessage(t, body),
+			fmt.Sprintf("an identifier: '%d'  is invalid: not a decimal number", -1),
 		)
 	}
 }
@@ -139,15 +140,15 @@ func TestTxIDString(t *testing.T) {
 		)
 		return
 	}
-	if string(body) != fmt.Sprintf(
-		"an identifier: '%s' is invalid",
+	if errorMessage(t, body) != fmt.Sprintf(
+		"an identifier: '%s' is invalid: not a decimal number",
 		"ff",
 	) {
 		t.Errorf(
 			"Invalid message: %s\nexpectd: %s",
-			string(body),
+			errorMessage(t, body),
 			fmt.Sprintf(
-				"an identifier: '%s' is invalid",
+				"an identifier: '%s' is invalid: not a decimal number",
 				"ff",
 			),
 		)
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/karlseguin/ccache/v2"
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+	"my.eth.test/store"
+)
+
+func expectTx(t *testing.T, s *RouterToServe, hash string) {
+	resp, body := get(t, s, "/tx/"+hash)
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Errorf("Invalid status code: %d\nexpected: %d", resp.StatusCode, fasthttp.StatusOK)
+		return
+	}
+	tx := new(model.Transaction)
+	if err := json.Unmarshal(body, tx); err != nil {
+		t.Error(err)
+		return
+	}
+	if tx.Hash != hash {
+		t.Errorf("Invalid transaction hash: %s\nexpected: %s", tx.Hash, hash)
+	}
+}
+
+func TestTransactionIndex(t *testing.T) {
+	indexed := fakenode.New(fakenode.DefaultHead)
+	defer indexed.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+	c, err := client.NewJRClient([]string{indexed.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	// transactions of a cached block are indexed
+	get(t, s, "/block/100")
+	expectTx(t, s, fakenode.TxHash(100, 1))
+	if calls := indexed.Calls("eth_getTransactionByHash"); calls != 0 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 0", calls)
+	}
+
+	// the block of a requested transaction is cached, so its other transactions are indexed
+	expectTx(t, s, fakenode.TxHash(200, 1))
+	expectTx(t, s, fakenode.TxHash(200, 0))
+	if calls := indexed.Calls("eth_getTransactionByHash"); calls != 1 {
+		t.Errorf("Invalid number of upstream calls: %d\nexpected: 1", calls)
+	}
+
+	cases := map[string]int{
+		"/tx/0x1234":                       fasthttp.StatusBadRequest,
+		"/tx/0x" + strings.Repeat("0", 64): fasthttp.StatusNotFound,
+	}
+	for path, status := range cases {
+		resp, _ := get(t, s, path)
+		if resp == nil {
+			return
+		}
+		if resp.StatusCode != status {
+			t.Errorf("Invalid status code for %s: %d\nexpected: %d", path, resp.StatusCode, status)
+		}
+	}
+}
+
+func TestTransactionIndexSurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	run := func(path string, hash string) int {
+		st, err := store.Open(dir, store.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer st.Close()
+		cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1 << 20))
+		c, err := client.NewJRClient([]string{node.URL()}, cache, client.Config{Store: st})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		s := NewRouterToServe("test", "", c, Config{})
+
+		calls := node.Calls("eth_getTransactionByHash") + node.Calls("eth_getBlockByNumber")
+		if path != "" {
+			get(t, s, path)
+		}
+		expectTx(t, s, hash)
+		return node.Calls("eth_getTransactionByHash") + node.Calls("eth_getBlockByNumber") - calls
+	}
+
+	if calls := run("/block/300", fakenode.TxHash(300, 1)); calls != 1 {
+		t.Errorf("Invalid number of upstream calls before a restart: %d\nexpected: 1", calls)
+	}
+	if calls := run("", fakenode.TxHash(300, 0)); calls != 0 {
+		t.Errorf("Invalid number of upstream calls after a restart: %d\nexpected: 0", calls)
+	}
+}
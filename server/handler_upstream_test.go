@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/karlseguin/ccache/v2"
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/fakenode"
+	"my.eth.test/model"
+)
+
+func TestFailover(t *testing.T) {
+	down := fakenode.New(fakenode.DefaultHead)
+	defer down.Close()
+	down.SetFailing(true)
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1))
+	c, err := client.NewJRClient([]string{down.URL(), node.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	resp, body := get(t, s, "/block/latest")
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Errorf("Invalid status code: %d\nexpectd: %d", resp.StatusCode, fasthttp.StatusOK)
+		return
+	}
+	b := new(model.ShowcaseBlock)
+	if err := json.Unmarshal(body, b); err != nil {
+		t.Error(err)
+		return
+	}
+	if b.Hash != fakenode.BlockHash(node.Head()) {
+		t.Errorf("Invalid block hash: %s\nexpected: %s", b.Hash, fakenode.BlockHash(node.Head()))
+	}
+}
+
+func TestUpstreamsStatus(t *testing.T) {
+	down := fakenode.New(fakenode.DefaultHead)
+	defer down.Close()
+	down.SetFailing(true)
+	lagging := fakenode.New(fakenode.DefaultHead - 100)
+	defer lagging.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1))
+	c, err := client.NewJRClient([]string{down.URL(), lagging.URL(), node.URL()}, cache, client.Config{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	resp, body := get(t, s, "/upstreams")
+	if resp == nil {
+		return
+	}
+	var statuses []client.UpstreamStatus
+	if err := json.Unmarshal(body, &statuses); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []bool{false, false, true}
+	if len(statuses) != len(expected) {
+		t.Errorf("Invalid number of upstreams: %d\nexpected: %d", len(statuses), len(expected))
+		return
+	}
+	for i, st := range statuses {
+		if st.Healthy != expected[i] {
+			t.Errorf("Invalid health of %s: %t\nexpected: %t", st.URL, st.Healthy, expected[i])
+		}
+	}
+	if statuses[1].Lag != 100 {
+		t.Errorf("Invalid lag of %s: %d\nexpected: %d", statuses[1].URL, statuses[1].Lag, 100)
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1))
+	conf := client.Config{
+		Retry:        client.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		LatestWindow: -1,
+	}
+	c, err := client.NewJRClient([]string{n.URL()}, cache, conf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	n.FailNext(2, fasthttp.StatusTooManyRequests)
+	resp, _ := get(t, s, "/block/latest")
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode != fasthttp.StatusOK {
+		t.Errorf("Invalid status code: %d\nexpectd: %d", resp.StatusCode, fasthttp.StatusOK)
+	}
+}
+
+func TestBreakerOpens(t *testing.T) {
+	n := fakenode.New(fakenode.DefaultHead)
+	defer n.Close()
+
+	cache := ccache.New(ccache.Configure().Buckets(8).ItemsToPrune(1).MaxSize(1))
+	conf := client.Config{
+		Retry:        client.RetryPolicy{MaxAttempts: 1},
+		Breaker:      client.BreakerConfig{Threshold: 2, Cooldown: time.Hour},
+		LatestWindow: -1,
+	}
+	c, err := client.NewJRClient([]string{n.URL()}, cache, conf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	s := NewRouterToServe("test", "", c, Config{})
+
+	n.SetFailing(true)
+	get(t, s, "/block/latest")
+	get(t, s, "/block/latest")
+	requests := n.Requests()
+	resp, _ := get(t, s, "/block/latest")
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode == fasthttp.StatusOK {
+		t.Error("expected error")
+	}
+	if n.Requests() != requests {
+		t.Errorf("The open circuit has let %d requests through", n.Requests()-requests)
+	}
+
+	_, body := get(t, s, "/upstreams")
+	var statuses []client.UpstreamStatus
+	if err := json.Unmarshal(body, &statuses); err != nil {
+		t.Error(err)
+		return
+	}
+	if statuses[0].Breaker != "open" {
+		t.Errorf("Invalid breaker state: %s\nexpected: open", statuses[0].Breaker)
+	}
+}
+
+func get(t *testing.T, s *RouterToServe, path string) (*http.Response, []byte) {
+	r, _ := http.NewRequest(
+		"GET",
+		fmt.Sprintf("http://%s:%s%s", s.host, s.port, path),
+		nil,
+	)
+	res, err := serve(RegisterHandler(s), r)
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+	return res, body
+}
@@ -1,85 +1,229 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"strconv"
 
 	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
 	"my.eth.test/model"
 )
 
-// GET /block/{identifier}
+// GET /block/{identifier}?txs={view}&fields={list}
 func (s *RouterToServe) requestBlock(ctx *fasthttp.RequestCtx) {
-	identifier := ctx.UserValue("identifier").(string)
-	if identifier != "latest" { // separates the 'latest' tag from numeric values
-		if err := validateParam(&identifier); err != nil { // changes identifier format to 0x...
-			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
-			return
-		}
+	id, err := parseIdentifier(ctx.UserValue("identifier").(string))
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	p, err := projectionArgs(ctx.QueryArgs())
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	uctx, cancel, err := s.upstreamContext(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
 	}
-	block, err := s.client.GetBlockBy(identifier)
+	defer cancel()
+	block, err := s.blockOf(uctx, id)
 	if err != nil {
-		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		fail(ctx, err)
+		return
+	}
+	if s.conditional(ctx, uctx, block, id.tag != "", projectionVariant(p)) {
 		return
 	}
-	resp, err := json.Marshal(block.ToShowcase())
+	resp, err := blockView(block, p)
 	if err != nil {
-		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		failInternal(ctx, err)
 		return
 	}
 	ctx.WriteString(string(resp))
 }
 
-func validateParam(identifier *string) error {
-	if num, err := strconv.ParseUint(*identifier, 10, 64); err == nil {
-		*identifier = fmt.Sprintf("0x%x", num)
-	} else {
-		return &model.InvalidIdentifierError{Identifier: *identifier}
+// GET /block/hash/{hash}?txs={view}&fields={list}
+func (s *RouterToServe) requestBlockByHash(ctx *fasthttp.RequestCtx) {
+	hash, err := parseHash(ctx.UserValue("hash").(string))
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	p, err := projectionArgs(ctx.QueryArgs())
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	uctx, cancel, err := s.upstreamContext(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	defer cancel()
+	block, err := s.client.GetBlockByHash(uctx, hash)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	if s.conditional(ctx, uctx, block, false, projectionVariant(p)) {
+		return
 	}
-	return nil
+	resp, err := blockView(block, p)
+	if err != nil {
+		failInternal(ctx, err)
+		return
+	}
+	ctx.WriteString(string(resp))
+}
+
+// GET /tx/{hash}
+func (s *RouterToServe) requestTransaction(ctx *fasthttp.RequestCtx) {
+	hash, err := parseHash(ctx.UserValue("hash").(string))
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	uctx, cancel, err := s.upstreamContext(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	defer cancel()
+	t, err := s.client.FindTransaction(uctx, hash)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	resp, err := json.Marshal(t)
+	if err != nil {
+		failInternal(ctx, err)
+		return
+	}
+	ctx.WriteString(string(resp))
+}
+
+// projectionArgs reads the txs and fields query args. Without them a block is shown
+// with transactions' hashes as before, so nil is returned
+func projectionArgs(args *fasthttp.Args) (*model.Projection, error) {
+	txs, fields := string(args.Peek("txs")), listArg(args, "fields")
+	if txs == "" && len(fields) == 0 {
+		return nil, nil
+	}
+	return model.NewProjection(txs, fields)
+}
+
+// projectionVariant is the variant of a block response a projection chooses, a plain block is the empty one.
+// Unknown query args and the order of fields don't change it
+func projectionVariant(p *model.Projection) string {
+	if p == nil {
+		return ""
+	}
+	return p.String()
+}
+
+// blockView serializes a block as a projection chooses
+func blockView(block *model.Block, p *model.Projection) ([]byte, error) {
+	if p == nil {
+		return json.Marshal(block.ToShowcase())
+	}
+	return block.Project(p)
+}
+
+// blockOf gets a block by a tag, a number or a hash
+func (s *RouterToServe) blockOf(ctx context.Context, id identifier) (*model.Block, error) {
+	if id.hash != "" {
+		return s.client.GetBlockByHash(ctx, id.hash)
+	}
+	return s.client.GetBlockBy(ctx, id.block())
 }
 
 // GET /block/{identifierB}/txs/{identifierT}
 func (s *RouterToServe) requestBlockAndFindTransaction(ctx *fasthttp.RequestCtx) {
-	var numericIDT uint64
-	isHash := true // to separate an identifierT hash value from an identifierT numeric value
-	idB := ctx.UserValue("identifierB").(string)
-	if idB != "latest" { // separates the 'latest' tag from numeric values
-		if err := validateParam(&idB); err != nil {
-			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
-			return
-		}
-	}
-	idT := ctx.UserValue("identifierT").(string)
-	if len(idT) < 2 || idT[:2] != "0x" { // separates hash values from numeric values
-		num, err := strconv.ParseUint(idT, 10, 64)
-		if err != nil {
-			ctx.Error(
-				(&model.InvalidIdentifierError{Identifier: idT}).Error(),
-				fasthttp.StatusBadRequest,
-			)
-			return
-		}
-		isHash = false
-		numericIDT = num
-	}
-	block, err := s.client.GetBlockBy(idB)
-	if err != nil {
-		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	idB, err := parseIdentifier(ctx.UserValue("identifierB").(string))
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	idT, err := parseTxIdentifier(ctx.UserValue("identifierT").(string))
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	uctx, cancel, err := s.upstreamContext(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	defer cancel()
+	block, err := s.blockOf(uctx, idB)
+	if err != nil {
+		fail(ctx, err)
 		return
 	}
 
 	var t *model.Transaction
-	if isHash {
-		t, err = s.client.GetTransactionByHash(block, idT)
+	if idT.hash != "" {
+		t, err = s.client.GetTransactionByHash(uctx, block, idT.hash)
 	} else {
-		t, err = s.client.GetTransactionByIndex(block, numericIDT)
+		t, err = s.client.GetTransactionByIndex(uctx, block, idT.number)
+	}
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	if s.conditional(ctx, uctx, block, idB.tag != "", "tx:"+t.Hash) {
+		return
 	}
 
 	resp, err := json.Marshal(t)
 	if err != nil {
-		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		failInternal(ctx, err)
+		return
+	}
+	ctx.WriteString(string(resp))
+}
+
+// GET /upstreams
+func (s *RouterToServe) requestUpstreams(ctx *fasthttp.RequestCtx) {
+	reporter, ok := s.client.(client.UpstreamReporter)
+	if !ok {
+		fail(ctx, &unsupportedError{what: "use upstream nodes"})
+		return
+	}
+	resp, err := json.Marshal(reporter.Upstreams())
+	if err != nil {
+		failInternal(ctx, err)
+		return
+	}
+	ctx.WriteString(string(resp))
+}
+
+// GET /head
+func (s *RouterToServe) requestHead(ctx *fasthttp.RequestCtx) {
+	reporter, ok := s.client.(client.HeadReporter)
+	if !ok {
+		fail(ctx, &unsupportedError{what: "follow the head"})
+		return
+	}
+	resp, err := json.Marshal(reporter.Head())
+	if err != nil {
+		failInternal(ctx, err)
+		return
+	}
+	ctx.WriteString(string(resp))
+}
+
+// GET /stats
+func (s *RouterToServe) requestStats(ctx *fasthttp.RequestCtx) {
+	reporter, ok := s.client.(client.StatsReporter)
+	if !ok {
+		fail(ctx, &unsupportedError{what: "count its work"})
+		return
+	}
+	resp, err := json.Marshal(reporter.Stats())
+	if err != nil {
+		failInternal(ctx, err)
 		return
 	}
 	ctx.WriteString(string(resp))
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"my.eth.test/client"
+	"my.eth.test/model"
+)
+
+// headBuffer is how many new heads wait for a slow subscriber before it's dropped
+const headBuffer = 64
+
+// headRetry is how soon a failed poll is repeated, a source that doesn't follow the head is polled that often too
+const headRetry = time.Second
+
+// headHub gets the latest block once for every stream when the head the source follows moves,
+// and fans new heads out to subscribers. It works only while somebody listens
+type headHub struct {
+	source  client.BlockSource
+	timeout time.Duration
+
+	lock sync.Mutex
+	subs map[chan *model.Block]struct{}
+	head *model.Block
+	stop chan struct{}
+}
+
+func newHeadHub(source client.BlockSource, timeout time.Duration) *headHub {
+	return &headHub{
+		source:  source,
+		timeout: timeout,
+		subs:    make(map[chan *model.Block]struct{}),
+	}
+}
+
+// subscribe returns a channel of new heads and the latest known one, it's nil till the first poll.
+// The channel is closed when the subscriber falls behind, so it can resume from the last block it has got
+func (h *headHub) subscribe() (chan *model.Block, *model.Block) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	sub := make(chan *model.Block, headBuffer)
+	h.subs[sub] = struct{}{}
+	if h.stop == nil {
+		h.stop = make(chan struct{})
+		go h.run(h.stop)
+	}
+	return sub, h.head
+}
+
+// unsubscribe stops sending heads to a channel, the loop stops with the last subscriber
+func (h *headHub) unsubscribe(sub chan *model.Block) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		h.drop(sub)
+	}
+}
+
+// drop closes a channel of a subscriber, the hub must be locked
+func (h *headHub) drop(sub chan *model.Block) {
+	delete(h.subs, sub)
+	close(sub)
+	if len(h.subs) == 0 {
+		close(h.stop)
+		h.stop = nil
+		// nobody polls, so the head gets stale
+		h.head = nil
+	}
+}
+
+func (h *headHub) run(stop chan struct{}) {
+	reporter, follows := h.source.(client.HeadReporter)
+	for {
+		// the channel is taken before the poll, so a move during the poll isn't missed
+		var changed <-chan struct{}
+		if follows {
+			changed = reporter.HeadChanged()
+		}
+		var retry <-chan time.Time
+		if !h.poll(stop, reporter) || !follows {
+			retry = time.After(headRetry)
+		}
+		select {
+		case <-changed:
+		case <-retry:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// poll gets the latest block, false means it has failed or the block is behind the head the source follows,
+// e.g. it's shared by recent requests. When the source follows the head, the block is requested only after the head has moved
+func (h *headHub) poll(stop chan struct{}, reporter client.HeadReporter) bool {
+	if reporter != nil && !h.moved(reporter.Head()) {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	b, err := h.source.GetBlockBy(ctx, "latest")
+	if err != nil {
+		log.Printf("an error (%s) occured while polling the latest block for streams", err)
+		return false
+	}
+	h.publish(stop, b)
+	return reporter == nil || !h.moved(reporter.Head())
+}
+
+// moved says whether the head a source reports differs from the one sent to subscribers
+func (h *headHub) moved(status client.HeadStatus) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.head == nil {
+		return true
+	}
+	number, err := parseBlockNumber(h.head.Number)
+	return err != nil || status.Number != number || status.Hash != "" && status.Hash != h.head.Hash
+}
+
+// publish sends a block to subscribers when it's a new head: a new number or a new hash after a reorg.
+// A block polled by a stopped loop is dropped
+func (h *headHub) publish(stop chan struct{}, b *model.Block) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.stop != stop || h.head != nil && h.head.Hash == b.Hash {
+		return
+	}
+	h.head = b
+	for sub := range h.subs {
+		select {
+		case sub <- b:
+		default:
+			h.drop(sub)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/model"
+	"my.eth.test/webhook"
+)
+
+// hooks returns the webhook manager, it's unsupported without one
+func (s *RouterToServe) hooks(ctx *fasthttp.RequestCtx) (*webhook.Manager, bool) {
+	if s.conf.Hooks == nil {
+		fail(ctx, &unsupportedError{what: "deliver webhooks"})
+		return nil, false
+	}
+	return s.conf.Hooks, true
+}
+
+// writeJSON writes a value with a status
+func writeJSON(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		failInternal(ctx, err)
+		return
+	}
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.Write(resp)
+}
+
+// POST /hooks with {"url": ..., "secret": ..., "filter": {...}}
+func (s *RouterToServe) requestRegisterHook(ctx *fasthttp.RequestCtx) {
+	m, ok := s.hooks(ctx)
+	if !ok {
+		return
+	}
+	hook := new(webhook.Hook)
+	if err := json.Unmarshal(ctx.PostBody(), hook); err != nil {
+		failRequest(ctx, &model.InvalidHookError{Reason: err.Error()})
+		return
+	}
+	registered, err := m.Register(*hook)
+	if err != nil {
+		failHook(ctx, err)
+		return
+	}
+	writeJSON(ctx, fasthttp.StatusCreated, registered)
+}
+
+// GET /hooks
+func (s *RouterToServe) requestHooks(ctx *fasthttp.RequestCtx) {
+	if m, ok := s.hooks(ctx); ok {
+		writeJSON(ctx, fasthttp.StatusOK, m.Hooks())
+	}
+}
+
+// GET /hooks/{id}
+func (s *RouterToServe) requestHook(ctx *fasthttp.RequestCtx) {
+	m, ok := s.hooks(ctx)
+	if !ok {
+		return
+	}
+	hook, err := m.Hook(ctx.UserValue("id").(string))
+	if err != nil {
+		failHook(ctx, err)
+		return
+	}
+	writeJSON(ctx, fasthttp.StatusOK, hook)
+}
+
+// DELETE /hooks/{id}
+func (s *RouterToServe) requestRemoveHook(ctx *fasthttp.RequestCtx) {
+	m, ok := s.hooks(ctx)
+	if !ok {
+		return
+	}
+	if err := m.Remove(ctx.UserValue("id").(string)); err != nil {
+		failHook(ctx, err)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// GET /hooks/{id}/deliveries?status={status}&limit={number}
+func (s *RouterToServe) requestDeliveries(ctx *fasthttp.RequestCtx) {
+	m, ok := s.hooks(ctx)
+	if !ok {
+		return
+	}
+	status := string(ctx.QueryArgs().Peek("status"))
+	switch status {
+	case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusFailed:
+	default:
+		failRequest(ctx, &model.InvalidQueryParamError{Param: "status", Value: status})
+		return
+	}
+	var all uint64
+	limit, err := uintArg(ctx.QueryArgs(), "limit", &all)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	deliveries, err := m.Deliveries(ctx.UserValue("id").(string), status, int(limit))
+	if err != nil {
+		failHook(ctx, err)
+		return
+	}
+	writeJSON(ctx, fasthttp.StatusOK, deliveries)
+}
+
+// POST /hooks/{id}/deliveries/{delivery}/replay
+func (s *RouterToServe) requestReplay(ctx *fasthttp.RequestCtx) {
+	m, ok := s.hooks(ctx)
+	if !ok {
+		return
+	}
+	d, err := m.Replay(ctx.UserValue("id").(string), ctx.UserValue("delivery").(string))
+	if err != nil {
+		failHook(ctx, err)
+		return
+	}
+	writeJSON(ctx, fasthttp.StatusCreated, d)
+}
+
+// failHook writes an error of the webhook manager, the ones without a known type are failures of its store
+func failHook(ctx *fasthttp.RequestCtx, err error) {
+	if status, _ := errorStatus(err); status == fasthttp.StatusBadGateway {
+		failInternal(ctx, err)
+		return
+	}
+	fail(ctx, err)
+}
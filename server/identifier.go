@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"my.eth.test/model"
+)
+
+// hashDigits is the length of a 32 bytes hash in hex digits
+const hashDigits = 64
+
+// identifier is a parsed path segment of a block or a transaction: a tag, a number or a hash
+type identifier struct {
+	tag    string
+	number uint64
+	hash   string
+}
+
+// parseIdentifier accepts a tag, a decimal number, a 0x-hex quantity and a 32 bytes hash.
+// A 0x value of 64 hex digits is a hash, a shorter one is a quantity
+func parseIdentifier(raw string) (identifier, error) {
+	if isTag(raw) {
+		return identifier{tag: raw}, nil
+	}
+	invalid := func(reason string) (identifier, error) {
+		return identifier{}, &model.InvalidIdentifierError{Identifier: raw, Reason: reason}
+	}
+	if raw == "" {
+		return invalid("it's empty")
+	}
+	if len(raw) < 2 || raw[:2] != "0x" {
+		number, err := strconv.ParseUint(raw, 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return invalid("the number doesn't fit 64 bits")
+		}
+		if err != nil {
+			return invalid("not a decimal number")
+		}
+		return identifier{number: number}, nil
+	}
+	digits := raw[2:]
+	switch {
+	case len(digits) == 0:
+		return invalid("0x has no hex digits")
+	case len(digits) == hashDigits:
+		if _, err := hex.DecodeString(digits); err != nil {
+			return invalid("a hash has a non-hex digit")
+		}
+		return identifier{hash: raw}, nil
+	case len(digits) > 16:
+		return invalid(fmt.Sprintf("a hex quantity is 16 digits at most and a hash is %d digits", hashDigits))
+	}
+	number, err := strconv.ParseUint(digits, 16, 64)
+	if err != nil {
+		return invalid("a hex quantity has a non-hex digit")
+	}
+	return identifier{number: number}, nil
+}
+
+// parseHash accepts a 32 bytes hash only
+func parseHash(raw string) (string, error) {
+	id, err := parseIdentifier(raw)
+	if err != nil {
+		return "", err
+	}
+	if id.hash == "" {
+		return "", &model.InvalidIdentifierError{Identifier: raw, Reason: fmt.Sprintf("a hash is 0x and %d hex digits", hashDigits)}
+	}
+	return id.hash, nil
+}
+
+// parseBlockNumber accepts a decimal number and a 0x-hex quantity only
+func parseBlockNumber(raw string) (uint64, error) {
+	id, err := parseIdentifier(raw)
+	if err != nil {
+		return 0, err
+	}
+	if id.tag != "" || id.hash != "" {
+		return 0, &model.InvalidIdentifierError{Identifier: raw, Reason: "a number is expected"}
+	}
+	return id.number, nil
+}
+
+// parseTxIdentifier accepts a transaction index and a transaction hash, a tag isn't a transaction
+func parseTxIdentifier(raw string) (identifier, error) {
+	id, err := parseIdentifier(raw)
+	if err == nil && id.tag != "" {
+		err = &model.InvalidIdentifierError{Identifier: raw, Reason: "a transaction is an index or a hash, not a tag"}
+	}
+	return id, err
+}
+
+// block is a tag or a number identifier in the format of GetBlockBy
+func (id identifier) block() string {
+	if id.tag != "" {
+		return id.tag
+	}
+	return fmt.Sprintf("0x%x", id.number)
+}
+
+// isTag says whether an identifier is a block tag
+func isTag(identifier string) bool {
+	switch identifier {
+	case "latest", "earliest", "pending", "safe", "finalized":
+		return true
+	}
+	return false
+}
+
+// isHash checks the format of a 32 bytes hash: 0x and 64 hex digits
+func isHash(hash string) bool {
+	if len(hash) != 2+hashDigits || hash[:2] != "0x" {
+		return false
+	}
+	_, err := hex.DecodeString(hash[2:])
+	return err == nil
+}
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/model"
+)
+
+// json-rpc 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+// rpcRequest is the dto of an incoming json-rpc request
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcResult is the dto of a successful answer, a null result is still a result
+type rpcResult struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+// rpcFailure is the dto of a failed answer
+type rpcFailure struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *model.EthError `json:"error"`
+}
+
+// rpcError is an error with a json-rpc code
+type rpcError struct {
+	Code    int64
+	Message string
+}
+
+func (err *rpcError) Error() string {
+	return err.Message
+}
+
+// rpcScope is what json-rpc methods are served with. Calls of a batch share the blocks received in advance
+type rpcScope struct {
+	s      *RouterToServe
+	ctx    context.Context
+	blocks map[string]prefetched
+}
+
+type prefetched struct {
+	block *model.Block
+	err   error
+}
+
+type rpcMethod func(r *rpcScope, params []json.RawMessage) (interface{}, error)
+
+// rpcMethods are served from the cache, every other method is forwarded upstream
+var rpcMethods = map[string]rpcMethod{
+	"eth_blockNumber":                         rpcBlockNumber,
+	"eth_getBlockByNumber":                    rpcBlockByNumber,
+	"eth_getBlockByHash":                      rpcBlockByHash,
+	"eth_getTransactionByBlockNumberAndIndex": rpcTxByNumberAndIndex,
+	"eth_getTransactionByBlockHashAndIndex":   rpcTxByHashAndIndex,
+	"eth_getBlockTransactionCountByNumber":    rpcTxCountByNumber,
+	"eth_getBlockTransactionCountByHash":      rpcTxCountByHash,
+	"eth_getTransactionByHash":                rpcTxByHash,
+	"eth_getLogs":                             rpcLogs,
+}
+
+// POST /
+func (s *RouterToServe) requestRPC(ctx *fasthttp.RequestCtx) {
+	uctx, cancel, err := s.upstreamContext(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	defer cancel()
+
+	var resp interface{}
+	body := bytes.TrimSpace(ctx.PostBody())
+	if len(body) > 0 && body[0] == '[' {
+		resp = s.dispatchBatch(uctx, body)
+	} else {
+		req := new(rpcRequest)
+		if err := json.Unmarshal(body, req); err != nil {
+			resp = failure(nil, &rpcError{Code: rpcParseError, Message: "parse error: " + err.Error()})
+		} else {
+			resp = s.dispatch(&rpcScope{s: s, ctx: uctx}, req)
+		}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		failInternal(ctx, err)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Write(data)
+}
+
+// dispatch serves a single json-rpc request and returns its answer
+func (s *RouterToServe) dispatch(r *rpcScope, req *rpcRequest) interface{} {
+	if !req.valid() {
+		return failure(req.ID, &rpcError{Code: rpcInvalidRequest, Message: "invalid request"})
+	}
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		forwarder, ok := s.client.(client.Forwarder)
+		if !ok {
+			return failure(req.ID, &rpcError{
+				Code:    rpcMethodNotFound,
+				Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method),
+			})
+		}
+		result, err := forwarder.Forward(r.ctx, req.Method, req.Params)
+		if err != nil {
+			return failure(req.ID, err)
+		}
+		return &rpcResult{JSONRPC: "2.0", ID: req.ID, Result: result}
+	}
+
+	params, err := req.params()
+	if err != nil {
+		return failure(req.ID, err)
+	}
+	result, err := method(r, params)
+	if err != nil {
+		return failure(req.ID, err)
+	}
+	return &rpcResult{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+func (req *rpcRequest) valid() bool {
+	return req.JSONRPC == "2.0" && req.Method != ""
+}
+
+// params returns positional params, named ones aren't supported
+func (req *rpcRequest) params() ([]json.RawMessage, error) {
+	var params []json.RawMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "params must be an array"}
+		}
+	}
+	return params, nil
+}
+
+// failure converts an error to a json-rpc error. Errors of ether nodes keep their codes
+func failure(id json.RawMessage, err error) *rpcFailure {
+	ethErr := &model.EthError{Code: rpcServerError, Message: err.Error()}
+	var rpcErr *rpcError
+	var contentErr *model.ResponseContentError
+	if errors.As(err, &rpcErr) {
+		ethErr.Code = rpcErr.Code
+	} else if errors.As(err, &contentErr) && contentErr.Code != 0 {
+		ethErr.Code, ethErr.Message = contentErr.Code, contentErr.Message
+	}
+	return &rpcFailure{JSONRPC: "2.0", ID: id, Error: ethErr}
+}
+
+// rpcBlockNumber answers with the followed head, it's forwarded till the head is known
+func rpcBlockNumber(r *rpcScope, params []json.RawMessage) (interface{}, error) {
+	if reporter, ok := r.s.client.(client.HeadReporter); ok {
+		if head := reporter.Head(); head.Number > 0 {
+			return fmt.Sprintf("0x%x", head.Number), nil
+		}
+	}
+	if forwarder, ok := r.s.client.(client.Forwarder); ok {
+		return forwarder.Forward(r.ctx, "eth_blockNumber", nil)
+	}
+	block, err := r.blockBy("latest")
+	if err != nil {
+		return nil, err
+	}
+	return block.Number, nil
+}
+
+func rpcBlockByNumber(r *rpcScope, params []json.RawMessage) (interface{}, error) {
+	block, err := r.block(params, 0)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	full, err := boolParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	return rpcBlockView(block, full), nil
+}
+
+func rpcBlockByHash(r *rpcScope, params []json.RawMessage) (interface{}, error) {
+	block, err := r.blockByHash(params, 0)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	full, err := boolParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	return rpcBlockView(block, full), nil
+}
+
+func rpcTxByNumberAndIndex(r *rpcScope, params []json.RawMessage) (interface{}, error) {
+	block, err := r.block(params, 0)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	return txByIndex(block, params, 1)
+}
+
+func rpcTxByHashAndIndex(r *rpcScope, params []json.RawMessage) (interface{}, error) {
+	block, err := r.blockByHash(params, 0)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	return txByIndex(block, params, 1)
+}
+
+func rpcTxCountByNumber(r *rpcScope, params []json.RawMessage) (interface{}, error) {
+	block, err := r.block(params, 0)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("0x%x", len(block.Transactions)), nil
+}
+
+func rpcTxCountByHash(r *rpcScope, params []json.RawMessage) (interface{}, error) {
+	block, err := r.blockByHash(params, 0)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("0x%x", len(block.Transactions)), nil
+}
+
+func rpcTxByHash(r *rpcScope, params []json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := param(params, 0, &hash); err != nil {
+		return nil, err
+	}
+	if !isHash(hash) {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid argument 0: hash must be 32 hex bytes"}
+	}
+	t, err := r.s.client.FindTransaction(r.ctx, hash)
+	var notFound *model.NotFoundTransactionError
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	return t, err
+}
+
+// block gets a block by a tag or a number param. An unknown block is nil without an error
+func (r *rpcScope) block(params []json.RawMessage, i int) (*model.Block, error) {
+	identifier, err := blockParam(params, i)
+	if err != nil {
+		return nil, err
+	}
+	return found(r.blockBy(identifier))
+}
+
+// blockBy takes a block received in advance or requests it
+func (r *rpcScope) blockBy(identifier string) (*model.Block, error) {
+	if p, ok := r.blocks[identifier]; ok {
+		return p.block, p.err
+	}
+	return r.s.client.GetBlockBy(r.ctx, identifier)
+}
+
+// blockParam returns a tag or a number param in the format of GetBlockBy
+func blockParam(params []json.RawMessage, i int) (string, error) {
+	var tag string
+	if err := param(params, i, &tag); err != nil {
+		return "", err
+	}
+	if isTag(tag) {
+		return tag, nil
+	}
+	number, err := quantity(tag, i)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("0x%x", number), nil
+}
+
+// blockByHash gets a block by a hash param. An unknown block is nil without an error
+func (r *rpcScope) blockByHash(params []json.RawMessage, i int) (*model.Block, error) {
+	var hash string
+	if err := param(params, i, &hash); err != nil {
+		return nil, err
+	}
+	if !isHash(hash) {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid argument %d: hash must be 32 hex bytes", i)}
+	}
+	return found(r.s.client.GetBlockByHash(r.ctx, hash))
+}
+
+// found turns NotFoundBlockError into a null result as ether nodes do
+func found(block *model.Block, err error) (*model.Block, error) {
+	var notFound *model.NotFoundBlockError
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	return block, err
+}
+
+func txByIndex(block *model.Block, params []json.RawMessage, i int) (interface{}, error) {
+	var raw string
+	if err := param(params, i, &raw); err != nil {
+		return nil, err
+	}
+	index, err := quantity(raw, i)
+	if err != nil {
+		return nil, err
+	}
+	if index >= uint64(len(block.Transactions)) {
+		return nil, nil
+	}
+	return block.Transactions[index], nil
+}
+
+// rpcBlockView is a block with full transactions or with their hashes only
+func rpcBlockView(block *model.Block, full bool) interface{} {
+	if full {
+		return block
+	}
+	return &struct {
+		model.NoTransactionBlock
+		Transactions []string `json:"transactions"`
+	}{block.NoTransactionBlock, block.ToShowcase().Transactions}
+}
+
+func param(params []json.RawMessage, i int, v interface{}) error {
+	if len(params) <= i {
+		return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("missing value for required argument %d", i)}
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid argument %d: %s", i, err.Error())}
+	}
+	return nil
+}
+
+func boolParam(params []json.RawMessage, i int) (bool, error) {
+	var b bool
+	if len(params) <= i {
+		return false, nil
+	}
+	return b, param(params, i, &b)
+}
+
+// quantity parses a hex number in the json-rpc format
+func quantity(raw string, i int) (uint64, error) {
+	if len(raw) < 3 || raw[:2] != "0x" {
+		return 0, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid argument %d: hex string without 0x prefix", i)}
+	}
+	number, err := strconv.ParseUint(raw[2:], 16, 64)
+	if err != nil {
+		return 0, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid argument %d: invalid hex number", i)}
+	}
+	return number, nil
+}
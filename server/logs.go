@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/model"
+)
+
+// GET /logs?from={number}&to={number}&blockHash={hash}&address={list}&topic0..topic3={list}
+// Lists are comma separated, 'to' is the latest block when it's omitted
+func (s *RouterToServe) requestLogs(ctx *fasthttp.RequestCtx) {
+	source, ok := s.client.(client.LogSource)
+	if !ok {
+		fail(ctx, &unsupportedError{what: "serve logs"})
+		return
+	}
+	filter, err := logFilterArgs(ctx.QueryArgs())
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	uctx, cancel, err := s.upstreamContext(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	defer cancel()
+	logs, err := source.GetLogs(uctx, filter)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	if logs == nil {
+		logs = []*model.Log{}
+	}
+	resp, err := json.Marshal(logs)
+	if err != nil {
+		failInternal(ctx, err)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Write(resp)
+}
+
+// logFilterArgs builds a filter from query args, a block hash excludes a range
+func logFilterArgs(args *fasthttp.Args) (*model.LogFilter, error) {
+	filter := new(model.LogFilter)
+	if hash := string(args.Peek("blockHash")); hash != "" {
+		if !isHash(hash) || args.Has("from") || args.Has("to") {
+			return nil, &model.InvalidQueryParamError{Param: "blockHash", Value: hash}
+		}
+		filter.BlockHash = hash
+	} else {
+		from, err := uintArg(args, "from", nil)
+		if err != nil {
+			return nil, err
+		}
+		filter.FromBlock, filter.ToBlock = fmt.Sprintf("0x%x", from), "latest"
+		if args.Has("to") {
+			to, err := uintArg(args, "to", nil)
+			if err != nil {
+				return nil, err
+			}
+			if from > to {
+				return nil, fmt.Errorf("the range is empty: from %d is above to %d", from, to)
+			}
+			filter.ToBlock = fmt.Sprintf("0x%x", to)
+		}
+	}
+	filter.Address = listArg(args, "address")
+	for i := 3; i >= 0; i-- {
+		position := listArg(args, fmt.Sprintf("topic%d", i))
+		if len(position) > 0 && filter.Topics == nil {
+			filter.Topics = make([][]string, i+1)
+		}
+		if filter.Topics != nil {
+			filter.Topics[i] = position
+		}
+	}
+	return filter, nil
+}
+
+func listArg(args *fasthttp.Args, name string) []string {
+	raw := string(args.Peek(name))
+	if raw == "" {
+		return nil
+	}
+	return strings.Split(raw, ",")
+}
+
+func rpcLogs(r *rpcScope, params []json.RawMessage) (interface{}, error) {
+	filter := new(model.LogFilter)
+	if err := param(params, 0, filter); err != nil {
+		return nil, err
+	}
+	if filter.BlockHash != "" && (filter.FromBlock != "" || filter.ToBlock != "") {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid argument 0: cannot specify both BlockHash and FromBlock/ToBlock"}
+	}
+	source, ok := r.s.client.(client.LogSource)
+	if !ok {
+		forwarder, ok := r.s.client.(client.Forwarder)
+		if !ok {
+			return nil, &rpcError{Code: rpcMethodNotFound, Message: "the method eth_getLogs does not exist/is not available"}
+		}
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		return forwarder.Forward(r.ctx, "eth_getLogs", raw)
+	}
+	logs, err := source.GetLogs(r.ctx, filter)
+	if logs == nil && err == nil {
+		logs = []*model.Log{}
+	}
+	return logs, err
+}
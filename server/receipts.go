@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/client"
+	"my.eth.test/model"
+)
+
+// GET /block/{identifierB}/receipts
+func (s *RouterToServe) requestBlockReceipts(ctx *fasthttp.RequestCtx) {
+	source, ok := s.client.(client.ReceiptSource)
+	if !ok {
+		fail(ctx, &unsupportedError{what: "serve receipts"})
+		return
+	}
+	idB, err := parseIdentifier(ctx.UserValue("identifierB").(string))
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	uctx, cancel, err := s.upstreamContext(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	defer cancel()
+	// receipts are taken by the number of the block, so a tag doesn't move between them
+	block, err := s.blockOf(uctx, idB)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	receipts, err := source.GetBlockReceipts(uctx, block.Number)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	// a block of the hash may have fallen off the chain, receipts of its number are of the canonical one then
+	for _, r := range receipts {
+		if idB.hash != "" && r.BlockHash != block.Hash {
+			fail(ctx, &model.NotFoundBlockError{Identifier: idB.hash})
+			return
+		}
+	}
+	if s.conditional(ctx, uctx, block, idB.tag != "", "receipts") {
+		return
+	}
+	resp, err := json.Marshal(receipts)
+	if err != nil {
+		failInternal(ctx, err)
+		return
+	}
+	ctx.WriteString(string(resp))
+}
+
+// GET /block/{identifierB}/txs/{identifierT}/receipt
+func (s *RouterToServe) requestTransactionReceipt(ctx *fasthttp.RequestCtx) {
+	source, ok := s.client.(client.ReceiptSource)
+	if !ok {
+		fail(ctx, &unsupportedError{what: "serve receipts"})
+		return
+	}
+	idB, err := parseIdentifier(ctx.UserValue("identifierB").(string))
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	idT, err := parseTxIdentifier(ctx.UserValue("identifierT").(string))
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	uctx, cancel, err := s.upstreamContext(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	defer cancel()
+	block, err := s.blockOf(uctx, idB)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	var t *model.Transaction
+	if idT.hash != "" {
+		t, err = s.client.GetTransactionByHash(uctx, block, idT.hash)
+	} else {
+		t, err = s.client.GetTransactionByIndex(uctx, block, idT.number)
+	}
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	receipt, err := source.GetTransactionReceipt(uctx, block, t)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	if s.conditional(ctx, uctx, block, idB.tag != "", "receipt:"+t.Hash) {
+		return
+	}
+	resp, err := json.Marshal(receipt)
+	if err != nil {
+		failInternal(ctx, err)
+		return
+	}
+	ctx.WriteString(string(resp))
+}
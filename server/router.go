@@ -2,26 +2,68 @@ package server
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/fasthttp/router"
+	"github.com/karlseguin/ccache/v2"
 	"github.com/valyala/fasthttp"
 
 	"my.eth.test/client"
+	"my.eth.test/webhook"
 )
 
+// Config is the set of service settings
+type Config struct {
+	Timeout          time.Duration // the longest time to serve a request, clients can shorten it with a header
+	RangeConcurrency int           // how many chunks of a block range are requested at once
+	MaxAge           time.Duration // how long caches keep a response of a block that may still change
+	// CompressMinSize is the smallest response body to compress, 1KiB by default. A negative value disables compression
+	CompressMinSize int
+	// StreamBacklog is how many blocks behind the head a stream can resume from, 128 by default
+	StreamBacklog uint64
+	// Hooks delivers new blocks and matching transactions to registered URLs, nil disables /hooks
+	Hooks *webhook.Manager
+	// Variants keeps compressed responses of final blocks, so they aren't compressed again. It's usually the block cache
+	Variants *ccache.Cache
+}
+
+func (c Config) withDefaults() Config {
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Second
+	}
+	if c.RangeConcurrency <= 0 {
+		c.RangeConcurrency = 4
+	}
+	if c.MaxAge <= 0 {
+		c.MaxAge = 2 * time.Second
+	}
+	if c.StreamBacklog == 0 {
+		c.StreamBacklog = 128
+	}
+	if c.CompressMinSize == 0 {
+		c.CompressMinSize = 1 << 10
+	}
+	return c
+}
+
 // RouterToServe is the service object
 type RouterToServe struct {
 	host   string
 	port   string
-	client *client.JRClient
+	client client.BlockSource
+	conf   Config
+	heads  *headHub
 }
 
 // NewRouterToServe is the constructor of the RoterToServe obj
-func NewRouterToServe(hostname string, port string, c *client.JRClient) *RouterToServe {
+func NewRouterToServe(hostname string, port string, c client.BlockSource, conf Config) *RouterToServe {
+	conf = conf.withDefaults()
 	return &RouterToServe{
 		hostname,
 		port,
 		c,
+		conf,
+		newHeadHub(c, conf.Timeout),
 	}
 }
 
@@ -37,5 +79,25 @@ func RegisterHandler(s *RouterToServe) func(*fasthttp.RequestCtx) {
 	r := router.New()
 	r.GET("/block/{identifier}", s.requestBlock)
 	r.GET("/block/{identifierB}/txs/{identifierT}", s.requestBlockAndFindTransaction)
-	return r.Handler
+	r.GET("/block/{identifierB}/txs/{identifierT}/receipt", s.requestTransactionReceipt)
+	r.GET("/block/{identifierB}/receipts", s.requestBlockReceipts)
+	r.GET("/block/hash/{hash}", s.requestBlockByHash)
+	r.GET("/blocks", s.requestBlocks)
+	r.GET("/tx/{hash}", s.requestTransaction)
+	r.GET("/logs", s.requestLogs)
+	r.GET("/stream/blocks", s.requestBlockStream)
+	r.GET("/ws/blocks", s.requestBlockSocket)
+	r.GET("/upstreams", s.requestUpstreams)
+	r.GET("/head", s.requestHead)
+	r.GET("/stats", s.requestStats)
+	r.POST("/hooks", s.requestRegisterHook)
+	r.GET("/hooks", s.requestHooks)
+	r.GET("/hooks/{id}", s.requestHook)
+	r.DELETE("/hooks/{id}", s.requestRemoveHook)
+	r.GET("/hooks/{id}/deliveries", s.requestDeliveries)
+	r.POST("/hooks/{id}/deliveries/{delivery}/replay", s.requestReplay)
+	r.GET("/graphql", s.requestGraphQL)
+	r.POST("/graphql", s.requestGraphQL)
+	r.POST("/", s.requestRPC)
+	return s.compress(r.Handler)
 }
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/valyala/fasthttp"
+	"my.eth.test/model"
+)
+
+// streamHeartbeat is how often an idle stream is pinged, so a gone client is noticed
+const streamHeartbeat = 15 * time.Second
+
+// lastEventIDHeader is sent by an EventSource that reconnects, it's the number of the last block it has got
+const lastEventIDHeader = "Last-Event-ID"
+
+// upgrader of /ws/blocks. Blocks are public, so pages of any origin may listen
+var upgrader = websocket.FastHTTPUpgrader{
+	CheckOrigin: func(*fasthttp.RequestCtx) bool { return true },
+	Error: func(ctx *fasthttp.RequestCtx, status int, reason error) {
+		writeError(ctx, status, codeBadRequest, reason)
+	},
+}
+
+// GET /stream/blocks?from={number}&txs={view}&fields={list}
+func (s *RouterToServe) requestBlockStream(ctx *fasthttp.RequestCtx) {
+	from, p, err := streamArgs(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-cache")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		send := func(b *model.Block) error {
+			data, err := blockView(b, p)
+			if err != nil {
+				return err
+			}
+			number, _ := parseBlockNumber(b.Number)
+			fmt.Fprintf(w, "id: %d\nevent: block\ndata: %s\n\n", number, data)
+			return w.Flush()
+		}
+		ping := func() error {
+			w.WriteString(": ping\n\n")
+			return w.Flush()
+		}
+		if err := s.followHeads(ctx, from, send, ping); err != nil && ctx.Err() == nil {
+			_, code := errorStatus(err)
+			data, _ := json.Marshal(&errorEnvelope{Error: &errorBody{Code: code, Message: err.Error()}})
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			w.Flush()
+		}
+	})
+}
+
+// GET /ws/blocks?from={number}&txs={view}&fields={list}
+func (s *RouterToServe) requestBlockSocket(ctx *fasthttp.RequestCtx) {
+	from, p, err := streamArgs(ctx)
+	if err != nil {
+		failRequest(ctx, err)
+		return
+	}
+	// the request ctx is released when the connection is hijacked, so the stream has its own context
+	upgrader.Upgrade(ctx, func(conn *websocket.Conn) {
+		defer conn.Close()
+		sctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			// messages of the client aren't expected, reading notices a close or a gone client
+			defer cancel()
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+		send := func(b *model.Block) error {
+			data, err := blockView(b, p)
+			if err != nil {
+				return err
+			}
+			return conn.WriteMessage(websocket.TextMessage, data)
+		}
+		ping := func() error {
+			return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamHeartbeat))
+		}
+		closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		if err := s.followHeads(sctx, from, send, ping); err != nil && sctx.Err() == nil {
+			reason := err.Error()
+			// a control frame has 125 bytes at most, 2 of them are the code
+			if len(reason) > 123 {
+				reason = reason[:123]
+			}
+			closing = websocket.FormatCloseMessage(websocket.CloseInternalServerErr, reason)
+		}
+		conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(time.Second))
+	})
+}
+
+// streamArgs reads the number to resume a stream from and the projection of its blocks.
+// The from query arg wins over Last-Event-ID, without both a stream starts at the head
+func streamArgs(ctx *fasthttp.RequestCtx) (*uint64, *model.Projection, error) {
+	p, err := projectionArgs(ctx.QueryArgs())
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ctx.QueryArgs().Peek("from")) > 0 {
+		from, err := uintArg(ctx.QueryArgs(), "from", nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &from, p, nil
+	}
+	if raw := ctx.Request.Header.Peek(lastEventIDHeader); len(raw) > 0 {
+		last, err := parseBlockNumber(string(raw))
+		if err != nil {
+			return nil, nil, &model.InvalidHeaderError{Header: lastEventIDHeader, Value: string(raw)}
+		}
+		from := last + 1
+		return &from, p, nil
+	}
+	return nil, p, nil
+}
+
+// followHeads sends blocks from a number up to the head when the number is given, then every new head.
+// Blocks skipped between two polls are sent too, so a stream has no gaps, but it never goes back further
+// than StreamBacklog. A head below the next number is a reorg and it's sent as well.
+// It returns nil when the stream has fallen behind the hub, so the client resumes with a new request
+func (s *RouterToServe) followHeads(
+	ctx context.Context,
+	from *uint64,
+	send func(*model.Block) error,
+	ping func() error,
+) error {
+	sub, head := s.heads.subscribe()
+	defer s.heads.unsubscribe(sub)
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	var next uint64
+	started, sent := from != nil, false
+	if started {
+		next = *from
+	}
+	follow := func(b *model.Block) error {
+		number, err := parseBlockNumber(b.Number)
+		if err != nil {
+			return err
+		}
+		if !started {
+			started, next = true, number
+		}
+		if number < next && !sent {
+			// the client has got it before reconnecting
+			return nil
+		}
+		if number >= next && number-next > s.conf.StreamBacklog {
+			next = number - s.conf.StreamBacklog
+		}
+		for ; next < number; next++ {
+			missed, err := s.streamBlock(ctx, next)
+			if err != nil {
+				return err
+			}
+			if err := send(missed); err != nil {
+				return err
+			}
+		}
+		next, sent = number+1, true
+		return send(b)
+	}
+
+	if head != nil {
+		if err := follow(head); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case b, ok := <-sub:
+			if !ok {
+				return nil
+			}
+			if err := follow(b); err != nil {
+				return err
+			}
+		case <-heartbeat.C:
+			if err := ping(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// streamBlock gets a block a stream has missed, every block has its own deadline
+func (s *RouterToServe) streamBlock(parent context.Context, number uint64) (*model.Block, error) {
+	ctx, cancel := context.WithTimeout(parent, s.conf.Timeout)
+	defer cancel()
+	return s.client.GetBlockBy(ctx, fmt.Sprintf("0x%x", number))
+}
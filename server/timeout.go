@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"my.eth.test/model"
+)
+
+// timeoutHeader lets a client shorten a deadline of its request.
+// The value is a duration like "1.5s" or a number of milliseconds
+const timeoutHeader = "X-Request-Timeout"
+
+// disconnectCheck is how often a connection is checked for a gone client while upstream work is running
+const disconnectCheck = 50 * time.Millisecond
+
+// upstreamContext derives a context for upstream calls from the server config and the timeout header.
+// RequestCtx is done when the server shuts down, so it's the parent. The context is cancelled
+// when the client closes its connection too
+func (s *RouterToServe) upstreamContext(ctx *fasthttp.RequestCtx) (context.Context, context.CancelFunc, error) {
+	timeout, err := s.requestTimeout(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, cancel := context.WithTimeout(ctx, timeout)
+	stop := watchClient(ctx.Conn(), cancel)
+	return c, func() {
+		stop()
+		cancel()
+	}, nil
+}
+
+// watchClient calls cancel when the peer of a connection has closed it. The returned func stops watching,
+// it returns once the connection is left to the server, so the next request on it is read by the server only
+func watchClient(conn net.Conn, cancel context.CancelFunc) func() {
+	if !watchable(conn) {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(disconnectCheck)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if peerClosed(conn) {
+					log.Printf("the client %s has gone, its upstream requests are cancelled\n", conn.RemoteAddr())
+					cancel()
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// requestTimeout is the config timeout or a shorter one from the header
+func (s *RouterToServe) requestTimeout(ctx *fasthttp.RequestCtx) (time.Duration, error) {
+	timeout := s.conf.Timeout
+	if raw := ctx.Request.Header.Peek(timeoutHeader); len(raw) > 0 {
+		requested, err := parseTimeout(string(raw))
+		if err != nil {
+			return 0, &model.InvalidHeaderError{Header: timeoutHeader, Value: string(raw)}
+		}
+		if requested < timeout {
+			timeout = requested
+		}
+	}
+	return timeout, nil
+}
+
+func parseTimeout(raw string) (time.Duration, error) {
+	if ms, err := strconv.ParseUint(raw, 10, 32); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, &model.InvalidHeaderError{Header: timeoutHeader, Value: raw}
+	}
+	return d, nil
+}
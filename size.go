@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag value of a size in bytes like 2GiB, 512MB or 1048576
+type byteSize int64
+
+var units = []struct {
+	suffix string
+	factor int64
+}{
+	// longer suffixes go first, so "GiB" isn't taken for "B"
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+func (s *byteSize) Set(arg string) error {
+	value := strings.TrimSpace(arg)
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(u.suffix)) {
+			factor = u.factor
+			value = strings.TrimSpace(value[:len(value)-len(u.suffix)])
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	// NaN fails every comparison, so it's rejected too
+	if err != nil || !(number >= 0) {
+		return fmt.Errorf("invalid size '%s', it's a number of bytes with an optional unit like 2GiB", arg)
+	}
+	size := number * float64(factor)
+	// float64(math.MaxInt64) is rounded up to 2^63, which doesn't fit an int64 already
+	if size >= float64(math.MaxInt64) {
+		return fmt.Errorf("invalid size '%s', it's over %d bytes", arg, int64(math.MaxInt64))
+	}
+	*s = byteSize(size)
+	return nil
+}
+
+func (s *byteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
@@ -0,0 +1,524 @@
+// Package store is a persistent key-value store made of append-only segment files.
+//
+// Every record is
+//
+//	crc32 (4) | kind (1) | key length (2) | value length (4) | key | value
+//
+// where crc32 covers everything after itself. A record is appended to the active segment,
+// a newer record of a key shadows older ones, a delete is a tombstone record.
+// A torn or corrupted tail of the active segment left by a crash is cut off when the store is opened,
+// a broken record in an older segment fails the opening.
+// Old segments are dropped when the store outgrows its size limit, and segments
+// full of shadowed records are compacted: live records are copied to the active segment
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerSize = 11
+	kindPut    = 0
+	kindDelete = 1
+	extension  = ".seg"
+)
+
+// Options is the set of store settings
+type Options struct {
+	MaxBytes        int64         // the size limit of all segments, 0 is unlimited
+	SegmentBytes    int64         // the size of a segment to start a new one. 64MiB by default
+	CompactInterval time.Duration // how often segments are checked for compaction. 10m by default
+	GarbageRatio    float64       // the share of shadowed bytes to compact a segment. 0.5 by default
+	NoSync          bool          // skips fsync after every write, it's faster but a crash may lose writes or break older segments
+}
+
+func (o Options) withDefaults() Options {
+	if o.SegmentBytes <= 0 {
+		o.SegmentBytes = 64 << 20
+	}
+	if o.CompactInterval <= 0 {
+		o.CompactInterval = 10 * time.Minute
+	}
+	if o.GarbageRatio <= 0 || o.GarbageRatio > 1 {
+		o.GarbageRatio = 0.5
+	}
+	return o
+}
+
+// location is where a value of a key is stored
+type location struct {
+	segment uint32
+	offset  int64
+	size    int64 // the whole record
+	value   int64 // the value length
+}
+
+type segment struct {
+	id    uint32
+	file  *os.File
+	size  int64
+	live  int64
+	tombs map[string]bool
+}
+
+// Store is the persistent key-value store
+type Store struct {
+	dir      string
+	opts     Options
+	lock     sync.RWMutex
+	index    map[string]location
+	segments map[uint32]*segment
+	order    []uint32 // segment ids, the oldest first
+	done     chan struct{}
+	closed   bool
+}
+
+// Open opens or creates a store in a directory and rebuilds its index
+func Open(dir string, opts Options) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:      dir,
+		opts:     opts.withDefaults(),
+		index:    make(map[string]location),
+		segments: make(map[uint32]*segment),
+		done:     make(chan struct{}),
+	}
+	ids, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		if err := s.load(id, i == len(ids)-1); err != nil {
+			s.closeFiles()
+			return nil, err
+		}
+	}
+	if len(s.order) == 0 {
+		if err := s.roll(); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("the store in %s is opened with %d records in %d segments\n", dir, len(s.index), len(s.order))
+	go s.compactLoop()
+	return s, nil
+}
+
+func (s *Store) list() ([]uint32, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), extension) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), extension), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (s *Store) path(id uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%09d%s", id, extension))
+}
+
+// load replays a segment into the index, a torn tail is cut off only in the last segment,
+// the earlier ones are synced before the next one is created, so a broken record there isn't left by a crash
+func (s *Store) load(id uint32, last bool) error {
+	f, err := os.OpenFile(s.path(id), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	seg := &segment{id: id, file: f, tombs: make(map[string]bool)}
+	s.segments[id] = seg
+	s.order = append(s.order, id)
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		kind, key, valueLen, size, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !last {
+			return fmt.Errorf("the segment %s has a broken record at %d: %s", s.path(id), offset, err.Error())
+		}
+		if err != nil {
+			log.Printf("the segment %s has a broken record at %d (%s), it's cut off\n", s.path(id), offset, err.Error())
+			if err := f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		s.apply(seg, kind, key, location{segment: id, offset: offset, size: size, value: valueLen})
+		offset += size
+	}
+	seg.size = offset
+	return nil
+}
+
+func readRecord(r io.Reader) (kind byte, key string, valueLen int64, size int64, err error) {
+	header := make([]byte, headerSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("a torn header")
+		}
+		return
+	}
+	kind = header[4]
+	keyLen := int64(binary.BigEndian.Uint16(header[5:7]))
+	valueLen = int64(binary.BigEndian.Uint32(header[7:11]))
+	body := make([]byte, keyLen+valueLen)
+	if _, err = io.ReadFull(r, body); err != nil {
+		err = fmt.Errorf("a torn body")
+		return
+	}
+	sum := crc32.NewIEEE()
+	sum.Write(header[4:])
+	sum.Write(body)
+	if sum.Sum32() != binary.BigEndian.Uint32(header[:4]) {
+		err = fmt.Errorf("a checksum mismatch")
+		return
+	}
+	if kind != kindPut && kind != kindDelete {
+		err = fmt.Errorf("an unknown record kind %d", kind)
+		return
+	}
+	return kind, string(body[:keyLen]), valueLen, headerSize + keyLen + valueLen, nil
+}
+
+// apply updates the index with a record written to a segment
+func (s *Store) apply(seg *segment, kind byte, key string, loc location) {
+	if old, ok := s.index[key]; ok {
+		if oldSeg, ok := s.segments[old.segment]; ok {
+			oldSeg.live -= old.size
+		}
+	}
+	for _, other := range s.segments {
+		delete(other.tombs, key)
+	}
+	if kind == kindDelete {
+		delete(s.index, key)
+		seg.tombs[key] = true
+		return
+	}
+	s.index[key] = loc
+	seg.live += loc.size
+}
+
+// roll seals the active segment and starts a new one, a partial record of a failed write is cut off
+// and the sealed segment is synced, so only the last segment may have a torn tail
+func (s *Store) roll() error {
+	var id uint32 = 1
+	if len(s.order) > 0 {
+		seg := s.active()
+		if err := seg.file.Truncate(seg.size); err != nil {
+			return err
+		}
+		if !s.opts.NoSync {
+			if err := seg.file.Sync(); err != nil {
+				return err
+			}
+		}
+		id = seg.id + 1
+	}
+	f, err := os.OpenFile(s.path(id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	s.segments[id] = &segment{id: id, file: f, tombs: make(map[string]bool)}
+	s.order = append(s.order, id)
+	return s.syncDir()
+}
+
+func (s *Store) syncDir() error {
+	if s.opts.NoSync {
+		return nil
+	}
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *Store) active() *segment {
+	return s.segments[s.order[len(s.order)-1]]
+}
+
+// Get returns a value of a key
+func (s *Store) Get(key string) ([]byte, bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	loc, ok := s.index[key]
+	if !ok {
+		return nil, false, nil
+	}
+	value := make([]byte, loc.value)
+	start := loc.offset + headerSize + int64(len(key))
+	if _, err := s.segments[loc.segment].file.ReadAt(value, start); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Put stores a value of a key
+func (s *Store) Put(key string, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.write(kindPut, key, value); err != nil {
+		return err
+	}
+	return s.shrink()
+}
+
+// PutAll stores values of many keys with a single sync, it's cheaper than a Put per key
+func (s *Store) PutAll(values map[string][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	written := make(map[*segment]bool)
+	for key, value := range values {
+		seg, err := s.append(kindPut, key, value)
+		if err != nil {
+			return err
+		}
+		written[seg] = true
+	}
+	if !s.opts.NoSync {
+		for seg := range written {
+			if err := seg.file.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return s.shrink()
+}
+
+// Delete removes a key
+func (s *Store) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.index[key]; !ok {
+		return nil
+	}
+	return s.write(kindDelete, key, nil)
+}
+
+// Keys lists stored keys with a prefix in the ascending order
+func (s *Store) Keys(prefix string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var keys []string
+	for key := range s.index {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Len is the number of stored keys
+func (s *Store) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.index)
+}
+
+// Size is the size of all segments in bytes
+func (s *Store) Size() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var size int64
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+	return size
+}
+
+func (s *Store) write(kind byte, key string, value []byte) error {
+	seg, err := s.append(kind, key, value)
+	if err != nil || s.opts.NoSync {
+		return err
+	}
+	return seg.file.Sync()
+}
+
+// append writes a record to the active segment without a sync and returns the segment
+func (s *Store) append(kind byte, key string, value []byte) (*segment, error) {
+	if s.closed {
+		return nil, fmt.Errorf("the store in %s is closed", s.dir)
+	}
+	if len(key) > 0xFFFF || int64(len(value)) > 0xFFFFFFFF {
+		return nil, fmt.Errorf("the record %s is too big", key)
+	}
+	record := make([]byte, headerSize+len(key)+len(value))
+	record[4] = kind
+	binary.BigEndian.PutUint16(record[5:7], uint16(len(key)))
+	binary.BigEndian.PutUint32(record[7:11], uint32(len(value)))
+	copy(record[headerSize:], key)
+	copy(record[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(record[:4], crc32.ChecksumIEEE(record[4:]))
+
+	seg := s.active()
+	if seg.size > 0 && seg.size+int64(len(record)) > s.opts.SegmentBytes {
+		if err := s.roll(); err != nil {
+			return nil, err
+		}
+		seg = s.active()
+	}
+	if _, err := seg.file.WriteAt(record, seg.size); err != nil {
+		// a partial record is overwritten by the next write or cut off on the next open
+		return nil, err
+	}
+	loc := location{segment: seg.id, offset: seg.size, size: int64(len(record)), value: int64(len(value))}
+	seg.size += loc.size
+	s.apply(seg, kind, key, loc)
+	return seg, nil
+}
+
+// shrink drops the oldest segments while the store is over its size limit
+func (s *Store) shrink() error {
+	if s.opts.MaxBytes <= 0 {
+		return nil
+	}
+	var size int64
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+	for size > s.opts.MaxBytes && len(s.order) > 1 {
+		seg := s.segments[s.order[0]]
+		for key, loc := range s.index {
+			if loc.segment == seg.id {
+				delete(s.index, key)
+			}
+		}
+		size -= seg.size
+		if err := s.drop(seg); err != nil {
+			return err
+		}
+		log.Printf("the segment %s is dropped to fit the store size limit\n", s.path(seg.id))
+	}
+	return nil
+}
+
+func (s *Store) drop(seg *segment) error {
+	seg.file.Close()
+	delete(s.segments, seg.id)
+	for i, id := range s.order {
+		if id == seg.id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	if err := os.Remove(s.path(seg.id)); err != nil {
+		return err
+	}
+	return s.syncDir()
+}
+
+// Compact copies live records of segments full of garbage to the active segment and removes them
+func (s *Store) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	for _, id := range append([]uint32(nil), s.order[:len(s.order)-1]...) {
+		seg := s.segments[id]
+		if seg.size == 0 || float64(seg.size-seg.live)/float64(seg.size) < s.opts.GarbageRatio {
+			continue
+		}
+		if err := s.compact(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) compact(seg *segment) error {
+	var keys []string
+	for key, loc := range s.index {
+		if loc.segment == seg.id {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		loc := s.index[key]
+		value := make([]byte, loc.value)
+		if _, err := seg.file.ReadAt(value, loc.offset+headerSize+int64(len(key))); err != nil {
+			return err
+		}
+		if err := s.write(kindPut, key, value); err != nil {
+			return err
+		}
+	}
+	// tombstones still shadow records of older segments
+	if s.order[0] != seg.id {
+		for key := range seg.tombs {
+			if err := s.write(kindDelete, key, nil); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("the segment %s is compacted, %d records are moved\n", s.path(seg.id), len(keys))
+	return s.drop(seg)
+}
+
+func (s *Store) compactLoop() {
+	ticker := time.NewTicker(s.opts.CompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Compact(); err != nil {
+				log.Printf("an error (%s) occured while compacting the store in %s\n", err.Error(), s.dir)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close stops compaction and closes segment files
+func (s *Store) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	return s.closeFiles()
+}
+
+func (s *Store) closeFiles() error {
+	var firstErr error
+	for _, seg := range s.segments {
+		if err := seg.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func tempStore(t *testing.T, opts Options) (*Store, string) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func expectValue(t *testing.T, s *Store, key string, expected string) {
+	value, ok, err := s.Get(key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !ok {
+		t.Errorf("The key %s not found", key)
+		return
+	}
+	if string(value) != expected {
+		t.Errorf("Invalid value of %s: %s\nexpected: %s", key, value, expected)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	s, dir := tempStore(t, Options{})
+	defer os.RemoveAll(dir)
+
+	s.Put("0x1", []byte("one"))
+	s.Put("0x2", []byte("two"))
+	s.Put("0x1", []byte("uno"))
+	s.Delete("0x2")
+	s.Close()
+
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	expectValue(t, s, "0x1", "uno")
+	if _, ok, _ := s.Get("0x2"); ok {
+		t.Error("The deleted key 0x2 has been found")
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	s, dir := tempStore(t, Options{})
+	defer os.RemoveAll(dir)
+
+	s.Put("0x1", []byte("one"))
+	s.Close()
+
+	// a crash in the middle of a write leaves a part of a record
+	f, err := os.OpenFile(s.path(1), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0, 0, 3})
+	f.Close()
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, s, "0x1", "one")
+	if err := s.Put("0x2", []byte("two")); err != nil {
+		t.Error(err)
+	}
+	s.Close()
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	expectValue(t, s, "0x1", "one")
+	expectValue(t, s, "0x2", "two")
+}
+
+func TestBrokenSealedSegment(t *testing.T) {
+	s, dir := tempStore(t, Options{SegmentBytes: 16})
+	defer os.RemoveAll(dir)
+
+	s.Put("0x1", []byte("one"))
+	s.Put("0x2", []byte("two"))
+	s.Close()
+	sealed := s.path(1)
+
+	// a record of a sealed segment isn't left by a crash, it isn't cut off
+	f, err := os.OpenFile(sealed, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("x"), headerSize+3)
+	f.Close()
+
+	if s, err = Open(dir, Options{}); err == nil {
+		s.Close()
+		t.Fatal("A broken record of a sealed segment is ignored")
+	}
+	info, err := os.Stat(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != headerSize+6 {
+		t.Errorf("The sealed segment is cut off: %d bytes", info.Size())
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	s, dir := tempStore(t, Options{SegmentBytes: 64})
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 10; i++ {
+		s.Put("0x1", []byte(fmt.Sprintf("value %d", i)))
+		s.Put(fmt.Sprintf("0x%x", 100+i), []byte("kept"))
+	}
+	before := s.Size()
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() >= before {
+		t.Errorf("The store hasn't shrunk after compaction: %d\nbefore: %d", s.Size(), before)
+	}
+	s.Close()
+
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	expectValue(t, s, "0x1", "value 9")
+	for i := 0; i < 10; i++ {
+		expectValue(t, s, fmt.Sprintf("0x%x", 100+i), "kept")
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	s, dir := tempStore(t, Options{SegmentBytes: 64, MaxBytes: 256})
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	for i := 0; i < 100; i++ {
+		s.Put(fmt.Sprintf("0x%x", i), []byte("some block"))
+	}
+	if s.Size() > 256 {
+		t.Errorf("The store is over its limit: %d", s.Size())
+	}
+	if _, ok, _ := s.Get("0x0"); ok {
+		t.Error("The oldest key 0x0 has been found")
+	}
+	expectValue(t, s, "0x63", "some block")
+}
+
+func TestPutAll(t *testing.T) {
+	s, dir := tempStore(t, Options{SegmentBytes: 64})
+	defer os.RemoveAll(dir)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 10; i++ {
+		values[fmt.Sprintf("tx:%d", i)] = []byte(fmt.Sprintf("0x%x", i))
+	}
+	if err := s.PutAll(values); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// the records are spread over a few segments, every one of them must survive
+	s, err := Open(dir, Options{SegmentBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Len() != len(values) {
+		t.Errorf("Invalid number of keys: %d\nexpected: %d", s.Len(), len(values))
+	}
+	for key, value := range values {
+		expectValue(t, s, key, string(value))
+	}
+}
+
+func TestKeys(t *testing.T) {
+	s, dir := tempStore(t, Options{})
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	for _, key := range []string{"hook:2", "delivery:1", "hook:1", "hook:3"} {
+		if err := s.Put(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Delete("hook:3")
+	if keys := fmt.Sprint(s.Keys("hook:")); keys != "[hook:1 hook:2]" {
+		t.Errorf("Invalid keys: %s\nexpected: [hook:1 hook:2]", keys)
+	}
+}
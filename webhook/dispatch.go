@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// headers of a delivery
+const (
+	HeaderHook      = "X-Hook-ID"
+	HeaderDelivery  = "X-Hook-Delivery"
+	HeaderEvent     = "X-Hook-Event"
+	HeaderSignature = "X-Hook-Signature"
+)
+
+// dispatch sends due deliveries till the manager is closed, Workers of them at once
+func (m *Manager) dispatch() {
+	defer m.wg.Done()
+	slots := make(chan struct{}, m.conf.Workers)
+	// retries fall due at any time, so they are looked for twice per base delay
+	tick := m.conf.BaseDelay / 2
+	if tick <= 0 {
+		tick = m.conf.BaseDelay
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		for _, d := range m.due(cap(slots) - len(slots)) {
+			slots <- struct{}{}
+			m.wg.Add(1)
+			go func(d *Delivery, secret string, url string) {
+				defer m.wg.Done()
+				defer func() { <-slots }()
+				m.finish(d.ID, m.send(d, secret, url))
+				// a free slot may take a delivery that's waiting
+				m.notify()
+			}(d.delivery, d.secret, d.url)
+		}
+		select {
+		case <-ticker.C:
+		case <-m.wake:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// dueDelivery is a copy of a delivery to send with what's needed to send it
+type dueDelivery struct {
+	delivery *Delivery
+	secret   string
+	url      string
+}
+
+// due takes the oldest pending deliveries whose time has come and marks them as being sent
+func (m *Manager) due(limit int) []dueDelivery {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	var list []dueDelivery
+	for _, d := range m.deliveries {
+		if d.Status != StatusPending || m.sending[d.ID] || d.NextAttempt != nil && d.NextAttempt.After(now) {
+			continue
+		}
+		hook, ok := m.hooks[d.Hook]
+		if !ok {
+			continue
+		}
+		c := *d
+		list = append(list, dueDelivery{delivery: &c, secret: hook.Secret, url: hook.URL})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].delivery.ID < list[j].delivery.ID })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	for _, d := range list {
+		m.sending[d.delivery.ID] = true
+	}
+	return list
+}
+
+// attempt is the outcome of sending a delivery
+type attempt struct {
+	status int
+	err    error
+}
+
+// send posts the payload of a delivery signed with the secret of its hook
+func (m *Manager) send(d *Delivery, secret string, url string) attempt {
+	req, err := newRequest(url, d, secret, time.Now())
+	if err != nil {
+		return attempt{err: err}
+	}
+	resp, err := m.http.Do(req)
+	if err != nil {
+		return attempt{err: err}
+	}
+	defer resp.Body.Close()
+	// the connection is reused only when the body is read, a huge body isn't worth it
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return attempt{status: resp.StatusCode, err: fmt.Errorf("the receiver answered %s", resp.Status)}
+	}
+	return attempt{status: resp.StatusCode}
+}
+
+// newRequest is the POST of a delivery
+func newRequest(url string, d *Delivery, secret string, at time.Time) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderHook, d.Hook)
+	req.Header.Set(HeaderDelivery, d.ID)
+	req.Header.Set(HeaderEvent, d.Kind)
+	req.Header.Set(HeaderSignature, fmt.Sprintf("t=%d,v1=%s", at.Unix(), Sign(secret, at.Unix(), d.Payload)))
+	return req, nil
+}
+
+// Sign is the signature of a payload sent at a time: the hex HMAC-SHA256 of "{unix time}.{payload}" with the secret of a hook.
+// The X-Hook-Signature header is "t={unix time},v1={signature}", the time lets receivers reject replayed requests
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// finish records an attempt: a delivered or failed delivery is finished, otherwise it's retried after a backoff
+func (m *Manager) finish(id string, a attempt) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.sending, id)
+	d, ok := m.deliveries[id]
+	if !ok {
+		// the hook has been removed meanwhile
+		return
+	}
+	now := time.Now().UTC()
+	d.Attempts++
+	d.LastStatus = a.status
+	d.LastError = ""
+	d.NextAttempt = nil
+	switch {
+	case a.err == nil:
+		d.Status = StatusDelivered
+		d.Finished = &now
+	case d.Attempts >= m.conf.MaxAttempts:
+		d.LastError = a.err.Error()
+		d.Status = StatusFailed
+		d.Finished = &now
+		log.Printf("an error (%s) occured while delivering %s to the webhook %s, it's given up\n", a.err.Error(), d.ID, d.Hook)
+	default:
+		d.LastError = a.err.Error()
+		next := now.Add(m.backoff(d.Attempts))
+		d.NextAttempt = &next
+	}
+	if err := m.save(deliveryPrefix+d.ID, d); err != nil {
+		log.Printf("an error (%s) occured while saving the delivery %s\n", err.Error(), d.ID)
+	}
+	if d.Finished != nil {
+		m.prune(d.Hook)
+	}
+}
+
+// backoff is the delay after a number of failed attempts
+func (m *Manager) backoff(attempts int) time.Duration {
+	delay := m.conf.BaseDelay
+	for i := 1; i < attempts && delay < m.conf.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > m.conf.MaxDelay {
+		delay = m.conf.MaxDelay
+	}
+	return delay
+}
+
+// prune drops the oldest finished deliveries of a hook beyond History, the manager must be locked
+func (m *Manager) prune(hook string) {
+	var finished []*Delivery
+	for _, d := range m.deliveries {
+		if d.Hook == hook && d.Finished != nil {
+			finished = append(finished, d)
+		}
+	}
+	if len(finished) <= m.conf.History {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].ID < finished[j].ID })
+	for _, d := range finished[:len(finished)-m.conf.History] {
+		m.drop(d)
+	}
+}
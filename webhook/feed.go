@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"my.eth.test/client"
+	"my.eth.test/model"
+)
+
+// follow looks for new blocks till the manager is closed
+func (m *Manager) follow() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.conf.Interval)
+	defer ticker.Stop()
+	for {
+		if err := m.catchUp(); err != nil {
+			log.Printf("an error (%s) occured while looking for new blocks for webhooks\n", err.Error())
+		}
+		select {
+		case <-ticker.C:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// catchUp processes blocks from the cursor up to the latest final one. The first start begins at it,
+// a restart resumes from the saved cursor but never goes back further than Backlog.
+// A block is processed once by its number, it's final, so a reorganization doesn't leave its events orphaned
+func (m *Manager) catchUp() error {
+	latest, err := m.head()
+	if err != nil {
+		return err
+	}
+	head, ok := m.finalHead(latest)
+	if !ok {
+		return nil
+	}
+	m.lock.Lock()
+	next := m.cursor + 1
+	if m.cursor == 0 {
+		next = head
+	}
+	m.lock.Unlock()
+	if head >= next && head-next > m.conf.Backlog {
+		next = head - m.conf.Backlog
+	}
+	for ; next <= head; next++ {
+		select {
+		case <-m.done:
+			return nil
+		default:
+		}
+		b, err := m.block(next)
+		if err != nil {
+			return err
+		}
+		if err := m.process(next, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// head is the number of the latest block, the source is asked for the latest block only when it doesn't follow the head
+func (m *Manager) head() (uint64, error) {
+	if reporter, ok := m.source.(client.HeadReporter); ok {
+		if status := reporter.Head(); status.Number > 0 {
+			return status.Number, nil
+		}
+	}
+	b, err := m.block(0)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(b.Number, 0, 64)
+}
+
+// finalHead is the latest block that won't change, blocks below a final one are final too, so it's searched by halves.
+// The head is final itself when the source can't tell
+func (m *Manager) finalHead(head uint64) (uint64, bool) {
+	reporter, ok := m.source.(client.FinalityReporter)
+	if !ok {
+		return head, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.conf.Timeout)
+	defer cancel()
+	// the first block that isn't final, every block up to the head is final when it's head+1
+	first := uint64(sort.Search(int(head), func(i int) bool {
+		return !reporter.Final(ctx, fmt.Sprintf("0x%x", i+1))
+	})) + 1
+	return first - 1, first > 1
+}
+
+// block gets a block by its number, 0 is the latest one
+func (m *Manager) block(number uint64) (*model.Block, error) {
+	id := "latest"
+	if number > 0 {
+		id = fmt.Sprintf("0x%x", number)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.conf.Timeout)
+	defer cancel()
+	return m.source.GetBlockBy(ctx, id)
+}
+
+// process enqueues events of a block for every matching hook and moves the cursor past it
+func (m *Manager) process(number uint64, b *model.Block) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, hook := range m.hooks {
+		for _, event := range events(hook, b) {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := m.enqueue(hook.ID, event.Kind, payload, ""); err != nil {
+				return err
+			}
+		}
+	}
+	if err := m.save(cursorKey, number); err != nil {
+		return err
+	}
+	m.cursor = number
+	return nil
+}
+
+// events are what a hook gets of a block: the block itself and matching transactions
+func events(hook *Hook, b *model.Block) []*Event {
+	var list []*Event
+	if hook.Filter.Blocks {
+		list = append(list, &Event{Hook: hook.ID, Kind: KindBlock, Block: &b.NoTransactionBlock})
+	}
+	for _, tx := range b.Transactions {
+		if hook.Filter.matches(tx) {
+			list = append(list, &Event{Hook: hook.ID, Kind: KindTransaction, Block: &b.NoTransactionBlock, Transaction: tx})
+		}
+	}
+	return list
+}
@@ -0,0 +1,484 @@
+// Package webhook delivers new blocks and matching transactions to registered URLs.
+//
+// A hook is a URL with a filter: every new block, transactions from or to given addresses,
+// transactions with a value above a threshold. Blocks come from the block source the service
+// already serves, so they're mostly taken from its cache. Every matching event becomes a delivery:
+// a signed POST that is retried with a backoff till the receiver answers 2xx or attempts run out.
+// Hooks, pending deliveries, the delivery history and the last processed block are kept in a store,
+// so nothing is lost on restart
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"my.eth.test/client"
+	"my.eth.test/model"
+)
+
+// keys of records in the store
+const (
+	hookPrefix     = "hook:"
+	deliveryPrefix = "delivery:"
+	cursorKey      = "cursor"
+)
+
+// kinds of events
+const (
+	KindBlock       = "block"
+	KindTransaction = "transaction"
+)
+
+// statuses of deliveries
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Store keeps hooks and deliveries, store.Store is the usual one
+type Store interface {
+	Get(key string) ([]byte, bool, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	Keys(prefix string) []string
+}
+
+// Config is the set of webhook settings
+type Config struct {
+	Store       Store         // where hooks and deliveries are kept, nil keeps them in memory only
+	Interval    time.Duration // how often new blocks are looked for, 1s by default
+	Backlog     uint64        // how many blocks behind the latest final one are processed after a restart, 128 by default
+	Workers     int           // how many deliveries are sent at once, 4 by default
+	Timeout     time.Duration // the longest time a receiver may take to answer, 10s by default
+	MaxAttempts int           // how many times a delivery is sent before it fails, 8 by default
+	BaseDelay   time.Duration // a delay before the first retry, it's doubled every next one. 1s by default
+	MaxDelay    time.Duration // the upper limit of a delay between retries, 10m by default
+	History     int           // how many finished deliveries of a hook are kept, 1000 by default
+}
+
+func (c Config) withDefaults() Config {
+	if c.Interval <= 0 {
+		c.Interval = time.Second
+	}
+	if c.Backlog == 0 {
+		c.Backlog = 128
+	}
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 8
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = time.Second
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = 10 * time.Minute
+	}
+	if c.History <= 0 {
+		c.History = 1000
+	}
+	return c
+}
+
+// Filter chooses events of a hook. Blocks asks for every new block. From, To and MinValue choose
+// transactions: a transaction matches when it meets every given criterion, an address matches any of its list
+type Filter struct {
+	Blocks   bool     `json:"blocks,omitempty"`
+	From     []string `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	MinValue string   `json:"minValue,omitempty"` // wei in decimal or 0x hex, the value must be at least this
+
+	minValue *big.Int
+}
+
+// transactions says whether the filter chooses any transactions
+func (f *Filter) transactions() bool {
+	return len(f.From) > 0 || len(f.To) > 0 || f.MinValue != ""
+}
+
+// compile checks the filter and prepares it for matching
+func (f *Filter) compile() error {
+	if !f.Blocks && !f.transactions() {
+		return &model.InvalidHookError{Reason: "the filter chooses nothing, set blocks, from, to or minValue"}
+	}
+	for _, list := range [][]string{f.From, f.To} {
+		for i, address := range list {
+			if !isAddress(address) {
+				return &model.InvalidHookError{Reason: fmt.Sprintf("%q isn't an address", address)}
+			}
+			list[i] = strings.ToLower(address)
+		}
+	}
+	f.minValue = nil
+	if f.MinValue != "" {
+		value, ok := parseValue(f.MinValue)
+		if !ok {
+			return &model.InvalidHookError{Reason: fmt.Sprintf("%q isn't a value in wei", f.MinValue)}
+		}
+		f.minValue = value
+	}
+	return nil
+}
+
+// matches says whether a transaction meets the filter
+func (f *Filter) matches(tx *model.Transaction) bool {
+	if !f.transactions() {
+		return false
+	}
+	if len(f.From) > 0 && !contains(f.From, strings.ToLower(tx.From)) {
+		return false
+	}
+	if len(f.To) > 0 && !contains(f.To, strings.ToLower(tx.To)) {
+		return false
+	}
+	if f.minValue != nil {
+		value, ok := parseValue(tx.Value)
+		if !ok || value.Cmp(f.minValue) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isAddress(s string) bool {
+	if len(s) != 42 || !strings.HasPrefix(s, "0x") {
+		return false
+	}
+	_, err := hex.DecodeString(s[2:])
+	return err == nil
+}
+
+// parseValue reads an amount of wei in decimal or 0x hex
+func parseValue(s string) (*big.Int, bool) {
+	value, ok := new(big.Int).SetString(s, 0)
+	if !ok || value.Sign() < 0 {
+		return nil, false
+	}
+	return value, true
+}
+
+// Hook is a registered receiver of events. The secret signs payloads, it's shown only on registration
+type Hook struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url"`
+	Secret  string    `json:"secret,omitempty"`
+	Filter  Filter    `json:"filter"`
+	Created time.Time `json:"created"`
+}
+
+// public is a copy of a hook without its secret
+func (h *Hook) public() *Hook {
+	p := *h
+	p.Secret = ""
+	return &p
+}
+
+// Event is the payload of a delivery. A transaction event has the header of its block
+type Event struct {
+	Hook        string                    `json:"hook"`
+	Kind        string                    `json:"kind"`
+	Block       *model.NoTransactionBlock `json:"block"`
+	Transaction *model.Transaction        `json:"transaction,omitempty"`
+}
+
+// Delivery is an attempt to hand an event to a hook, it's pending till it's delivered or failed
+type Delivery struct {
+	ID          string          `json:"id"`
+	Hook        string          `json:"hook"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	LastStatus  int             `json:"lastStatus,omitempty"`
+	LastError   string          `json:"lastError,omitempty"`
+	NextAttempt *time.Time      `json:"nextAttempt,omitempty"`
+	ReplayOf    string          `json:"replayOf,omitempty"`
+	Created     time.Time       `json:"created"`
+	Finished    *time.Time      `json:"finished,omitempty"`
+}
+
+// Manager keeps hooks, follows the chain and delivers events
+type Manager struct {
+	source client.BlockSource
+	conf   Config
+	http   *http.Client
+
+	lock       sync.Mutex
+	hooks      map[string]*Hook
+	deliveries map[string]*Delivery
+	sending    map[string]bool
+	cursor     uint64 // the last processed block, 0 till the first one
+	sequence   uint64
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New loads hooks and deliveries from the store and starts to follow the chain
+func New(source client.BlockSource, conf Config) (*Manager, error) {
+	conf = conf.withDefaults()
+	m := &Manager{
+		source:     source,
+		conf:       conf,
+		http:       &http.Client{Timeout: conf.Timeout},
+		hooks:      make(map[string]*Hook),
+		deliveries: make(map[string]*Delivery),
+		sending:    make(map[string]bool),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	m.wg.Add(2)
+	go m.follow()
+	go m.dispatch()
+	return m, nil
+}
+
+// load reads the saved state
+func (m *Manager) load() error {
+	if m.conf.Store == nil {
+		return nil
+	}
+	for _, key := range m.conf.Store.Keys(hookPrefix) {
+		hook := new(Hook)
+		if err := m.read(key, hook); err != nil {
+			return err
+		}
+		if err := hook.Filter.compile(); err != nil {
+			return err
+		}
+		m.hooks[hook.ID] = hook
+	}
+	for _, key := range m.conf.Store.Keys(deliveryPrefix) {
+		d := new(Delivery)
+		if err := m.read(key, d); err != nil {
+			return err
+		}
+		m.deliveries[d.ID] = d
+	}
+	data, ok, err := m.conf.Store.Get(cursorKey)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return json.Unmarshal(data, &m.cursor)
+	}
+	return nil
+}
+
+func (m *Manager) read(key string, v interface{}) error {
+	data, ok, err := m.conf.Store.Get(key)
+	if err != nil || !ok {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// save writes a record to the store, there's nothing to do without it
+func (m *Manager) save(key string, v interface{}) error {
+	if m.conf.Store == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return m.conf.Store.Put(key, data)
+}
+
+func (m *Manager) remove(key string) error {
+	if m.conf.Store == nil {
+		return nil
+	}
+	return m.conf.Store.Delete(key)
+}
+
+// Register adds a hook. A secret is generated when it's empty, the returned hook is the only place it's shown
+func (m *Manager) Register(hook Hook) (*Hook, error) {
+	u, err := url.Parse(hook.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, &model.InvalidHookError{Reason: fmt.Sprintf("%q isn't an http or https URL", hook.URL)}
+	}
+	if err := hook.Filter.compile(); err != nil {
+		return nil, err
+	}
+	hook.ID = randomHex(8)
+	if hook.Secret == "" {
+		hook.Secret = randomHex(24)
+	}
+	hook.Created = time.Now().UTC()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := m.save(hookPrefix+hook.ID, &hook); err != nil {
+		return nil, err
+	}
+	m.hooks[hook.ID] = &hook
+	return &hook, nil
+}
+
+// Hooks lists registered hooks in the order of registration
+func (m *Manager) Hooks() []*Hook {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	hooks := make([]*Hook, 0, len(m.hooks))
+	for _, hook := range m.hooks {
+		hooks = append(hooks, hook.public())
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].Created.Before(hooks[j].Created) ||
+			hooks[i].Created.Equal(hooks[j].Created) && hooks[i].ID < hooks[j].ID
+	})
+	return hooks
+}
+
+// Hook returns a registered hook
+func (m *Manager) Hook(id string) (*Hook, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	hook, ok := m.hooks[id]
+	if !ok {
+		return nil, &model.NotFoundHookError{ID: id}
+	}
+	return hook.public(), nil
+}
+
+// Remove drops a hook with its deliveries, pending ones aren't sent anymore
+func (m *Manager) Remove(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.hooks[id]; !ok {
+		return &model.NotFoundHookError{ID: id}
+	}
+	if err := m.remove(hookPrefix + id); err != nil {
+		return err
+	}
+	delete(m.hooks, id)
+	for _, d := range m.deliveries {
+		if d.Hook == id {
+			m.drop(d)
+		}
+	}
+	return nil
+}
+
+// drop forgets a delivery, the manager must be locked
+func (m *Manager) drop(d *Delivery) {
+	if err := m.remove(deliveryPrefix + d.ID); err != nil {
+		log.Printf("an error (%s) occured while removing the delivery %s\n", err.Error(), d.ID)
+	}
+	delete(m.deliveries, d.ID)
+}
+
+// Deliveries lists deliveries of a hook from the newest one. An empty status means any,
+// limit is the largest number of them, 0 is unlimited
+func (m *Manager) Deliveries(hook string, status string, limit int) ([]*Delivery, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.hooks[hook]; !ok {
+		return nil, &model.NotFoundHookError{ID: hook}
+	}
+	list := make([]*Delivery, 0)
+	for _, d := range m.deliveries {
+		if d.Hook == hook && (status == "" || d.Status == status) {
+			c := *d
+			list = append(list, &c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// Replay sends the event of a delivery once more as a new delivery
+func (m *Manager) Replay(hook string, id string) (*Delivery, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.hooks[hook]; !ok {
+		return nil, &model.NotFoundHookError{ID: hook}
+	}
+	original, ok := m.deliveries[id]
+	if !ok || original.Hook != hook {
+		return nil, &model.NotFoundDeliveryError{Hook: hook, ID: id}
+	}
+	d, err := m.enqueue(hook, original.Kind, original.Payload, id)
+	if err != nil {
+		return nil, err
+	}
+	c := *d
+	return &c, nil
+}
+
+// enqueue adds a pending delivery, the manager must be locked
+func (m *Manager) enqueue(hook string, kind string, payload json.RawMessage, replayOf string) (*Delivery, error) {
+	now := time.Now().UTC()
+	m.sequence++
+	d := &Delivery{
+		// ids are sorted by time
+		ID:          fmt.Sprintf("%016x%04x", now.UnixNano(), m.sequence&0xffff),
+		Hook:        hook,
+		Kind:        kind,
+		Payload:     payload,
+		Status:      StatusPending,
+		NextAttempt: &now,
+		ReplayOf:    replayOf,
+		Created:     now,
+	}
+	if err := m.save(deliveryPrefix+d.ID, d); err != nil {
+		return nil, err
+	}
+	m.deliveries[d.ID] = d
+	m.notify()
+	return d, nil
+}
+
+// notify wakes the dispatcher up
+func (m *Manager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops following the chain and waits for deliveries being sent, pending ones are sent after a restart
+func (m *Manager) Close() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+func randomHex(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}